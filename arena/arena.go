@@ -0,0 +1,71 @@
+package arena
+
+import "encoding/binary"
+
+var binLE = binary.LittleEndian
+
+// Default chunk size
+const chunkSize = 1 << 20
+
+// An arena stores keys in large byte chunks. Each key is
+// prefixed with its length (2 bytes). Chunks are plain byte
+// slices and are never scanned by the garbage collector.
+type arena struct {
+	chunks [][]byte
+	size   int // chunk size
+	used   int // bytes used by all keys
+	live   int // bytes used by live keys
+}
+
+func newArena(size int) *arena {
+	return &arena{size: size}
+}
+
+// Adds a key, returns its location
+func (a *arena) add(key []byte) (uint32, uint32) {
+	need := 2 + len(key)
+	last := len(a.chunks) - 1
+	if last < 0 || len(a.chunks[last])+need > cap(a.chunks[last]) {
+		size := a.size
+		if size < need {
+			size = need
+		}
+		a.chunks = append(a.chunks, make([]byte, 0, size))
+		last++
+	}
+
+	chunk := a.chunks[last]
+	offset := len(chunk)
+	chunk = chunk[:offset+need]
+	binLE.PutUint16(chunk[offset:], uint16(len(key)))
+	copy(chunk[offset+2:], key)
+	a.chunks[last] = chunk
+	a.used += need
+	a.live += need
+	return uint32(last), uint32(offset)
+}
+
+// Returns the key at the given location
+func (a *arena) key(chunk, offset uint32) []byte {
+	buf := a.chunks[chunk][offset:]
+	return buf[2 : 2+int(binLE.Uint16(buf))]
+}
+
+// Releases a key
+func (a *arena) release(chunk, offset uint32) {
+	a.live -= 2 + len(a.key(chunk, offset))
+}
+
+// Returns true if most of the arena is occupied by released keys
+func (a *arena) wasteful() bool {
+	garbage := a.used - a.live
+	return garbage >= a.size && garbage > a.live
+}
+
+// Returns the number of allocated bytes
+func (a *arena) allocated() (n int) {
+	for _, chunk := range a.chunks {
+		n += cap(chunk)
+	}
+	return
+}
@@ -0,0 +1,222 @@
+// Package arena implements a compact, GC-friendly KeyStore.
+//
+// Keys are held in large byte arenas and indexed by an open-addressing
+// hash table of hashes and arena offsets, so the Go heap holds almost no
+// pointers regardless of the number of keys. Tables are resized
+// incrementally: while a resize is in progress, each write migrates a
+// small number of slots to the new table, so growth never causes long
+// pauses. Resizing also compacts the arena, releasing deleted keys.
+package arena
+
+import (
+	"sync"
+
+	"github.com/bsm/rumcask"
+)
+
+// Number of slots migrated by each write during a resize
+const migrateStep = 128
+
+// Iterator allows callers to iterate the key/ref pairs.
+// When this function returns false, iteration will stop
+// immediately.
+type Iterator = rumcask.Iterator
+
+// A compact, in-memory KeyStore implementation.
+// Keys are iterable in no particular order and are held
+// in memory all the time.
+type KeyStore struct {
+	cur, old  *table
+	migrated  int // number of migrated slots of old
+	chunkSize int
+
+	lock sync.RWMutex
+}
+
+// NewKeyStore creates a new, empty store
+func NewKeyStore() *KeyStore {
+	return newKeyStore(1024, chunkSize)
+}
+
+func newKeyStore(size, chunkSize int) *KeyStore {
+	return &KeyStore{cur: newTable(size, chunkSize), chunkSize: chunkSize}
+}
+
+// Fetch retrieves the ref at key
+func (s *KeyStore) Fetch(key []byte) (rumcask.PageRef, bool) {
+	hash := hashKey(key)
+
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if i := s.cur.find(key, hash); i > -1 {
+		return s.cur.slots[i].ref, true
+	}
+	if s.old != nil {
+		if i := s.old.find(key, hash); i > -1 {
+			return s.old.slots[i].ref, true
+		}
+	}
+	return rumcask.PageRef{}, false
+}
+
+// Store stores a key/ref pair
+func (s *KeyStore) Store(key []byte, ref rumcask.PageRef) (prev rumcask.PageRef, ok bool) {
+	hash := hashKey(key)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if i := s.cur.find(key, hash); i > -1 {
+		prev, s.cur.slots[i].ref = s.cur.slots[i].ref, ref
+		return prev, true
+	}
+	if s.old != nil {
+		if i := s.old.find(key, hash); i > -1 {
+			prev, ok = s.old.remove(i), true
+		}
+	}
+
+	s.cur.insert(key, hash, ref)
+	s.maintain()
+	return
+}
+
+// Delete deletes a key
+func (s *KeyStore) Delete(key []byte) (prev rumcask.PageRef, ok bool) {
+	hash := hashKey(key)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if i := s.cur.find(key, hash); i > -1 {
+		prev, ok = s.cur.remove(i), true
+	} else if s.old != nil {
+		if i := s.old.find(key, hash); i > -1 {
+			prev, ok = s.old.remove(i), true
+		}
+	}
+	s.maintain()
+	return
+}
+
+// Len returns the number of keys in the store
+func (s *KeyStore) Len() int {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	n := s.cur.used
+	if s.old != nil {
+		n += s.old.used
+	}
+	return n
+}
+
+// ForEach iterates over all keys, in no particular order.
+// It iterates over a snapshot, writers are not blocked.
+func (s *KeyStore) ForEach(each Iterator) {
+	s.lock.RLock()
+	entries := s.cur.entries(nil)
+	if s.old != nil {
+		entries = s.old.entries(entries)
+	}
+	s.lock.RUnlock()
+
+	for _, e := range entries {
+		if !each(e.key, e.ref) {
+			return
+		}
+	}
+}
+
+// Reset implements rumcask.Resetter
+func (s *KeyStore) Reset() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.cur, s.old, s.migrated = newTable(1024, s.chunkSize), nil, 0
+	return nil
+}
+
+// Stats returns memory statistics
+func (s *KeyStore) Stats() *Stats {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	stats := new(Stats)
+	for _, t := range []*table{s.cur, s.old} {
+		if t != nil {
+			stats.Keys += t.used
+			stats.Slots += len(t.slots)
+			stats.ArenaBytes += t.arena.allocated()
+			stats.KeyBytes += t.arena.live
+		}
+	}
+	stats.Resizing = s.old != nil
+	return stats
+}
+
+// Stats contains memory statistics
+type Stats struct {
+	// Number of keys
+	Keys int
+	// Number of hash table slots
+	Slots int
+	// Number of bytes allocated for arenas
+	ArenaBytes int
+	// Number of bytes occupied by live keys
+	KeyBytes int
+	// True, if a resize is in progress
+	Resizing bool
+}
+
+// Migrates slots if a resize is in progress, starts
+// a new resize when the current table is overloaded.
+// The next resize waits for the pending one to complete,
+// the current table is sized to absorb the remaining
+// migration, so each write only ever migrates a step.
+func (s *KeyStore) maintain() {
+	if s.old != nil {
+		s.migrate(migrateStep)
+	}
+	if s.old != nil || !s.cur.overloaded() {
+		return
+	}
+
+	// Grow, unless most slots are occupied by deleted keys
+	size := len(s.cur.slots)
+	if s.cur.used >= size/4 {
+		size *= 2
+	}
+	s.old, s.cur, s.migrated = s.cur, newTable(size, s.chunkSize), 0
+}
+
+// Moves n slots from the old to the current table
+func (s *KeyStore) migrate(n int) {
+	old := s.old
+	for ; n > 0 && s.migrated < len(old.slots); n-- {
+		sl := &old.slots[s.migrated]
+		if sl.hash > hashDeleted {
+			s.cur.insert(old.arena.key(sl.chunk, sl.offset), sl.hash, sl.ref)
+			sl.hash = hashDeleted
+			old.used--
+		}
+		s.migrated++
+	}
+	if s.migrated == len(old.slots) {
+		s.old = nil
+	}
+}
+
+// FNV-1a hash, avoiding the reserved values
+func hashKey(key []byte) uint32 {
+	hash := uint32(2166136261)
+	for _, c := range key {
+		hash ^= uint32(c)
+		hash *= 16777619
+	}
+	if hash <= hashDeleted {
+		hash += 2
+	}
+	return hash
+}
@@ -0,0 +1,190 @@
+package arena
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/bsm/rumcask"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("KeyStore", func() {
+	var subject *KeyStore
+	var _ rumcask.KeyStore = subject // interface assertions
+	var _ rumcask.UnorderedIterator = subject
+	var _ rumcask.Counter = subject
+	var _ rumcask.Resetter = subject
+
+	var keyAt = func(i int) []byte {
+		return []byte(fmt.Sprintf("key%06d", i))
+	}
+
+	BeforeEach(func() {
+		subject = newKeyStore(16, 256)
+	})
+
+	It("should store/fetch/delete", func() {
+		_, ok := subject.Fetch([]byte("key1"))
+		Expect(ok).To(BeFalse())
+
+		_, ok = subject.Store([]byte("key1"), rumcask.PageRef{ID: 1, Offset: 1024})
+		Expect(ok).To(BeFalse())
+		_, ok = subject.Store([]byte("key2"), rumcask.PageRef{ID: 7, Offset: 8096})
+		Expect(ok).To(BeFalse())
+
+		ref, ok := subject.Store([]byte("key1"), rumcask.PageRef{ID: 2, Offset: 2048})
+		Expect(ok).To(BeTrue())
+		Expect(ref).To(Equal(rumcask.PageRef{ID: 1, Offset: 1024}))
+
+		ref, ok = subject.Fetch([]byte("key1"))
+		Expect(ok).To(BeTrue())
+		Expect(ref).To(Equal(rumcask.PageRef{ID: 2, Offset: 2048}))
+
+		ref, ok = subject.Delete([]byte("key2"))
+		Expect(ok).To(BeTrue())
+		Expect(ref).To(Equal(rumcask.PageRef{ID: 7, Offset: 8096}))
+		_, ok = subject.Delete([]byte("key2"))
+		Expect(ok).To(BeFalse())
+		Expect(subject.Len()).To(Equal(1))
+	})
+
+	It("should resize incrementally", func() {
+		resized := false
+		for i := 0; i < 5000; i++ {
+			_, ok := subject.Store(keyAt(i), rumcask.PageRef{ID: uint32(i)})
+			Expect(ok).To(BeFalse())
+			resized = resized || subject.old != nil
+
+			// Check a random subset while resizing
+			for _, n := range rand.Perm(i + 1)[:1] {
+				ref, ok := subject.Fetch(keyAt(n))
+				Expect(ok).To(BeTrue())
+				Expect(ref).To(Equal(rumcask.PageRef{ID: uint32(n)}))
+			}
+		}
+		Expect(resized).To(BeTrue())
+		Expect(subject.Len()).To(Equal(5000))
+		Expect(len(subject.cur.slots)).To(BeNumerically(">=", 8192))
+
+		for i := 0; i < 5000; i++ {
+			ref, ok := subject.Fetch(keyAt(i))
+			Expect(ok).To(BeTrue())
+			Expect(ref).To(Equal(rumcask.PageRef{ID: uint32(i)}))
+		}
+	})
+
+	It("should update and delete while resizing", func() {
+		model := make(map[string]rumcask.PageRef)
+		for i := 0; i < 20000; i++ {
+			key := keyAt(rand.Intn(3000))
+			if rand.Intn(3) == 0 {
+				prev, ok := subject.Delete(key)
+				exp, exists := model[string(key)]
+				Expect(ok).To(Equal(exists))
+				Expect(prev).To(Equal(exp))
+				delete(model, string(key))
+			} else {
+				ref := rumcask.PageRef{ID: uint32(i)}
+				prev, ok := subject.Store(key, ref)
+				exp, exists := model[string(key)]
+				Expect(ok).To(Equal(exists))
+				Expect(prev).To(Equal(exp))
+				model[string(key)] = ref
+			}
+		}
+		Expect(subject.Len()).To(Equal(len(model)))
+
+		found := make(map[string]rumcask.PageRef)
+		subject.ForEach(func(key []byte, ref rumcask.PageRef) bool {
+			found[string(key)] = ref
+			return true
+		})
+		Expect(found).To(Equal(model))
+	})
+
+	It("should bound migration work per write", func() {
+		write := func(fn func()) {
+			old, migrated := subject.old, subject.migrated
+			fn()
+			if old == nil {
+				return
+			} else if subject.old == old {
+				Expect(subject.migrated - migrated).To(BeNumerically("<=", migrateStep))
+			} else {
+				Expect(len(old.slots) - migrated).To(BeNumerically("<=", migrateStep))
+			}
+		}
+
+		// Large, short-lived keys make the arena wasteful while resizing
+		large := bytes.Repeat([]byte{'x'}, 4096)
+		for i := 0; i < 5000; i++ {
+			write(func() { subject.Store(keyAt(i), rumcask.PageRef{ID: uint32(i)}) })
+			if i%10 == 0 {
+				write(func() { subject.Store(large, rumcask.PageRef{ID: uint32(i)}) })
+				write(func() { subject.Delete(large) })
+			}
+		}
+		Expect(subject.Len()).To(Equal(5000))
+	})
+
+	It("should not block writers while iterating", func() {
+		subject.Store(keyAt(1), rumcask.PageRef{ID: 1})
+
+		subject.ForEach(func(key []byte, _ rumcask.PageRef) bool {
+			done := make(chan struct{})
+			go func() {
+				for i := 2; i < 1000; i++ {
+					subject.Store(keyAt(i), rumcask.PageRef{ID: uint32(i)})
+				}
+				close(done)
+			}()
+			Eventually(done).Should(BeClosed())
+			Expect(key).To(Equal(keyAt(1)))
+			return true
+		})
+		Expect(subject.Len()).To(Equal(999))
+	})
+
+	It("should compact arenas", func() {
+		for i := 0; i < 1000; i++ {
+			subject.Store(keyAt(i), rumcask.PageRef{ID: uint32(i)})
+		}
+		for i := 0; i < 1000; i++ {
+			subject.Delete(keyAt(i))
+		}
+		for i := 0; i < 5000; i++ {
+			subject.Store(keyAt(i%10), rumcask.PageRef{ID: uint32(i)})
+			subject.Delete(keyAt(i % 10))
+		}
+
+		stats := subject.Stats()
+		Expect(stats.Keys).To(Equal(0))
+		Expect(stats.KeyBytes).To(Equal(0))
+		Expect(stats.ArenaBytes).To(BeNumerically("<", 4096))
+		Expect(stats.Slots).To(BeNumerically("<=", 4096))
+	})
+
+})
+
+func BenchmarkKeyStore_Store(b *testing.B) {
+	keys := make([][]byte, b.N)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("KEY%08d", i))
+	}
+
+	store := NewKeyStore()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		store.Store(keys[i], rumcask.PageRef{ID: 1, Offset: uint32(i)})
+	}
+}
+
+/** Test hook **/
+
+func TestSuite(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "rumcask/arena")
+}
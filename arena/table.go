@@ -0,0 +1,100 @@
+package arena
+
+import (
+	"bytes"
+
+	"github.com/bsm/rumcask"
+)
+
+// Special hash values
+const (
+	hashEmpty   = 0
+	hashDeleted = 1
+)
+
+// A slot in the hash table, contains no pointers
+type slot struct {
+	hash   uint32
+	chunk  uint32
+	offset uint32
+	ref    rumcask.PageRef
+}
+
+// An open-addressing hash table with linear probing.
+// Keys are stored in the table's arena.
+type table struct {
+	slots  []slot
+	mask   uint32
+	used   int // live slots
+	filled int // live + deleted slots
+	arena  *arena
+}
+
+func newTable(size, chunkSize int) *table {
+	return &table{
+		slots: make([]slot, size),
+		mask:  uint32(size - 1),
+		arena: newArena(chunkSize),
+	}
+}
+
+// Returns true if the table exceeds its maximum load
+// or its arena is mostly garbage
+func (t *table) overloaded() bool {
+	return t.filled*4 >= len(t.slots)*3 || t.arena.wasteful()
+}
+
+// Returns the slot index of key, or -1 if not found
+func (t *table) find(key []byte, hash uint32) int {
+	for i := hash & t.mask; ; i = (i + 1) & t.mask {
+		s := &t.slots[i]
+		if s.hash == hashEmpty {
+			return -1
+		} else if s.hash == hash && bytes.Equal(t.arena.key(s.chunk, s.offset), key) {
+			return int(i)
+		}
+	}
+}
+
+// Inserts a key which is known to be absent
+func (t *table) insert(key []byte, hash uint32, ref rumcask.PageRef) {
+	i := hash & t.mask
+	for t.slots[i].hash > hashDeleted {
+		i = (i + 1) & t.mask
+	}
+
+	s := &t.slots[i]
+	if s.hash == hashEmpty {
+		t.filled++
+	}
+	s.chunk, s.offset = t.arena.add(key)
+	s.hash, s.ref = hash, ref
+	t.used++
+}
+
+// Removes the slot at index i
+func (t *table) remove(i int) rumcask.PageRef {
+	s := &t.slots[i]
+	t.arena.release(s.chunk, s.offset)
+	s.hash = hashDeleted
+	t.used--
+	return s.ref
+}
+
+// A key/ref pair of a table, the key is shared with the arena
+type entry struct {
+	key []byte
+	ref rumcask.PageRef
+}
+
+// Appends all live slots to dst. Arena chunks are append-only,
+// so the keys remain valid after the table is modified.
+func (t *table) entries(dst []entry) []entry {
+	for i := range t.slots {
+		if s := &t.slots[i]; s.hash > hashDeleted {
+			key := t.arena.key(s.chunk, s.offset)
+			dst = append(dst, entry{key: key[:len(key):len(key)], ref: s.ref})
+		}
+	}
+	return dst
+}
@@ -0,0 +1,438 @@
+package rumcask
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Name of the backup manifest file
+const manifestName = "MANIFEST"
+
+// Manifest describes the files of a backup
+type Manifest struct {
+	// Time the backup was taken
+	Created time.Time `json:"created"`
+	// Log position, all writes before it are included
+	Position Position `json:"position"`
+	// Files of the backup
+	Files []ManifestFile `json:"files"`
+}
+
+// ManifestFile describes a single backup file
+type ManifestFile struct {
+	Name  string `json:"name"`
+	Size  int64  `json:"size"`
+	CRC32 uint32 `json:"crc32"`
+
+	// CRC32 of the records, excluding the header, for pages.
+	// Pages with an unchanged body are inherited.
+	BodyCRC32 uint32 `json:"body_crc32,omitempty"`
+	// True, if the file is unchanged since the base backup
+	// and not included in this one
+	Inherited bool `json:"inherited,omitempty"`
+}
+
+// Backup writes a consistent copy of the DB to dir, without
+// blocking writes. Sealed pages are copied in full, the current
+// page up to the position at the start of the backup. Delete
+// markers set after the start are cleared in the copy.
+//
+// Pages are always copied, never hard-linked, as delete markers
+// and header stats of sealed pages are rewritten in place and
+// would modify linked copies.
+func (db *DB) Backup(dir string) (*Manifest, error) {
+	return db.BackupSince(dir, nil)
+}
+
+// BackupTo writes a consistent copy of the DB as a tar
+// stream to w. The manifest is the last entry.
+func (db *DB) BackupTo(w io.Writer) (*Manifest, error) {
+	return db.BackupSinceTo(w, nil)
+}
+
+// BackupSince writes an incremental backup to dir, which only
+// includes pages that are new or changed since the base backup.
+// A nil base creates a full backup.
+func (db *DB) BackupSince(dir string, base *Manifest) (*Manifest, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return db.backup(dirSink(dir), base)
+}
+
+// BackupSinceTo writes an incremental backup as a tar stream
+// to w. A nil base creates a full backup.
+func (db *DB) BackupSinceTo(w io.Writer, base *Manifest) (*Manifest, error) {
+	tw := tar.NewWriter(w)
+	m, err := db.backup(tarSink{tw}, base)
+	if err != nil {
+		return nil, err
+	}
+	return m, tw.Close()
+}
+
+// ReadManifest reads the manifest of a backup in dir
+func ReadManifest(dir string) (*Manifest, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, manifestName))
+	if err != nil {
+		return nil, err
+	}
+
+	m := new(Manifest)
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, ERROR_BACKUP_INVALID
+	}
+	return m, nil
+}
+
+// Restore rebuilds a DB in dir from a chain of backup directories,
+// starting with a full backup, followed by incremental ones in
+// the order they were taken. The dir must not contain any files.
+func Restore(dir string, chain ...string) error {
+	if len(chain) == 0 {
+		return ERROR_BACKUP_INVALID
+	}
+
+	manifests := make([]*Manifest, len(chain))
+	for i, src := range chain {
+		m, err := ReadManifest(src)
+		if err != nil {
+			return err
+		}
+		manifests[i] = m
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if names, err := filepath.Glob(filepath.Join(dir, "*")); err != nil {
+		return err
+	} else if len(names) != 0 {
+		return &os.PathError{Op: "restore", Path: dir, Err: os.ErrExist}
+	}
+
+	last := len(chain) - 1
+	for _, mf := range manifests[last].Files {
+		src := findBackupFile(chain, manifests, mf)
+		if src == "" {
+			return ERROR_BACKUP_INVALID
+		}
+		if err := restoreFile(filepath.Join(src, mf.Name), filepath.Join(dir, mf.Name), mf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// VerifyBackup validates the files of a backup in dir
+// against its manifest
+func VerifyBackup(dir string) (*Manifest, error) {
+	m, err := ReadManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, mf := range m.Files {
+		if mf.Inherited {
+			continue
+		}
+
+		file, err := os.Open(filepath.Join(dir, mf.Name))
+		if err != nil {
+			return nil, err
+		}
+
+		hash := crc32.NewIEEE()
+		n, err := io.Copy(hash, file)
+		file.Close()
+		if err != nil {
+			return nil, err
+		} else if n != mf.Size || hash.Sum32() != mf.CRC32 {
+			return nil, ERROR_BACKUP_INVALID
+		}
+	}
+	return m, nil
+}
+
+// Writes all backup files and the manifest to the sink. Pages
+// of the same size and body checksum as in base are inherited.
+func (db *DB) backup(sink backupSink, base *Manifest) (*Manifest, error) {
+	inherit := make(map[string]ManifestFile)
+	if base != nil {
+		for _, mf := range base.Files {
+			inherit[mf.Name] = mf
+		}
+	}
+
+	marks := &markSet{refs: make(map[PageRef]struct{})}
+	pos, pages := db.snapshotPagesWithMarks(marks)
+	defer releasePages(pages)
+	defer db.unregisterMarks(marks)
+
+	m := &Manifest{Created: time.Now().UTC(), Position: pos}
+
+	for _, ps := range pages {
+		name := filepath.Base(ps.page.file.Name())
+		if bf, ok := inherit[name]; ok && bf.Size == int64(ps.size) && bf.BodyCRC32 != 0 {
+			// Delete markers may have changed, compare the body
+			hash := crc32.NewIEEE()
+			if _, err := io.Copy(hash, ps.body(marks)); err != nil {
+				return nil, err
+			}
+			if hash.Sum32() == bf.BodyCRC32 {
+				bf.Inherited = true
+				m.Files = append(m.Files, bf)
+				continue
+			}
+		}
+
+		body := crc32.NewIEEE()
+		r := io.MultiReader(bytes.NewReader(ps.header), io.TeeReader(ps.body(marks), body))
+		mf, err := addBackupFile(sink, name, int64(ps.size), r)
+		if err != nil {
+			return nil, err
+		}
+		mf.BodyCRC32 = body.Sum32()
+		m.Files = append(m.Files, mf)
+	}
+
+	meta, err := ioutil.ReadFile(filepath.Join(db.dir, "META"))
+	if err == nil {
+		mf, err := addBackupFile(sink, "META", int64(len(meta)), bytes.NewReader(meta))
+		if err != nil {
+			return nil, err
+		}
+		m.Files = append(m.Files, mf)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := sink.add(manifestName, int64(len(data)), bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// A page, its header and size at the time of a snapshot
+type pageSnapshot struct {
+	page   *Page
+	header []byte
+	size   uint32
+}
+
+// Reads the records of the page as of the snapshot, markers in
+// marks are cleared. Headers are rewritten in place, see header.
+func (ps pageSnapshot) body(marks *markSet) io.Reader {
+	return &unmarkReader{
+		r:     io.NewSectionReader(ps.page.file, PAGE_HEADER_LEN, int64(ps.size)-PAGE_HEADER_LEN),
+		pos:   PAGE_HEADER_LEN,
+		id:    ps.page.id,
+		marks: marks,
+	}
+}
+
+// Returns the log position and all pages, ordered by ID.
+// Pages must be released after use, see releasePages.
+func (db *DB) snapshotPages() (Position, []pageSnapshot) {
+	return db.snapshotPagesWithMarks(nil)
+}
+
+// Like snapshotPages, registers marks to collect delete
+// markers which are set after the snapshot
+func (db *DB) snapshotPagesWithMarks(marks *markSet) (Position, []pageSnapshot) {
+	db.cLock.Lock()
+	defer db.cLock.Unlock()
+
+	if marks != nil {
+		if db.marks == nil {
+			db.marks = make(map[*markSet]struct{})
+		}
+		db.marks[marks] = struct{}{}
+	}
+
+	db.pLock.RLock()
+	defer db.pLock.RUnlock()
+
+	pos := db.position()
+	refs := checkpointPages(db.pages, pos)
+	pages := make([]pageSnapshot, 0, len(refs))
+	for _, ref := range refs {
+		page := db.pages[ref.ID]
+		page.acquire()
+		pages = append(pages, pageSnapshot{page: page, header: page.header.encode(), size: ref.Offset})
+	}
+	return pos, pages
+}
+
+// Releases the pages of a snapshot
+func releasePages(pages []pageSnapshot) {
+	for _, ps := range pages {
+		ps.page.release()
+	}
+}
+
+// Stops collecting delete markers
+func (db *DB) unregisterMarks(marks *markSet) {
+	db.cLock.Lock()
+	defer db.cLock.Unlock()
+
+	delete(db.marks, marks)
+}
+
+// Sets the delete marker of the record at ref, records it
+// for running backups. Requires cLock.
+func (db *DB) markDeleted(ref PageRef) error {
+	for marks := range db.marks {
+		marks.add(ref)
+	}
+	return db.page(ref.ID).delete(ref.Offset)
+}
+
+// Records with delete markers set after a snapshot
+type markSet struct {
+	refs map[PageRef]struct{}
+	lock sync.Mutex
+}
+
+func (s *markSet) add(ref PageRef) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.refs[ref] = struct{}{}
+}
+
+// Returns the offsets of marked records in a page
+func (s *markSet) offsets(id uint32) []uint32 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var offsets []uint32
+	for ref := range s.refs {
+		if ref.ID == id {
+			offsets = append(offsets, ref.Offset)
+		}
+	}
+	return offsets
+}
+
+// Clears the delete markers of a set in the page data read
+// from r. Marks are checked after each read, a marker which
+// was read is always included in the set.
+type unmarkReader struct {
+	r     io.Reader
+	pos   int64 // page offset of the next byte
+	id    uint32
+	marks *markSet
+}
+
+func (u *unmarkReader) Read(p []byte) (int, error) {
+	n, err := u.r.Read(p)
+	for _, offset := range u.marks.offsets(u.id) {
+		if i := int64(offset) + OH_KV - 1 - u.pos; i >= 0 && i < int64(n) {
+			p[i] &^= 0x80
+		}
+	}
+	u.pos += int64(n)
+	return n, err
+}
+
+// Copies a file to the sink, returns its manifest entry
+func addBackupFile(sink backupSink, name string, size int64, r io.Reader) (ManifestFile, error) {
+	hash := crc32.NewIEEE()
+	if err := sink.add(name, size, io.TeeReader(r, hash)); err != nil {
+		return ManifestFile{}, err
+	}
+	return ManifestFile{Name: name, Size: size, CRC32: hash.Sum32()}, nil
+}
+
+// Returns the latest backup in the chain, which includes the file
+func findBackupFile(chain []string, manifests []*Manifest, want ManifestFile) string {
+	for i := len(chain) - 1; i >= 0; i-- {
+		for _, mf := range manifests[i].Files {
+			if mf.Name == want.Name && !mf.Inherited && mf.Size == want.Size && mf.CRC32 == want.CRC32 {
+				return chain[i]
+			}
+		}
+	}
+	return ""
+}
+
+// Copies a backup file, validates the checksum
+func restoreFile(src, dst string, mf ManifestFile) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	hash := crc32.NewIEEE()
+	n, err := io.Copy(io.MultiWriter(out, hash), in)
+	if err != nil {
+		return err
+	} else if n != mf.Size || hash.Sum32() != mf.CRC32 {
+		return ERROR_BACKUP_INVALID
+	}
+	return out.Sync()
+}
+
+// --------------------------------------------------------------------
+
+// Destination of backup files
+type backupSink interface {
+	// add writes a file of the given size
+	add(name string, size int64, r io.Reader) error
+}
+
+// Writes backup files to a directory
+type dirSink string
+
+func (s dirSink) add(name string, size int64, r io.Reader) error {
+	file, err := os.Create(filepath.Join(string(s), name))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if n, err := io.Copy(file, r); err != nil {
+		return err
+	} else if n != size {
+		return io.ErrUnexpectedEOF
+	}
+	return file.Sync()
+}
+
+// Writes backup files to a tar stream
+type tarSink struct {
+	w *tar.Writer
+}
+
+func (s tarSink) add(name string, size int64, r io.Reader) error {
+	if err := s.w.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    size,
+		ModTime: time.Now(),
+	}); err != nil {
+		return err
+	}
+	_, err := io.Copy(s.w, r)
+	return err
+}
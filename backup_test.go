@@ -0,0 +1,219 @@
+package rumcask
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Backup", func() {
+	var subject *DB
+	var backupDir string
+
+	var set = func(key, value string) {
+		_, err := subject.Set([]byte(key), []byte(value))
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	BeforeEach(func() {
+		var err error
+		subject, err = Open(filepath.Join(testDir, "db"), NewHashKeyStore())
+		Expect(err).NotTo(HaveOccurred())
+		backupDir = filepath.Join(testDir, "backup")
+
+		set("key1", "val1")
+		set("key2", "val2")
+		Expect(subject.nextPage()).NotTo(HaveOccurred())
+		set("key3", "val3")
+	})
+
+	AfterEach(func() {
+		subject.Close()
+	})
+
+	It("should copy a consistent state while writing", func() {
+		var wg sync.WaitGroup
+		stop := make(chan struct{})
+		wg.Add(1)
+		go func() {
+			defer GinkgoRecover()
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				_, err := subject.Set([]byte(fmt.Sprintf("bg%d", i)), []byte("value"))
+				Expect(err).NotTo(HaveOccurred())
+			}
+		}()
+
+		m, err := subject.Backup(backupDir)
+		close(stop)
+		wg.Wait()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(m.Files).To(HaveLen(2))
+		Expect(m.Files[0].Name).To(Equal("00000000.rcp"))
+		Expect(m.Files[1].Size).To(Equal(int64(m.Position.Offset)))
+
+		v, err := VerifyBackup(backupDir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(v.Position).To(Equal(m.Position))
+
+		db, err := Open(backupDir, NewHashKeyStore())
+		Expect(err).NotTo(HaveOccurred())
+		defer db.Close()
+		Expect(db.Position()).To(Equal(m.Position))
+		val, err := db.Get([]byte("key3"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(val).To(Equal([]byte("val3")))
+	})
+
+	It("should clear delete markers set after the snapshot", func() {
+		_, err := subject.Delete([]byte("key1"))
+		Expect(err).NotTo(HaveOccurred())
+
+		marks := &markSet{refs: make(map[PageRef]struct{})}
+		_, pages := subject.snapshotPagesWithMarks(marks)
+		defer releasePages(pages)
+		defer subject.unregisterMarks(marks)
+
+		_, err = subject.Delete([]byte("key2"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(marks.offsets(0)).To(Equal([]uint32{144}))
+
+		data, err := ioutil.ReadAll(pages[0].body(marks))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(data).To(HaveLen(32))
+		Expect(data[OH_KV-1] & 0x80).NotTo(BeZero()) // key1, deleted before
+		Expect(data[16+OH_KV-1] & 0x80).To(BeZero()) // key2, deleted after
+	})
+
+	It("should detect corruption", func() {
+		_, err := subject.Backup(backupDir)
+		Expect(err).NotTo(HaveOccurred())
+
+		fname := filepath.Join(backupDir, "00000001.rcp")
+		data, err := ioutil.ReadFile(fname)
+		Expect(err).NotTo(HaveOccurred())
+		data[len(data)-3] ^= 0xff
+		Expect(ioutil.WriteFile(fname, data, 0644)).NotTo(HaveOccurred())
+
+		_, err = VerifyBackup(backupDir)
+		Expect(err).To(Equal(ERROR_BACKUP_INVALID))
+
+		Expect(os.Remove(fname)).NotTo(HaveOccurred())
+		_, err = VerifyBackup(backupDir)
+		Expect(os.IsNotExist(err)).To(BeTrue())
+	})
+
+	It("should write tar streams", func() {
+		buf := new(bytes.Buffer)
+		m, err := subject.BackupTo(buf)
+		Expect(err).NotTo(HaveOccurred())
+
+		var names []string
+		var last []byte
+		tr := tar.NewReader(buf)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			Expect(err).NotTo(HaveOccurred())
+			names = append(names, hdr.Name)
+
+			last, err = ioutil.ReadAll(tr)
+			Expect(err).NotTo(HaveOccurred())
+			if i := len(names) - 1; i < len(m.Files) {
+				Expect(crc32.ChecksumIEEE(last)).To(Equal(m.Files[i].CRC32))
+			}
+		}
+		Expect(names).To(Equal([]string{"00000000.rcp", "00000001.rcp", "MANIFEST"}))
+
+		var decoded Manifest
+		Expect(json.Unmarshal(last, &decoded)).NotTo(HaveOccurred())
+		Expect(decoded.Files).To(Equal(m.Files))
+	})
+
+	It("should back up incrementally and restore chains", func() {
+		full := filepath.Join(testDir, "full")
+		base, err := subject.Backup(full)
+		Expect(err).NotTo(HaveOccurred())
+
+		// Page 0 is unchanged, page 1 grows, page 2 is new
+		set("key4", "val4")
+		Expect(subject.nextPage()).NotTo(HaveOccurred())
+		set("key5", "val5")
+
+		inc1 := filepath.Join(testDir, "inc1")
+		m1, err := subject.BackupSince(inc1, base)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(m1.Files).To(HaveLen(3))
+		Expect(m1.Files[0].Inherited).To(BeTrue())
+		Expect(m1.Files[1].Inherited).To(BeFalse())
+		Expect(filepath.Join(inc1, "00000000.rcp")).NotTo(BeAnExistingFile())
+		Expect(filepath.Join(inc1, "00000002.rcp")).To(BeAnExistingFile())
+		_, err = VerifyBackup(inc1)
+		Expect(err).NotTo(HaveOccurred())
+
+		// Deletes mark records in page 0
+		_, err = subject.Delete([]byte("key1"))
+		Expect(err).NotTo(HaveOccurred())
+
+		inc2 := filepath.Join(testDir, "inc2")
+		m2, err := subject.BackupSince(inc2, m1)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(m2.Files[0].Inherited).To(BeFalse())
+		Expect(m2.Files[0].BodyCRC32).NotTo(Equal(m1.Files[0].BodyCRC32))
+		Expect(m2.Files[1].Inherited).To(BeTrue())
+
+		// Markers change the body, even if header stats lag
+		Expect(subject.markDeleted(PageRef{ID: 1, Offset: 128})).To(Succeed())
+		subject.page(1).header.Stats.Deleted = 0
+		m3, err := subject.BackupSince(filepath.Join(testDir, "inc3"), m2)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(m3.Files[1].Inherited).To(BeFalse())
+
+		restored := filepath.Join(testDir, "restored")
+		Expect(Restore(restored, full, inc1, inc2)).NotTo(HaveOccurred())
+		Expect(Restore(restored, full, inc1, inc2)).To(HaveOccurred())
+
+		db, err := Open(restored, NewHashKeyStore())
+		Expect(err).NotTo(HaveOccurred())
+		defer db.Close()
+		Expect(db.Position()).To(Equal(m2.Position))
+		_, err = db.Get([]byte("key1"))
+		Expect(err).To(Equal(ERROR_NOT_FOUND))
+		for _, key := range []string{"key2", "key3", "key4", "key5"} {
+			_, err = db.Get([]byte(key))
+			Expect(err).NotTo(HaveOccurred())
+		}
+	})
+
+	It("should reject broken chains", func() {
+		full := filepath.Join(testDir, "full")
+		base, err := subject.Backup(full)
+		Expect(err).NotTo(HaveOccurred())
+		set("key4", "val4")
+
+		inc := filepath.Join(testDir, "inc")
+		_, err = subject.BackupSince(inc, base)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(Restore(filepath.Join(testDir, "r1"), inc)).To(Equal(ERROR_BACKUP_INVALID))
+		Expect(Restore(filepath.Join(testDir, "r2"))).To(Equal(ERROR_BACKUP_INVALID))
+	})
+
+})
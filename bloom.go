@@ -0,0 +1,240 @@
+package rumcask
+
+import (
+	"bytes"
+	"hash/crc32"
+	"io/ioutil"
+	"math"
+	"os"
+	"strings"
+	"sync"
+)
+
+var _BLOOM_MAGIC = []byte{'R', 'U', 'M', 'C', 'B', 'L', 'M'}
+
+// Initial capacity of a page bloom filter, each
+// additional stage doubles the capacity
+const bloomInitialCapacity = 1 << 14
+
+// BloomStats contains bloom filter metrics
+type BloomStats struct {
+	// Number of lookups checked against bloom filters
+	Lookups uint64
+	// Number of lookups which were avoided
+	Avoided uint64
+}
+
+// A simple bloom filter, using double hashing
+type bloomFilter struct {
+	bits     []uint64
+	k        uint32
+	count    uint32
+	capacity uint32
+}
+
+func newBloomFilter(capacity int, fpRate float64) *bloomFilter {
+	m := math.Ceil(-float64(capacity) * math.Log(fpRate) / (math.Ln2 * math.Ln2))
+	k := math.Ceil(m / float64(capacity) * math.Ln2)
+	return &bloomFilter{
+		bits:     make([]uint64, (uint64(m)+63)/64),
+		k:        uint32(k),
+		capacity: uint32(capacity),
+	}
+}
+
+func (f *bloomFilter) add(h1, h2 uint64) {
+	nbits := uint64(len(f.bits)) * 64
+	for i := uint64(0); i < uint64(f.k); i++ {
+		bit := (h1 + i*h2) % nbits
+		f.bits[bit/64] |= 1 << (bit % 64)
+	}
+	f.count++
+}
+
+func (f *bloomFilter) has(h1, h2 uint64) bool {
+	nbits := uint64(len(f.bits)) * 64
+	for i := uint64(0); i < uint64(f.k); i++ {
+		bit := (h1 + i*h2) % nbits
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns two independent hashes for a key
+func bloomHash(key []byte) (uint64, uint64) {
+	h1 := uint64(14695981039346656037)
+	for _, c := range key {
+		h1 ^= uint64(c)
+		h1 *= 1099511628211
+	}
+
+	// Derive the second hash using a splitmix64 finalizer
+	h2 := h1 + 0x9e3779b97f4a7c15
+	h2 = (h2 ^ (h2 >> 30)) * 0xbf58476d1ce4e5b9
+	h2 = (h2 ^ (h2 >> 27)) * 0x94d049bb133111eb
+	h2 ^= h2 >> 31
+	return h1, h2 | 1
+}
+
+// A scalable bloom filter for a page. Whenever a stage reaches its
+// capacity, a new stage is added with twice the capacity and a tighter
+// false-positive rate, so the combined rate stays below the target.
+type pageBloom struct {
+	stages []*bloomFilter
+	fpRate float64
+	offset uint32 // page offset covered by the filter
+	lock   sync.RWMutex
+}
+
+func newPageBloom(fpRate float64) *pageBloom {
+	return &pageBloom{fpRate: fpRate, offset: PAGE_HEADER_LEN}
+}
+
+// Adds a key, marks the page as covered up to offset
+func (b *pageBloom) add(key []byte, offset uint32) {
+	h1, h2 := bloomHash(key)
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	last := len(b.stages) - 1
+	if last < 0 || b.stages[last].count >= b.stages[last].capacity {
+		capacity := bloomInitialCapacity << uint(len(b.stages))
+		rate := b.fpRate / math.Pow(2, float64(len(b.stages)+1))
+		b.stages = append(b.stages, newBloomFilter(capacity, rate))
+		last++
+	}
+	b.stages[last].add(h1, h2)
+	b.offset = offset
+}
+
+// Returns true if the page may contain the key
+func (b *pageBloom) has(h1, h2 uint64) bool {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	for _, f := range b.stages {
+		if f.has(h1, h2) {
+			return true
+		}
+	}
+	return false
+}
+
+// Each bloom file contains:
+//
+// 	MAGIC WORD        7 bytes
+// 	VERSION           1 byte
+// 	COVERED OFFSET    4 bytes
+// 	STAGE COUNT       4 bytes
+// 	STAGES            variable
+// 	CRC-32            4 bytes
+//
+// Stages are encoded as number of hash functions (4 bytes),
+// count (4 bytes), capacity (4 bytes), number of words (4 bytes)
+// followed by the words (8 bytes each).
+func (b *pageBloom) write(fname string) error {
+	b.lock.RLock()
+	size := 16 + 4
+	for _, f := range b.stages {
+		size += 16 + len(f.bits)*8
+	}
+
+	buf := make([]byte, size)
+	copy(buf, _BLOOM_MAGIC)
+	buf[7] = VERSION
+	binLE.PutUint32(buf[8:], b.offset)
+	binLE.PutUint32(buf[12:], uint32(len(b.stages)))
+
+	pos := 16
+	for _, f := range b.stages {
+		binLE.PutUint32(buf[pos:], f.k)
+		binLE.PutUint32(buf[pos+4:], f.count)
+		binLE.PutUint32(buf[pos+8:], f.capacity)
+		binLE.PutUint32(buf[pos+12:], uint32(len(f.bits)))
+		pos += 16
+		for _, w := range f.bits {
+			binLE.PutUint64(buf[pos:], w)
+			pos += 8
+		}
+	}
+	b.lock.RUnlock()
+
+	binLE.PutUint32(buf[pos:], crc32.ChecksumIEEE(buf[:pos]))
+
+	tmp := fname + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, fname)
+}
+
+// Reads a bloom file
+func readPageBloom(fname string, fpRate float64) (*pageBloom, error) {
+	buf, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	} else if len(buf) < 20 || !bytes.Equal(buf[:7], _BLOOM_MAGIC) || buf[7] != VERSION {
+		return nil, ERROR_BLOOM_INVALID
+	}
+
+	end := len(buf) - 4
+	if crc32.ChecksumIEEE(buf[:end]) != binLE.Uint32(buf[end:]) {
+		return nil, ERROR_BLOOM_INVALID
+	}
+
+	b := newPageBloom(fpRate)
+	b.offset = binLE.Uint32(buf[8:])
+	n := int(binLE.Uint32(buf[12:]))
+
+	pos := 16
+	for i := 0; i < n; i++ {
+		if pos+16 > end {
+			return nil, ERROR_BLOOM_INVALID
+		}
+		f := &bloomFilter{
+			k:        binLE.Uint32(buf[pos:]),
+			count:    binLE.Uint32(buf[pos+4:]),
+			capacity: binLE.Uint32(buf[pos+8:]),
+			bits:     make([]uint64, binLE.Uint32(buf[pos+12:])),
+		}
+		pos += 16
+		if len(f.bits) == 0 || pos+len(f.bits)*8 > end {
+			return nil, ERROR_BLOOM_INVALID
+		}
+		for j := range f.bits {
+			f.bits[j] = binLE.Uint64(buf[pos:])
+			pos += 8
+		}
+		b.stages = append(b.stages, f)
+	}
+	return b, nil
+}
+
+// Returns the bloom file name for a page
+func bloomName(page *Page) string {
+	return strings.TrimSuffix(page.file.Name(), ".rcp") + ".rcb"
+}
+
+// Loads or builds the bloom filter of a page
+func loadPageBloom(page *Page, fpRate float64) (*pageBloom, error) {
+	b, err := readPageBloom(bloomName(page), fpRate)
+	if err != nil || b.offset > page.pos() {
+		b = newPageBloom(fpRate)
+	}
+
+	// Add records which are not yet covered
+	iter := newPageIterator(page)
+	for iter.Seek(b.offset); iter.Valid(); iter.Next() {
+		if len(iter.value) != 0 {
+			b.add(iter.key, iter.pos)
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+	b.offset = page.pos()
+	return b, nil
+}
@@ -0,0 +1,155 @@
+package rumcask
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("bloomFilter", func() {
+
+	It("should have no false negatives and few false positives", func() {
+		f := newBloomFilter(1000, 0.01)
+		for i := 0; i < 1000; i++ {
+			f.add(bloomHash([]byte(fmt.Sprintf("key%d", i))))
+		}
+		for i := 0; i < 1000; i++ {
+			Expect(f.has(bloomHash([]byte(fmt.Sprintf("key%d", i))))).To(BeTrue())
+		}
+
+		fp := 0
+		for i := 0; i < 10000; i++ {
+			if f.has(bloomHash([]byte(fmt.Sprintf("other%d", i)))) {
+				fp++
+			}
+		}
+		Expect(fp).To(BeNumerically("<", 300))
+	})
+
+})
+
+var _ = Describe("pageBloom", func() {
+
+	It("should add stages when full", func() {
+		b := newPageBloom(0.01)
+		for i := 0; i < bloomInitialCapacity+1; i++ {
+			b.add([]byte(fmt.Sprintf("key%d", i)), uint32(i))
+		}
+		Expect(b.stages).To(HaveLen(2))
+		Expect(b.stages[1].capacity).To(Equal(uint32(bloomInitialCapacity * 2)))
+		Expect(b.has(bloomHash([]byte("key0")))).To(BeTrue())
+		Expect(b.has(bloomHash([]byte(fmt.Sprintf("key%d", bloomInitialCapacity))))).To(BeTrue())
+	})
+
+	It("should write and read", func() {
+		fname := filepath.Join(testDir, "00000000.rcb")
+		b := newPageBloom(0.01)
+		b.add([]byte("key1"), 144)
+		b.add([]byte("key2"), 160)
+		Expect(b.write(fname)).NotTo(HaveOccurred())
+
+		r, err := readPageBloom(fname, 0.01)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.offset).To(Equal(uint32(160)))
+		Expect(r.stages).To(Equal(b.stages))
+	})
+
+	It("should reject corrupt files", func() {
+		fname := filepath.Join(testDir, "00000000.rcb")
+		b := newPageBloom(0.01)
+		b.add([]byte("key1"), 144)
+		Expect(b.write(fname)).NotTo(HaveOccurred())
+
+		data, err := ioutil.ReadFile(fname)
+		Expect(err).NotTo(HaveOccurred())
+		data[30] ^= 0xff
+		Expect(ioutil.WriteFile(fname, data, 0644)).NotTo(HaveOccurred())
+
+		_, err = readPageBloom(fname, 0.01)
+		Expect(err).To(Equal(ERROR_BLOOM_INVALID))
+	})
+
+})
+
+var _ = Describe("DB with bloom filters", func() {
+	var subject *DB
+	var opts = &Options{BloomFalsePositiveRate: 0.01}
+
+	var set = func(key, value string) {
+		_, err := subject.Set([]byte(key), []byte(value))
+		Expect(err).NotTo(HaveOccurred())
+	}
+	var reopen = func() {
+		db, err := OpenWithOptions(testDir, &persistentTestKeyStore{HashKeyStore: NewHashKeyStore()}, opts)
+		Expect(err).NotTo(HaveOccurred())
+		subject = db
+	}
+
+	BeforeEach(func() {
+		reopen()
+		set("key1", "val1")
+		Expect(subject.nextPage()).NotTo(HaveOccurred())
+		set("key2", "val2")
+	})
+
+	AfterEach(func() {
+		subject.Close()
+	})
+
+	It("should avoid lookups of absent keys", func() {
+		val, err := subject.Get([]byte("key1"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(val)).To(Equal("val1"))
+
+		_, err = subject.Get([]byte("missing"))
+		Expect(err).To(Equal(ERROR_NOT_FOUND))
+		Expect(subject.BloomStats()).To(Equal(BloomStats{Lookups: 2, Avoided: 1}))
+	})
+
+	It("should not check filters of in-memory key stores", func() {
+		Expect(subject.Close()).NotTo(HaveOccurred())
+		db, err := OpenWithOptions(testDir, NewHashKeyStore(), opts)
+		Expect(err).NotTo(HaveOccurred())
+		subject = db
+
+		_, err = subject.Get([]byte("key1"))
+		Expect(err).NotTo(HaveOccurred())
+		_, err = subject.Get([]byte("missing"))
+		Expect(err).To(Equal(ERROR_NOT_FOUND))
+		Expect(subject.BloomStats()).To(Equal(BloomStats{}))
+
+		set("key3", "val3")
+		Expect(subject.Close()).NotTo(HaveOccurred())
+		reopen()
+		Expect(subject.Get([]byte("key3"))).To(Equal([]byte("val3")))
+	})
+
+	It("should seal filters when pages rotate", func() {
+		Expect(filepath.Join(testDir, "00000000.rcb")).To(BeAnExistingFile())
+		Expect(filepath.Join(testDir, "00000001.rcb")).NotTo(BeAnExistingFile())
+	})
+
+	It("should persist and extend filters on reopen", func() {
+		Expect(subject.Close()).NotTo(HaveOccurred())
+		b, err := readPageBloom(filepath.Join(testDir, "00000001.rcb"), 0.01)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(b.has(bloomHash([]byte("key2")))).To(BeTrue())
+
+		reopen()
+		set("key3", "val3")
+		Expect(subject.Close()).NotTo(HaveOccurred())
+
+		// remove the filter, records must be re-added from the page
+		Expect(ioutil.WriteFile(filepath.Join(testDir, "00000001.rcb"), []byte("junk"), 0644)).NotTo(HaveOccurred())
+		reopen()
+		for _, key := range []string{"key1", "key2", "key3"} {
+			_, err := subject.Get([]byte(key))
+			Expect(err).NotTo(HaveOccurred())
+		}
+		Expect(subject.BloomStats().Avoided).To(Equal(uint64(0)))
+	})
+
+})
@@ -0,0 +1,210 @@
+package bptree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+
+	"github.com/bsm/rumcask"
+)
+
+var binLE = binary.LittleEndian
+
+// Each node is stored in a fixed-size block:
+//
+// 	KIND              1 byte
+// 	ENTRY COUNT       2 bytes
+// 	NEXT/FIRST CHILD  4 bytes
+// 	ENTRIES           ...
+//
+// Leaf entries are encoded as key length (2 bytes), key,
+// page ID (4 bytes) and page offset (4 bytes). Branch
+// entries are encoded as key length (2 bytes), key and the
+// ID of the child node holding keys >= key (4 bytes).
+const (
+	nodeSize       = 4096
+	nodeHeaderLen  = 7
+	leafEntryOH    = 2 + 8
+	branchEntryOH  = 2 + 4
+	kindLeaf       = 1
+	kindBranch     = 2
+	maxNodePayload = nodeSize - nodeHeaderLen
+)
+
+// A decoded node
+type node struct {
+	id    uint32
+	leaf  bool
+	dirty bool
+
+	keys [][]byte
+	refs []rumcask.PageRef // leaf nodes only
+
+	// For leaf nodes, next holds the ID of the next leaf (0 = none).
+	// For branch nodes, children[0] holds keys < keys[0] and
+	// children[i+1] holds keys >= keys[i].
+	next     uint32
+	children []uint32
+}
+
+// Returns the encoded size of the node
+func (n *node) size() int {
+	size := nodeHeaderLen
+	for _, key := range n.keys {
+		if n.leaf {
+			size += leafEntryOH + len(key)
+		} else {
+			size += branchEntryOH + len(key)
+		}
+	}
+	return size
+}
+
+// Returns the index of the first key >= key
+// and true if the key is an exact match
+func (n *node) find(key []byte) (int, bool) {
+	i := sort.Search(len(n.keys), func(i int) bool {
+		return bytes.Compare(n.keys[i], key) >= 0
+	})
+	return i, i < len(n.keys) && bytes.Equal(n.keys[i], key)
+}
+
+// Returns the child index to follow for key
+func (n *node) child(key []byte) int {
+	return sort.Search(len(n.keys), func(i int) bool {
+		return bytes.Compare(n.keys[i], key) > 0
+	})
+}
+
+// Returns the index at which to split the node
+// so that both halves have similar encoded sizes
+func (n *node) splitAt() int {
+	half, size := (n.size()-nodeHeaderLen)/2, 0
+	for i, key := range n.keys {
+		if n.leaf {
+			size += leafEntryOH + len(key)
+		} else {
+			size += branchEntryOH + len(key)
+		}
+		if size >= half {
+			if i == 0 {
+				return 1
+			}
+			return i
+		}
+	}
+	return len(n.keys) / 2
+}
+
+func (n *node) insertLeaf(i int, key []byte, ref rumcask.PageRef) {
+	n.keys = append(n.keys, nil)
+	copy(n.keys[i+1:], n.keys[i:])
+	n.keys[i] = key
+
+	n.refs = append(n.refs, rumcask.PageRef{})
+	copy(n.refs[i+1:], n.refs[i:])
+	n.refs[i] = ref
+}
+
+func (n *node) removeLeaf(i int) {
+	n.keys = append(n.keys[:i], n.keys[i+1:]...)
+	n.refs = append(n.refs[:i], n.refs[i+1:]...)
+}
+
+func (n *node) insertBranch(i int, key []byte, child uint32) {
+	n.keys = append(n.keys, nil)
+	copy(n.keys[i+1:], n.keys[i:])
+	n.keys[i] = key
+
+	n.children = append(n.children, 0)
+	copy(n.children[i+2:], n.children[i+1:])
+	n.children[i+1] = child
+}
+
+// Encodes the node into buf
+func (n *node) encode(buf []byte) {
+	for i := range buf {
+		buf[i] = 0
+	}
+
+	buf[0] = kindBranch
+	if n.leaf {
+		buf[0] = kindLeaf
+	}
+	binLE.PutUint16(buf[1:], uint16(len(n.keys)))
+	if n.leaf {
+		binLE.PutUint32(buf[3:], n.next)
+	} else {
+		binLE.PutUint32(buf[3:], n.children[0])
+	}
+
+	pos := nodeHeaderLen
+	for i, key := range n.keys {
+		binLE.PutUint16(buf[pos:], uint16(len(key)))
+		pos += 2
+		pos += copy(buf[pos:], key)
+		if n.leaf {
+			binLE.PutUint32(buf[pos:], n.refs[i].ID)
+			binLE.PutUint32(buf[pos+4:], n.refs[i].Offset)
+			pos += 8
+		} else {
+			binLE.PutUint32(buf[pos:], n.children[i+1])
+			pos += 4
+		}
+	}
+}
+
+// Decodes the node from buf
+func (n *node) decode(buf []byte) error {
+	switch buf[0] {
+	case kindLeaf:
+		n.leaf = true
+	case kindBranch:
+		n.leaf = false
+	default:
+		return errBadNode
+	}
+
+	count := int(binLE.Uint16(buf[1:]))
+	n.keys = make([][]byte, 0, count)
+	if n.leaf {
+		n.next = binLE.Uint32(buf[3:])
+		n.refs = make([]rumcask.PageRef, 0, count)
+	} else {
+		n.children = make([]uint32, 1, count+1)
+		n.children[0] = binLE.Uint32(buf[3:])
+	}
+
+	pos := nodeHeaderLen
+	for i := 0; i < count; i++ {
+		if pos+2 > len(buf) {
+			return errBadNode
+		}
+		klen := int(binLE.Uint16(buf[pos:]))
+		pos += 2
+
+		end := pos + klen + branchEntryOH - 2
+		if n.leaf {
+			end = pos + klen + leafEntryOH - 2
+		}
+		if klen > rumcask.MAX_KEY_LEN || end > len(buf) {
+			return errBadNode
+		}
+
+		key := make([]byte, klen)
+		copy(key, buf[pos:])
+		n.keys = append(n.keys, key)
+		pos += klen
+
+		if n.leaf {
+			n.refs = append(n.refs, rumcask.PageRef{
+				ID:     binLE.Uint32(buf[pos:]),
+				Offset: binLE.Uint32(buf[pos+4:]),
+			})
+		} else {
+			n.children = append(n.children, binLE.Uint32(buf[pos:]))
+		}
+		pos = end
+	}
+	return nil
+}
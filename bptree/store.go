@@ -0,0 +1,526 @@
+// Package bptree implements a disk-backed KeyStore, for key sets which
+// do not fit in memory.
+//
+// Keys are stored in a B+tree file, which is organised in fixed-size
+// nodes. Only a bounded number of nodes is cached in memory at any time.
+// Underfull nodes are not merged on deletion, space is reclaimed when
+// the store is reset.
+package bptree
+
+import (
+	"bytes"
+	"container/list"
+	"errors"
+	"os"
+	"sync"
+
+	"github.com/bsm/rumcask"
+)
+
+var (
+	errBadHeader = errors.New("bptree: invalid file header")
+	errBadNode   = errors.New("bptree: invalid node")
+	errClosed    = errors.New("bptree: store is closed")
+)
+
+var _MAGIC = []byte{'R', 'U', 'M', 'C', 'B', 'P', 'T'}
+
+const version uint8 = 1
+
+// The first node of each file holds the meta data:
+//
+// 	MAGIC WORD        7 bytes
+// 	VERSION           1 byte
+// 	FLAGS             1 byte
+// 	RESERVED          3 bytes
+// 	ROOT NODE ID      4 bytes
+// 	NODE COUNT        4 bytes
+// 	KEY COUNT         8 bytes
+// 	CHECKPOINT        8 bytes (page ID + offset)
+//
+type meta struct {
+	clean bool
+	root  uint32
+	nodes uint32
+	keys  uint64
+	pos   rumcask.PageRef
+}
+
+const flagClean = 1
+
+func (m *meta) encode(buf []byte) {
+	for i := range buf {
+		buf[i] = 0
+	}
+	copy(buf[0:], _MAGIC)
+	buf[7] = version
+	if m.clean {
+		buf[8] = flagClean
+	}
+	binLE.PutUint32(buf[12:], m.root)
+	binLE.PutUint32(buf[16:], m.nodes)
+	binLE.PutUint64(buf[20:], m.keys)
+	binLE.PutUint32(buf[28:], m.pos.ID)
+	binLE.PutUint32(buf[32:], m.pos.Offset)
+}
+
+func (m *meta) decode(buf []byte) error {
+	if !bytes.Equal(buf[:7], _MAGIC) || buf[7] != version {
+		return errBadHeader
+	}
+	m.clean = buf[8]&flagClean != 0
+	m.root = binLE.Uint32(buf[12:])
+	m.nodes = binLE.Uint32(buf[16:])
+	m.keys = binLE.Uint64(buf[20:])
+	m.pos.ID = binLE.Uint32(buf[28:])
+	m.pos.Offset = binLE.Uint32(buf[32:])
+	if m.root == 0 || m.root >= m.nodes {
+		return errBadHeader
+	}
+	return nil
+}
+
+// Iterator allows callers to iterate the key/ref pairs
+// in lexical order. When this function returns false,
+// iteration will stop immediately.
+type Iterator = rumcask.Iterator
+
+// A disk-backed B+tree KeyStore implementation.
+// Keys are iterable and persist across restarts.
+//
+// The KeyStore interface does not permit errors, I/O
+// failures are therefore recorded and returned by Err,
+// Commit and Close. Once failed, the store will not accept
+// further changes until it is reset.
+type KeyStore struct {
+	file  *os.File
+	meta  meta
+	buf   []byte
+	err   error
+	limit int
+
+	cache map[uint32]*list.Element
+	lru   *list.List
+
+	lock sync.Mutex
+}
+
+// OpenKeyStore opens a store file, creating it if it does not
+// exist yet. The cacheSize limits the number of nodes which are held in
+// memory, each node occupies up to 4KiB.
+func OpenKeyStore(fname string, cacheSize int) (*KeyStore, error) {
+	if cacheSize < 8 {
+		cacheSize = 8
+	}
+
+	file, err := os.OpenFile(fname, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	s := &KeyStore{
+		file:  file,
+		buf:   make([]byte, nodeSize),
+		limit: cacheSize,
+		cache: make(map[uint32]*list.Element),
+		lru:   list.New(),
+	}
+	if info.Size() == 0 {
+		err = s.init()
+	} else if _, err = file.ReadAt(s.buf, 0); err == nil {
+		err = s.meta.decode(s.buf)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Fetch retrieves the ref at key
+func (s *KeyStore) Fetch(key []byte) (_ rumcask.PageRef, _ bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	defer s.shrink()
+
+	if s.err != nil {
+		return
+	}
+
+	n, err := s.leaf(key)
+	if err != nil {
+		s.err = err
+		return
+	}
+
+	if i, ok := n.find(key); ok {
+		return n.refs[i], true
+	}
+	return
+}
+
+// Store stores a key/ref pair
+func (s *KeyStore) Store(key []byte, ref rumcask.PageRef) (prev rumcask.PageRef, ok bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	defer s.shrink()
+
+	if s.err = s.markDirty(); s.err != nil {
+		return
+	}
+
+	var sep []byte
+	var right uint32
+	prev, ok, sep, right, s.err = s.insert(s.meta.root, key, ref)
+	if s.err != nil {
+		return
+	}
+
+	if right != 0 {
+		root := s.alloc(false)
+		root.keys = [][]byte{sep}
+		root.children = []uint32{s.meta.root, right}
+		s.meta.root = root.id
+	}
+	if !ok {
+		s.meta.keys++
+	}
+	return
+}
+
+// Delete deletes a key
+func (s *KeyStore) Delete(key []byte) (prev rumcask.PageRef, ok bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	defer s.shrink()
+
+	if s.err = s.markDirty(); s.err != nil {
+		return
+	}
+
+	n, err := s.leaf(key)
+	if err != nil {
+		s.err = err
+		return
+	}
+
+	i, found := n.find(key)
+	if !found {
+		return
+	}
+
+	prev = n.refs[i]
+	n.removeLeaf(i)
+	n.dirty = true
+	s.meta.keys--
+	return prev, true
+}
+
+// Len returns the number of keys in the store
+func (s *KeyStore) Len() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return int(s.meta.keys)
+}
+
+// Iterate iterates over a range of keys >= min and < max.
+// A nil max iterates to the last key.
+func (s *KeyStore) Iterate(min, max []byte, each Iterator) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	defer s.shrink()
+
+	if s.err != nil {
+		return
+	}
+
+	n, err := s.leaf(min)
+	if err != nil {
+		s.err = err
+		return
+	}
+
+	i, _ := n.find(min)
+	for {
+		for ; i < len(n.keys); i++ {
+			if max != nil && bytes.Compare(n.keys[i], max) >= 0 {
+				return
+			}
+			if !each(n.keys[i], n.refs[i]) {
+				return
+			}
+		}
+		if n.next == 0 {
+			return
+		}
+
+		s.shrink()
+		if n, err = s.load(n.next); err != nil {
+			s.err = err
+			return
+		}
+		i = 0
+	}
+}
+
+// Checkpoint implements rumcask.PersistentKeyStore
+func (s *KeyStore) Checkpoint() (rumcask.PageRef, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.meta.pos, s.err == nil && s.meta.clean
+}
+
+// Commit implements rumcask.PersistentKeyStore
+func (s *KeyStore) Commit(pos rumcask.PageRef) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.err != nil {
+		return s.err
+	}
+	if s.err = s.flush(); s.err != nil {
+		return s.err
+	}
+
+	s.meta.pos = pos
+	s.meta.clean = true
+	if s.err = s.writeMeta(); s.err != nil {
+		return s.err
+	}
+	s.err = s.file.Sync()
+	return s.err
+}
+
+// Reset implements rumcask.PersistentKeyStore
+func (s *KeyStore) Reset() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.file == nil {
+		return errClosed
+	}
+
+	s.cache = make(map[uint32]*list.Element)
+	s.lru.Init()
+	if err := s.file.Truncate(0); err != nil {
+		s.err = err
+		return err
+	}
+
+	s.err = s.init()
+	return s.err
+}
+
+// Err returns the first I/O error encountered by the store
+func (s *KeyStore) Err() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.err
+}
+
+// Close writes back all cached changes and closes the store file
+func (s *KeyStore) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+
+	err := s.err
+	if err == nil {
+		err = s.flush()
+	}
+	if e := s.file.Close(); e != nil && err == nil {
+		err = e
+	}
+	s.file = nil
+	s.err = errClosed
+	return err
+}
+
+// Initializes an empty store file
+func (s *KeyStore) init() error {
+	s.meta = meta{nodes: 1}
+	s.meta.root = s.alloc(true).id
+	if err := s.flush(); err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+// Inserts a key into the subtree at id, returns the previous ref
+// and, if the node was split, the separator key and the new node ID
+func (s *KeyStore) insert(id uint32, key []byte, ref rumcask.PageRef) (prev rumcask.PageRef, ok bool, sep []byte, right uint32, err error) {
+	n, err := s.load(id)
+	if err != nil {
+		return
+	}
+
+	if n.leaf {
+		i, found := n.find(key)
+		if found {
+			prev, ok = n.refs[i], true
+			n.refs[i] = ref
+			n.dirty = true
+			return
+		}
+
+		n.insertLeaf(i, append([]byte(nil), key...), ref)
+		n.dirty = true
+		if n.size() > nodeSize {
+			sep, right = s.splitLeaf(n)
+		}
+		return
+	}
+
+	i := n.child(key)
+	prev, ok, sep, right, err = s.insert(n.children[i], key, ref)
+	if err != nil || right == 0 {
+		return
+	}
+
+	n.insertBranch(i, sep, right)
+	n.dirty = true
+	sep, right = nil, 0
+	if n.size() > nodeSize {
+		sep, right = s.splitBranch(n)
+	}
+	return
+}
+
+func (s *KeyStore) splitLeaf(n *node) ([]byte, uint32) {
+	at := n.splitAt()
+	r := s.alloc(true)
+	r.keys = append(r.keys, n.keys[at:]...)
+	r.refs = append(r.refs, n.refs[at:]...)
+	r.next = n.next
+	n.keys, n.refs = n.keys[:at], n.refs[:at]
+	n.next = r.id
+	return r.keys[0], r.id
+}
+
+func (s *KeyStore) splitBranch(n *node) ([]byte, uint32) {
+	at := n.splitAt()
+	if at > len(n.keys)-2 {
+		at = len(n.keys) - 2
+	}
+
+	sep := n.keys[at]
+	r := s.alloc(false)
+	r.keys = append(r.keys, n.keys[at+1:]...)
+	r.children = append(r.children, n.children[at+1:]...)
+	n.keys, n.children = n.keys[:at], n.children[:at+1]
+	return sep, r.id
+}
+
+// Finds the leaf node which may contain key
+func (s *KeyStore) leaf(key []byte) (*node, error) {
+	n, err := s.load(s.meta.root)
+	for err == nil && !n.leaf {
+		n, err = s.load(n.children[n.child(key)])
+	}
+	return n, err
+}
+
+// Loads a node, from cache if possible
+func (s *KeyStore) load(id uint32) (*node, error) {
+	if el, ok := s.cache[id]; ok {
+		s.lru.MoveToFront(el)
+		return el.Value.(*node), nil
+	}
+	if id == 0 || id >= s.meta.nodes {
+		return nil, errBadNode
+	}
+
+	if _, err := s.file.ReadAt(s.buf, int64(id)*nodeSize); err != nil {
+		return nil, err
+	}
+	n := &node{id: id}
+	if err := n.decode(s.buf); err != nil {
+		return nil, err
+	}
+	s.cache[id] = s.lru.PushFront(n)
+	return n, nil
+}
+
+// Allocates a new node
+func (s *KeyStore) alloc(leaf bool) *node {
+	n := &node{id: s.meta.nodes, leaf: leaf, dirty: true}
+	if !leaf {
+		n.children = make([]uint32, 0, 2)
+	}
+	s.meta.nodes++
+	s.cache[n.id] = s.lru.PushFront(n)
+	return n
+}
+
+// Writes a node to disk
+func (s *KeyStore) write(n *node) error {
+	n.encode(s.buf)
+	if _, err := s.file.WriteAt(s.buf, int64(n.id)*nodeSize); err != nil {
+		return err
+	}
+	n.dirty = false
+	return nil
+}
+
+// Writes the meta data
+func (s *KeyStore) writeMeta() error {
+	s.meta.encode(s.buf)
+	_, err := s.file.WriteAt(s.buf, 0)
+	return err
+}
+
+// Clears the clean flag before the first modification
+// after a commit
+func (s *KeyStore) markDirty() error {
+	if s.err != nil {
+		return s.err
+	} else if !s.meta.clean {
+		return nil
+	}
+
+	s.meta.clean = false
+	if err := s.writeMeta(); err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+// Writes all dirty nodes and the meta data
+func (s *KeyStore) flush() error {
+	for el := s.lru.Front(); el != nil; el = el.Next() {
+		if n := el.Value.(*node); n.dirty {
+			if err := s.write(n); err != nil {
+				return err
+			}
+		}
+	}
+	return s.writeMeta()
+}
+
+// Evicts least recently used nodes until the
+// cache is within limits
+func (s *KeyStore) shrink() {
+	for s.lru.Len() > s.limit {
+		el := s.lru.Back()
+		n := el.Value.(*node)
+		if n.dirty && s.err == nil {
+			if s.err = s.write(n); s.err != nil {
+				return
+			}
+		}
+		s.lru.Remove(el)
+		delete(s.cache, n.id)
+	}
+}
@@ -0,0 +1,236 @@
+package bptree
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bsm/rumcask"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("KeyStore", func() {
+	var subject *KeyStore
+	var _ rumcask.PersistentKeyStore = subject // interface assertions
+	var _ rumcask.OrderedIterator = subject
+	var _ rumcask.Counter = subject
+	var fname string
+
+	var keyAt = func(i int) []byte {
+		return []byte(fmt.Sprintf("key%06d", i))
+	}
+
+	BeforeEach(func() {
+		var err error
+		fname = filepath.Join(testDir, "KEYS")
+		subject, err = OpenKeyStore(fname, 8)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		subject.Close()
+	})
+
+	It("should store/fetch/delete", func() {
+		_, ok := subject.Fetch([]byte("key1"))
+		Expect(ok).To(BeFalse())
+
+		_, ok = subject.Store([]byte("key1"), rumcask.PageRef{ID: 1, Offset: 1024})
+		Expect(ok).To(BeFalse())
+		_, ok = subject.Store([]byte("key2"), rumcask.PageRef{ID: 7, Offset: 8096})
+		Expect(ok).To(BeFalse())
+
+		ref, ok := subject.Store([]byte("key1"), rumcask.PageRef{ID: 2, Offset: 2048})
+		Expect(ok).To(BeTrue())
+		Expect(ref).To(Equal(rumcask.PageRef{ID: 1, Offset: 1024}))
+
+		ref, ok = subject.Fetch([]byte("key1"))
+		Expect(ok).To(BeTrue())
+		Expect(ref).To(Equal(rumcask.PageRef{ID: 2, Offset: 2048}))
+
+		ref, ok = subject.Delete([]byte("key2"))
+		Expect(ok).To(BeTrue())
+		Expect(ref).To(Equal(rumcask.PageRef{ID: 7, Offset: 8096}))
+		_, ok = subject.Delete([]byte("key2"))
+		Expect(ok).To(BeFalse())
+		Expect(subject.Len()).To(Equal(1))
+		Expect(subject.Err()).NotTo(HaveOccurred())
+	})
+
+	It("should handle many keys with a small cache", func() {
+		for _, i := range rand.Perm(5000) {
+			_, ok := subject.Store(keyAt(i), rumcask.PageRef{ID: uint32(i), Offset: 128})
+			Expect(ok).To(BeFalse())
+		}
+		Expect(subject.Len()).To(Equal(5000))
+		Expect(subject.lru.Len()).To(BeNumerically("<=", 8))
+		Expect(subject.meta.nodes).To(BeNumerically(">", 20))
+
+		for _, i := range rand.Perm(5000)[:500] {
+			ref, ok := subject.Fetch(keyAt(i))
+			Expect(ok).To(BeTrue())
+			Expect(ref).To(Equal(rumcask.PageRef{ID: uint32(i), Offset: 128}))
+		}
+		for i := 0; i < 5000; i += 2 {
+			_, ok := subject.Delete(keyAt(i))
+			Expect(ok).To(BeTrue())
+		}
+		Expect(subject.Len()).To(Equal(2500))
+		Expect(subject.Err()).NotTo(HaveOccurred())
+	})
+
+	It("should iterate", func() {
+		for _, i := range rand.Perm(2000) {
+			subject.Store(keyAt(i), rumcask.PageRef{ID: uint32(i)})
+		}
+
+		var keys []string
+		subject.Iterate(keyAt(500), keyAt(1500), func(key []byte, ref rumcask.PageRef) bool {
+			Expect(key).To(Equal(keyAt(int(ref.ID))))
+			keys = append(keys, string(key))
+			return true
+		})
+		Expect(keys).To(HaveLen(1000))
+		Expect(keys[0]).To(Equal("key000500"))
+		Expect(keys[999]).To(Equal("key001499"))
+
+		count := 0
+		subject.Iterate(nil, nil, func(key []byte, ref rumcask.PageRef) bool {
+			count++
+			return count < 1800
+		})
+		Expect(count).To(Equal(1800))
+	})
+
+	It("should persist keys", func() {
+		for i := 0; i < 1000; i++ {
+			subject.Store(keyAt(i), rumcask.PageRef{ID: uint32(i)})
+		}
+		_, ok := subject.Checkpoint()
+		Expect(ok).To(BeFalse())
+
+		Expect(subject.Commit(rumcask.PageRef{ID: 3, Offset: 333})).NotTo(HaveOccurred())
+		Expect(subject.Close()).NotTo(HaveOccurred())
+
+		var err error
+		subject, err = OpenKeyStore(fname, 8)
+		Expect(err).NotTo(HaveOccurred())
+
+		pos, ok := subject.Checkpoint()
+		Expect(ok).To(BeTrue())
+		Expect(pos).To(Equal(rumcask.PageRef{ID: 3, Offset: 333}))
+		Expect(subject.Len()).To(Equal(1000))
+
+		ref, ok := subject.Fetch(keyAt(777))
+		Expect(ok).To(BeTrue())
+		Expect(ref).To(Equal(rumcask.PageRef{ID: 777}))
+	})
+
+	It("should detect uncommitted changes", func() {
+		subject.Store(keyAt(1), rumcask.PageRef{ID: 1})
+		Expect(subject.Commit(rumcask.PageRef{ID: 1, Offset: 128})).NotTo(HaveOccurred())
+		subject.Store(keyAt(2), rumcask.PageRef{ID: 2})
+		Expect(subject.Close()).NotTo(HaveOccurred())
+
+		var err error
+		subject, err = OpenKeyStore(fname, 8)
+		Expect(err).NotTo(HaveOccurred())
+		_, ok := subject.Checkpoint()
+		Expect(ok).To(BeFalse())
+	})
+
+	It("should reset", func() {
+		for i := 0; i < 1000; i++ {
+			subject.Store(keyAt(i), rumcask.PageRef{ID: uint32(i)})
+		}
+		Expect(subject.Reset()).NotTo(HaveOccurred())
+		Expect(subject.Len()).To(Equal(0))
+		_, ok := subject.Fetch(keyAt(1))
+		Expect(ok).To(BeFalse())
+
+		info, err := os.Stat(fname)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Size()).To(Equal(int64(2 * nodeSize)))
+	})
+
+	It("should reject invalid files", func() {
+		Expect(ioutil.WriteFile(fname+".bad", []byte("not a tree"), 0644)).NotTo(HaveOccurred())
+		_, err := OpenKeyStore(fname+".bad", 8)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should be usable by DB", func() {
+		dir := filepath.Join(testDir, "db")
+		db, err := rumcask.Open(dir, subject)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = db.Set([]byte("key1"), []byte("val1"))
+		Expect(err).NotTo(HaveOccurred())
+		_, err = db.Set([]byte("key2"), []byte("val2"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(db.Close()).NotTo(HaveOccurred())
+
+		// Remove a key behind the DB's back, pages must not be parsed again
+		pos, ok := subject.Checkpoint()
+		Expect(ok).To(BeTrue())
+		Expect(pos).To(Equal(rumcask.PageRef{ID: 0, Offset: 160}))
+		subject.Delete([]byte("key1"))
+		Expect(subject.Commit(pos)).NotTo(HaveOccurred())
+		Expect(subject.Close()).NotTo(HaveOccurred())
+
+		subject, err = OpenKeyStore(fname, 8)
+		Expect(err).NotTo(HaveOccurred())
+		db, err = rumcask.Open(dir, subject)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(subject.Len()).To(Equal(1))
+		val, err := db.Get([]byte("key2"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(val).To(Equal([]byte("val2")))
+		Expect(db.Close()).NotTo(HaveOccurred())
+
+		// Reopen with stale keys, pages must be parsed
+		subject.Delete([]byte("key2"))
+		db, err = rumcask.Open(dir, subject)
+		Expect(err).NotTo(HaveOccurred())
+		defer db.Close()
+		Expect(subject.Len()).To(Equal(2))
+	})
+
+	It("should be committed by DB periodically", func() {
+		db, err := rumcask.OpenWithOptions(filepath.Join(testDir, "db"), subject, &rumcask.Options{
+			CheckpointInterval: 10 * time.Millisecond,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		defer db.Close()
+
+		_, err = db.Set([]byte("key1"), []byte("val1"))
+		Expect(err).NotTo(HaveOccurred())
+		Eventually(func() bool {
+			pos, ok := subject.Checkpoint()
+			return ok && pos == rumcask.PageRef{ID: 0, Offset: 144}
+		}).Should(BeTrue())
+	})
+
+})
+
+/** Test hook **/
+
+var testDir string
+
+func TestSuite(t *testing.T) {
+	RegisterFailHandler(Fail)
+	BeforeEach(func() {
+		var err error
+		testDir, err = ioutil.TempDir("", "rumcask-bptree")
+		Expect(err).NotTo(HaveOccurred())
+	})
+	AfterEach(func() {
+		os.RemoveAll(testDir)
+	})
+	RunSpecs(t, "rumcask/bptree")
+}
@@ -25,26 +25,19 @@
 // implmentation written about there.
 //
 // Within this tree, each node contains a slice of items and a (possibly nil)
-// slice of children.  For basic numeric values or raw structs, this can cause
-// efficiency differences when compared to equivalent C++ template code that
-// stores values in arrays within the node:
-//   * Due to the overhead of storing values as interfaces (each
-//     value needs to be stored as the value itself, then 2 words for the
-//     interface pointing to that value and its type), resulting in higher
-//     memory use.
-//   * Since interfaces can point to values anywhere in memory, values are
-//     most likely not stored in contiguous blocks, resulting in a higher
-//     number of cache misses.
-// These issues don't tend to matter, though, when working with strings or other
-// heap-allocated structures, since C++-equivalent structures also must store
-// pointers and also distribute their values across the heap.
+// slice of children.  The tree is generic over its item type, items are
+// stored by value within the nodes' slices and ordered by a less function:
+//   * Unlike interface values, items need no additional indirection
+//     and no per-item heap allocation.
+//   * Items of a node are stored in contiguous blocks, resulting in
+//     fewer cache misses during searches.
 //
 // This implementation is designed to be a drop-in replacement to gollrb.LLRB
 // trees, (http://github.com/petar/gollrb), an excellent and probably the most
 // widely used ordered tree implementation in the Go ecosystem currently.
 // Its functions, therefore, exactly mirror those of
 // llrb.LLRB where possible.  Unlike gollrb, though, we currently don't
-// support storing multiple equivalent values or backwards iteration.
+// support storing multiple equivalent values.
 package btree
 
 import (
@@ -52,44 +45,73 @@ import (
 	"io"
 	"sort"
 	"strings"
+	"sync"
 )
 
-// bItem represents a single object in the tree.
-type bItem interface {
-	// Less tests whether the current item is less than the given argument.
-	//
-	// This must provide a strict weak ordering.
-	// If !a.Less(b) && !b.Less(a), we treat this to mean a == b (i.e. we can only
-	// hold one of either a or b in the tree).
-	Less(than bItem) bool
-}
+// lessFunc determines how to order items of a tree.
+//
+// This must provide a strict weak ordering.
+// If !less(a, b) && !less(b, a), we treat this to mean a == b (i.e. we can only
+// hold one of either a or b in the tree).
+type lessFunc[T any] func(a, b T) bool
 
-// treeIterator allows callers of Ascend* to iterate in-order over portions of
-// the tree.  When this function returns false, iteration will stop and the
-// associated Ascend* function will immediately return.
-type treeIterator func(i bItem) bool
+// treeIterator allows callers of Ascend* and Descend* to iterate in-order over
+// portions of the tree.  When this function returns false, iteration will stop
+// and the associated Ascend* or Descend* function will immediately return.
+type treeIterator[T any] func(item T) bool
 
-// newTree creates a new B-Tree with the given degree.
+// newTree creates a new B-Tree with the given degree and ordering.
 //
-// newTree(2), for example, will create a 2-3-4 tree (each node contains 1-3 items
-// and 2-4 children).
-func newTree(degree int) *bTree {
+// newTree(2, less), for example, will create a 2-3-4 tree (each node contains
+// 1-3 items and 2-4 children).
+func newTree[T any](degree int, less lessFunc[T]) *bTree[T] {
 	if degree <= 1 {
 		panic("bad degree")
 	}
-	return &bTree{
-		degree:   degree,
-		freelist: make([]*node, 0, 32),
+	return &bTree[T]{
+		degree: degree,
+		cow: &copyOnWriteContext[T]{
+			freelist: &freeList[T]{nodes: make([]*node[T], 0, 32)},
+			less:     less,
+		},
+	}
+}
+
+// freeList represents a free list of btree nodes, shared by clones.
+type freeList[T any] struct {
+	nodes []*node[T]
+	mu    sync.Mutex
+}
+
+func (f *freeList[T]) newNode() (n *node[T]) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	index := len(f.nodes) - 1
+	if index < 0 {
+		return new(node[T])
+	}
+	f.nodes, n = f.nodes[:index], f.nodes[index]
+	return
+}
+
+func (f *freeList[T]) freeNode(n *node[T]) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(f.nodes) < cap(f.nodes) {
+		f.nodes = append(f.nodes, n)
 	}
 }
 
 // items stores items in a node.
-type items []bItem
+type items[T any] []T
 
 // insertAt inserts a value into the given index, pushing all subsequent values
 // forward.
-func (s *items) insertAt(index int, item bItem) {
-	*s = append(*s, nil)
+func (s *items[T]) insertAt(index int, item T) {
+	var zero T
+	*s = append(*s, zero)
 	if index < len(*s) {
 		copy((*s)[index+1:], (*s)[index:])
 	}
@@ -98,39 +120,53 @@ func (s *items) insertAt(index int, item bItem) {
 
 // removeAt removes a value at a given index, pulling all subsequent values
 // back.
-func (s *items) removeAt(index int) bItem {
+func (s *items[T]) removeAt(index int) T {
+	var zero T
 	item := (*s)[index]
 	copy((*s)[index:], (*s)[index+1:])
+	(*s)[len(*s)-1] = zero // clear to allow GC
 	*s = (*s)[:len(*s)-1]
 	return item
 }
 
 // pop removes and returns the last element in the list.
-func (s *items) pop() (out bItem) {
+func (s *items[T]) pop() (out T) {
+	var zero T
 	index := len(*s) - 1
-	out, *s = (*s)[index], (*s)[:index]
+	out = (*s)[index]
+	(*s)[index] = zero // clear to allow GC
+	*s = (*s)[:index]
 	return
 }
 
+// truncate truncates the list at index, clearing all subsequent values.
+func (s *items[T]) truncate(index int) {
+	var zero T
+	for i := index; i < len(*s); i++ {
+		(*s)[i] = zero // clear to allow GC
+	}
+	*s = (*s)[:index]
+}
+
 // find returns the index where the given item should be inserted into this
 // list.  'found' is true if the item already exists in the list at the given
 // index.
-func (s items) find(item bItem) (index int, found bool) {
+func (s items[T]) find(item T, less lessFunc[T]) (index int, found bool) {
 	i := sort.Search(len(s), func(i int) bool {
-		return item.Less(s[i])
+		return less(item, s[i])
 	})
-	if i > 0 && !s[i-1].Less(item) {
+	if i > 0 && !less(s[i-1], item) {
 		return i - 1, true
 	}
 	return i, false
 }
 
 // children stores child nodes in a node.
-type children []*node
+type children[T any] []*node[T]
 
 // insertAt inserts a value into the given index, pushing all subsequent values
 // forward.
-func (s *children) insertAt(index int, n *node) {
+func (s *children[T]) insertAt(index int, n *node[T]) {
 	*s = append(*s, nil)
 	if index < len(*s) {
 		copy((*s)[index+1:], (*s)[index:])
@@ -140,160 +176,229 @@ func (s *children) insertAt(index int, n *node) {
 
 // removeAt removes a value at a given index, pulling all subsequent values
 // back.
-func (s *children) removeAt(index int) *node {
+func (s *children[T]) removeAt(index int) *node[T] {
 	n := (*s)[index]
 	copy((*s)[index:], (*s)[index+1:])
+	(*s)[len(*s)-1] = nil // clear to allow GC
 	*s = (*s)[:len(*s)-1]
 	return n
 }
 
 // pop removes and returns the last element in the list.
-func (s *children) pop() (out *node) {
+func (s *children[T]) pop() (out *node[T]) {
 	index := len(*s) - 1
-	out, *s = (*s)[index], (*s)[:index]
+	out = (*s)[index]
+	(*s)[index] = nil // clear to allow GC
+	*s = (*s)[:index]
 	return
 }
 
+// truncate truncates the list at index, clearing all subsequent values.
+func (s *children[T]) truncate(index int) {
+	for i := index; i < len(*s); i++ {
+		(*s)[i] = nil // clear to allow GC
+	}
+	*s = (*s)[:index]
+}
+
 // node is an internal node in a tree.
 //
 // It must at all times maintain the invariant that either
 //   * len(children) == 0, len(items) unconstrained
 //   * len(children) == len(items) + 1
-type node struct {
-	items    items
-	children children
-	t        *bTree
+//
+// Additionally, size must equal the number of items in the subtree
+// rooted at this node.
+type node[T any] struct {
+	items    items[T]
+	children children[T]
+	size     int
+	cow      *copyOnWriteContext[T]
+}
+
+// computeSize recalculates the subtree size of n from its items and children.
+func (n *node[T]) computeSize() {
+	n.size = len(n.items)
+	for _, c := range n.children {
+		n.size += c.size
+	}
+}
+
+// mutableFor returns a node which may be modified within the given context,
+// copying n if it is owned by another one.
+func (n *node[T]) mutableFor(cow *copyOnWriteContext[T]) *node[T] {
+	if n.cow == cow {
+		return n
+	}
+	out := cow.newNode()
+	if cap(out.items) >= len(n.items) {
+		out.items = out.items[:len(n.items)]
+	} else {
+		out.items = make(items[T], len(n.items), cap(n.items))
+	}
+	copy(out.items, n.items)
+	if cap(out.children) >= len(n.children) {
+		out.children = out.children[:len(n.children)]
+	} else {
+		out.children = make(children[T], len(n.children), cap(n.children))
+	}
+	copy(out.children, n.children)
+	out.size = n.size
+	return out
+}
+
+// mutableChild makes child 'i' mutable within the context of n.
+func (n *node[T]) mutableChild(i int) *node[T] {
+	c := n.children[i].mutableFor(n.cow)
+	n.children[i] = c
+	return c
 }
 
 // split splits the given node at the given index.  The current node shrinks,
 // and this function returns the item that existed at that index and a new node
 // containing all items/children after it.
-func (n *node) split(i int) (bItem, *node) {
+func (n *node[T]) split(i int) (T, *node[T]) {
 	item := n.items[i]
-	next := n.t.newNode()
+	next := n.cow.newNode()
 	next.items = append(next.items, n.items[i+1:]...)
-	n.items = n.items[:i]
+	n.items.truncate(i)
 	if len(n.children) > 0 {
 		next.children = append(next.children, n.children[i+1:]...)
-		n.children = n.children[:i+1]
+		n.children.truncate(i + 1)
 	}
+	n.computeSize()
+	next.computeSize()
 	return item, next
 }
 
 // maybeSplitChild checks if a child should be split, and if so splits it.
 // Returns whether or not a split occurred.
-func (n *node) maybeSplitChild(i, maxbItems int) bool {
-	if len(n.children[i].items) < maxbItems {
+func (n *node[T]) maybeSplitChild(i, maxItems int) bool {
+	if len(n.children[i].items) < maxItems {
 		return false
 	}
-	first := n.children[i]
-	item, second := first.split(maxbItems / 2)
+	first := n.mutableChild(i)
+	item, second := first.split(maxItems / 2)
 	n.items.insertAt(i, item)
 	n.children.insertAt(i+1, second)
 	return true
 }
 
 // insert inserts an item into the subtree rooted at this node, making sure
-// no nodes in the subtree exceed maxbItems items.  Should an equivalent item be
+// no nodes in the subtree exceed maxItems items.  Should an equivalent item be
 // be found/replaced by insert, it will be returned.
-func (n *node) insert(item bItem, maxbItems int) bItem {
-	i, found := n.items.find(item)
+func (n *node[T]) insert(item T, maxItems int) (_ T, _ bool) {
+	i, found := n.items.find(item, n.cow.less)
 	if found {
 		out := n.items[i]
 		n.items[i] = item
-		return out
+		return out, true
 	}
 	if len(n.children) == 0 {
 		n.items.insertAt(i, item)
-		return nil
+		n.size++
+		return
 	}
-	if n.maybeSplitChild(i, maxbItems) {
+	if n.maybeSplitChild(i, maxItems) {
 		inTree := n.items[i]
 		switch {
-		case item.Less(inTree):
+		case n.cow.less(item, inTree):
 			// no change, we want first split node
-		case inTree.Less(item):
+		case n.cow.less(inTree, item):
 			i++ // we want second split node
 		default:
 			out := n.items[i]
 			n.items[i] = item
-			return out
+			return out, true
 		}
 	}
-	return n.children[i].insert(item, maxbItems)
+	out, replaced := n.mutableChild(i).insert(item, maxItems)
+	if !replaced {
+		n.size++
+	}
+	return out, replaced
 }
 
 // get finds the given key in the subtree and returns it.
-func (n *node) get(key bItem) bItem {
-	i, found := n.items.find(key)
+func (n *node[T]) get(key T) (_ T, _ bool) {
+	i, found := n.items.find(key, n.cow.less)
 	if found {
-		return n.items[i]
+		return n.items[i], true
 	} else if len(n.children) > 0 {
 		return n.children[i].get(key)
 	}
-	return nil
+	return
 }
 
 // toRemove details what item to remove in a node.remove call.
 type toRemove int
 
 const (
-	removebItem toRemove = iota // removes the given item
-	removeMin                   // removes smallest item in the subtree
-	removeMax                   // removes largest item in the subtree
+	removeItem toRemove = iota // removes the given item
+	removeMin                  // removes smallest item in the subtree
+	removeMax                  // removes largest item in the subtree
 )
 
 // remove removes an item from the subtree rooted at this node.
-func (n *node) remove(item bItem, minbItems int, typ toRemove) bItem {
+func (n *node[T]) remove(item T, minItems int, typ toRemove) (_ T, _ bool) {
 	var i int
 	var found bool
 	switch typ {
 	case removeMax:
 		if len(n.children) == 0 {
-			return n.items.pop()
+			n.size--
+			return n.items.pop(), true
 		}
 		i = len(n.items)
 	case removeMin:
 		if len(n.children) == 0 {
-			return n.items.removeAt(0)
+			n.size--
+			return n.items.removeAt(0), true
 		}
 		i = 0
-	case removebItem:
-		i, found = n.items.find(item)
+	case removeItem:
+		i, found = n.items.find(item, n.cow.less)
 		if len(n.children) == 0 {
 			if found {
-				return n.items.removeAt(i)
+				n.size--
+				return n.items.removeAt(i), true
 			}
-			return nil
+			return
 		}
 	default:
 		panic("invalid type")
 	}
 	// If we get to here, we have children.
-	child := n.children[i]
-	if len(child.items) <= minbItems {
-		return n.growChildAndRemove(i, item, minbItems, typ)
+	if len(n.children[i].items) <= minItems {
+		return n.growChildAndRemove(i, item, minItems, typ)
 	}
+	child := n.mutableChild(i)
 	// Either we had enough items to begin with, or we've done some
 	// merging/stealing, because we've got enough now and we're ready to return
 	// stuff.
 	if found {
 		// The item exists at index 'i', and the child we've selected can give us a
-		// predecessor, since if we've gotten here it's got > minbItems items in it.
+		// predecessor, since if we've gotten here it's got > minItems items in it.
 		out := n.items[i]
-		// We use our special-case 'remove' call with typ=maxbItem to pull the
+		// We use our special-case 'remove' call with typ=removeMax to pull the
 		// predecessor of item i (the rightmost leaf of our immediate left child)
 		// and set it into where we pulled the item from.
-		n.items[i] = child.remove(nil, minbItems, removeMax)
-		return out
+		var zero T
+		n.items[i], _ = child.remove(zero, minItems, removeMax)
+		n.size--
+		return out, true
 	}
 	// Final recursive call.  Once we're here, we know that the item isn't in this
 	// node and that the child is big enough to remove from.
-	return child.remove(item, minbItems, typ)
+	out, removed := child.remove(item, minItems, typ)
+	if removed {
+		n.size--
+	}
+	return out, removed
 }
 
 // growChildAndRemove grows child 'i' to make sure it's possible to remove an
-// item from it while keeping it at minbItems, then calls remove to actually
+// item from it while keeping it at minItems, then calls remove to actually
 // remove it.
 //
 // Most documentation says we have to do two sets of special casing:
@@ -311,40 +416,46 @@ func (n *node) remove(item bItem, minbItems int, typ toRemove) bItem {
 // We then simply redo our remove call, and the second time (regardless of
 // whether we're in case 1 or 2), we'll have enough items and can guarantee
 // that we hit case A.
-func (n *node) growChildAndRemove(i int, item bItem, minbItems int, typ toRemove) bItem {
-	child := n.children[i]
-	if i > 0 && len(n.children[i-1].items) > minbItems {
+func (n *node[T]) growChildAndRemove(i int, item T, minItems int, typ toRemove) (T, bool) {
+	if i > 0 && len(n.children[i-1].items) > minItems {
 		// Steal from left child
-		stealFrom := n.children[i-1]
-		stolenbItem := stealFrom.items.pop()
+		child := n.mutableChild(i)
+		stealFrom := n.mutableChild(i - 1)
+		stolenItem := stealFrom.items.pop()
 		child.items.insertAt(0, n.items[i-1])
-		n.items[i-1] = stolenbItem
+		n.items[i-1] = stolenItem
 		if len(stealFrom.children) > 0 {
 			child.children.insertAt(0, stealFrom.children.pop())
 		}
-	} else if i < len(n.items) && len(n.children[i+1].items) > minbItems {
+		child.computeSize()
+		stealFrom.computeSize()
+	} else if i < len(n.items) && len(n.children[i+1].items) > minItems {
 		// steal from right child
-		stealFrom := n.children[i+1]
-		stolenbItem := stealFrom.items.removeAt(0)
+		child := n.mutableChild(i)
+		stealFrom := n.mutableChild(i + 1)
+		stolenItem := stealFrom.items.removeAt(0)
 		child.items = append(child.items, n.items[i])
-		n.items[i] = stolenbItem
+		n.items[i] = stolenItem
 		if len(stealFrom.children) > 0 {
 			child.children = append(child.children, stealFrom.children.removeAt(0))
 		}
+		child.computeSize()
+		stealFrom.computeSize()
 	} else {
 		if i >= len(n.items) {
 			i--
-			child = n.children[i]
 		}
+		child := n.mutableChild(i)
 		// merge with right child
-		mergebItem := n.items.removeAt(i)
+		mergeItem := n.items.removeAt(i)
 		mergeChild := n.children.removeAt(i + 1)
-		child.items = append(child.items, mergebItem)
+		child.items = append(child.items, mergeItem)
 		child.items = append(child.items, mergeChild.items...)
 		child.children = append(child.children, mergeChild.children...)
-		n.t.freeNode(mergeChild)
+		child.size += mergeChild.size + 1
+		n.cow.freeNode(mergeChild)
 	}
-	return n.remove(item, minbItems, typ)
+	return n.remove(item, minItems, typ)
 }
 
 // iterate provides a simple method for iterating over elements in the tree.
@@ -356,7 +467,7 @@ func (n *node) growChildAndRemove(i int, item bItem, minbItems int, typ toRemove
 // values less than or equal to values 'to' returns true for, and 'to'
 // returns true for values greater than or equal to those that 'from'
 // does.
-func (n *node) iterate(from, to func(bItem) bool, iter treeIterator) bool {
+func (n *node[T]) iterate(from, to func(T) bool, iter treeIterator[T]) bool {
 	for i, item := range n.items {
 		if !from(item) {
 			continue
@@ -377,8 +488,68 @@ func (n *node) iterate(from, to func(bItem) bool, iter treeIterator) bool {
 	return true
 }
 
+// reverse provides a simple method for iterating over elements in the tree in
+// descending order.  It mirrors iterate: 'from' returns true for values less
+// than or equal to the upper bound, 'to' returns true for values within the
+// lower bound.
+func (n *node[T]) reverse(from, to func(T) bool, iter treeIterator[T]) bool {
+	for i := len(n.items) - 1; i >= 0; i-- {
+		item := n.items[i]
+		if !from(item) {
+			continue
+		}
+		if len(n.children) > 0 && !n.children[i+1].reverse(from, to, iter) {
+			return false
+		}
+		if !to(item) {
+			return false
+		}
+		if !iter(item) {
+			return false
+		}
+	}
+	if len(n.children) > 0 {
+		return n.children[0].reverse(from, to, iter)
+	}
+	return true
+}
+
+// rank returns the number of items in the subtree which are less than key.
+func (n *node[T]) rank(key T) int {
+	i, found := n.items.find(key, n.cow.less)
+	rank := i
+	if len(n.children) == 0 {
+		return rank
+	}
+	for _, c := range n.children[:i] {
+		rank += c.size
+	}
+	if found {
+		return rank + n.children[i].size
+	}
+	return rank + n.children[i].rank(key)
+}
+
+// nth returns the item at position 'pos' (zero-based) within the subtree.
+func (n *node[T]) nth(pos int) T {
+	if len(n.children) == 0 {
+		return n.items[pos]
+	}
+	for i, c := range n.children {
+		if pos < c.size {
+			return c.nth(pos)
+		}
+		pos -= c.size
+		if pos == 0 {
+			return n.items[i]
+		}
+		pos--
+	}
+	panic("btree: size out of sync")
+}
+
 // Used for testing/debugging purposes.
-func (n *node) print(w io.Writer, level int) {
+func (n *node[T]) print(w io.Writer, level int) {
 	fmt.Fprintf(w, "%sNODE:%v\n", strings.Repeat("  ", level), n.items)
 	for _, c := range n.children {
 		c.print(w, level+1)
@@ -387,177 +558,310 @@ func (n *node) print(w io.Writer, level int) {
 
 // bTree is an implementation of a B-Tree.
 //
-// bTree stores bItem instances in an ordered structure, allowing easy insertion,
-// removal, and iteration.
+// bTree stores items of type T in an ordered structure, allowing easy
+// insertion, removal, and iteration.
 //
 // Write operations are not safe for concurrent mutation by multiple
 // goroutines, but Read operations are.
-type bTree struct {
-	degree   int
-	length   int
-	root     *node
-	freelist []*node
+type bTree[T any] struct {
+	degree int
+	length int
+	root   *node[T]
+	cow    *copyOnWriteContext[T]
 }
 
-// maxbItems returns the max number of items to allow per node.
-func (t *bTree) maxbItems() int {
+// copyOnWriteContext pointers determine node ownership. A tree with a given
+// context may modify nodes with the same context in place, all other nodes
+// must be copied first. Clone gives both trees new contexts, so that all
+// existing nodes become shared and are copied on their next modification.
+type copyOnWriteContext[T any] struct {
+	freelist *freeList[T]
+	less     lessFunc[T]
+}
+
+// Clone clones the tree lazily.  Both the original and the clone may be used
+// and modified independently afterwards, but must not be modified
+// concurrently.  Clone itself must not be called concurrently with writes.
+//
+// Clone is cheap, the cost of copying is spread across subsequent writes
+// of both trees.
+func (t *bTree[T]) Clone() *bTree[T] {
+	cow1, cow2 := *t.cow, *t.cow
+	out := *t
+	t.cow = &cow1
+	out.cow = &cow2
+	return &out
+}
+
+// maxItems returns the max number of items to allow per node.
+func (t *bTree[T]) maxItems() int {
 	return t.degree*2 - 1
 }
 
-// minbItems returns the min number of items to allow per node (ignored for the
+// minItems returns the min number of items to allow per node (ignored for the
 // root node).
-func (t *bTree) minbItems() int {
+func (t *bTree[T]) minItems() int {
 	return t.degree - 1
 }
 
-func (t *bTree) newNode() (n *node) {
-	index := len(t.freelist) - 1
-	if index < 0 {
-		return &node{t: t}
-	}
-	t.freelist, n = t.freelist[:index], t.freelist[index]
-	return
+func (c *copyOnWriteContext[T]) newNode() *node[T] {
+	n := c.freelist.newNode()
+	n.cow = c
+	return n
 }
 
-func (t *bTree) freeNode(n *node) {
-	if len(t.freelist) < cap(t.freelist) {
-		for i := range n.items {
-			n.items[i] = nil // clear to allow GC
-		}
-		n.items = n.items[:0]
-		for i := range n.children {
-			n.children[i] = nil // clear to allow GC
-		}
-		n.children = n.children[:0]
-		t.freelist = append(t.freelist, n)
+// freeNode returns n to the free list, unless it is shared with other trees.
+func (c *copyOnWriteContext[T]) freeNode(n *node[T]) {
+	if n.cow != c {
+		return
 	}
+	n.items.truncate(0)
+	n.children.truncate(0)
+	n.size = 0
+	n.cow = nil
+	c.freelist.freeNode(n)
 }
 
 // ReplaceOrInsert adds the given item to the tree.  If an item in the tree
-// already equals the given one, it is removed from the tree and returned.
-// Otherwise, nil is returned.
-//
-// nil cannot be added to the tree (will panic).
-func (t *bTree) ReplaceOrInsert(item bItem) bItem {
-	if item == nil {
-		panic("nil item being added to bTree")
-	}
+// already equals the given one, it is removed from the tree and returned,
+// and the second return value is true.  Otherwise, (zeroValue, false).
+func (t *bTree[T]) ReplaceOrInsert(item T) (_ T, _ bool) {
 	if t.root == nil {
-		t.root = t.newNode()
+		t.root = t.cow.newNode()
 		t.root.items = append(t.root.items, item)
+		t.root.size = 1
 		t.length++
-		return nil
-	} else if len(t.root.items) >= t.maxbItems() {
-		item2, second := t.root.split(t.maxbItems() / 2)
+		return
+	}
+
+	t.root = t.root.mutableFor(t.cow)
+	if len(t.root.items) >= t.maxItems() {
+		item2, second := t.root.split(t.maxItems() / 2)
 		oldroot := t.root
-		t.root = t.newNode()
+		t.root = t.cow.newNode()
 		t.root.items = append(t.root.items, item2)
 		t.root.children = append(t.root.children, oldroot, second)
+		t.root.computeSize()
 	}
-	out := t.root.insert(item, t.maxbItems())
-	if out == nil {
+	out, replaced := t.root.insert(item, t.maxItems())
+	if !replaced {
 		t.length++
 	}
-	return out
+	return out, replaced
+}
+
+// Load replaces the contents of the tree with the given items, which must be
+// sorted and free of duplicates.  The tree is built bottom-up in linear time,
+// with nodes packed as tightly as the B-Tree invariants permit.
+func (t *bTree[T]) Load(sorted []T) {
+	t.root, t.length = nil, len(sorted)
+	if len(sorted) == 0 {
+		return
+	}
+
+	nodes, seps := t.buildLevel(sorted, nil)
+	for len(nodes) > 1 {
+		nodes, seps = t.buildLevel(seps, nodes)
+	}
+	t.root = nodes[0]
+}
+
+// buildLevel distributes list (and children, unless building leaves) evenly
+// across as few nodes as possible.  Returns the nodes and the separating items
+// between them, which form the list of the next level.
+func (t *bTree[T]) buildLevel(list []T, children []*node[T]) ([]*node[T], []T) {
+	max := t.maxItems()
+	k := (len(list) + 1 + max) / (max + 1)
+	n := len(list) - (k - 1)
+
+	nodes := make([]*node[T], 0, k)
+	seps := make([]T, 0, k-1)
+	for i := 0; i < k; i++ {
+		size := n / k
+		if i < n%k {
+			size++
+		}
+
+		nd := t.cow.newNode()
+		nd.items = append(nd.items, list[:size]...)
+		list = list[size:]
+		if children != nil {
+			nd.children = append(nd.children, children[:size+1]...)
+			children = children[size+1:]
+		}
+		nd.computeSize()
+		nodes = append(nodes, nd)
+
+		if i < k-1 {
+			seps = append(seps, list[0])
+			list = list[1:]
+		}
+	}
+	return nodes, seps
 }
 
 // Delete removes an item equal to the passed in item from the tree, returning
-// it.  If no such item exists, returns nil.
-func (t *bTree) Delete(item bItem) bItem {
-	return t.deletebItem(item, removebItem)
+// it.  If no such item exists, returns (zeroValue, false).
+func (t *bTree[T]) Delete(item T) (T, bool) {
+	return t.deleteItem(item, removeItem)
 }
 
 // DeleteMin removes the smallest item in the tree and returns it.
-// If no such item exists, returns nil.
-func (t *bTree) DeleteMin() bItem {
-	return t.deletebItem(nil, removeMin)
+// If no such item exists, returns (zeroValue, false).
+func (t *bTree[T]) DeleteMin() (T, bool) {
+	var zero T
+	return t.deleteItem(zero, removeMin)
 }
 
 // DeleteMax removes the largest item in the tree and returns it.
-// If no such item exists, returns nil.
-func (t *bTree) DeleteMax() bItem {
-	return t.deletebItem(nil, removeMax)
+// If no such item exists, returns (zeroValue, false).
+func (t *bTree[T]) DeleteMax() (T, bool) {
+	var zero T
+	return t.deleteItem(zero, removeMax)
 }
 
-func (t *bTree) deletebItem(item bItem, typ toRemove) bItem {
+func (t *bTree[T]) deleteItem(item T, typ toRemove) (_ T, _ bool) {
 	if t.root == nil || len(t.root.items) == 0 {
-		return nil
+		return
 	}
-	out := t.root.remove(item, t.minbItems(), typ)
+	t.root = t.root.mutableFor(t.cow)
+	out, found := t.root.remove(item, t.minItems(), typ)
 	if len(t.root.items) == 0 && len(t.root.children) > 0 {
 		oldroot := t.root
 		t.root = t.root.children[0]
-		t.freeNode(oldroot)
+		t.cow.freeNode(oldroot)
 	}
-	if out != nil {
+	if found {
 		t.length--
 	}
-	return out
+	return out, found
 }
 
 // AscendRange calls the iterator for every value in the tree within the range
 // [greaterOrEqual, lessThan), until iterator returns false.
-func (t *bTree) AscendRange(greaterOrEqual, lessThan bItem, iterator treeIterator) {
-	if t.root == nil {
-		return
-	}
-	t.root.iterate(
-		func(a bItem) bool { return !a.Less(greaterOrEqual) },
-		func(a bItem) bool { return a.Less(lessThan) },
+func (t *bTree[T]) AscendRange(greaterOrEqual, lessThan T, iterator treeIterator[T]) {
+	less := t.cow.less
+	t.ascend(
+		func(a T) bool { return !less(a, greaterOrEqual) },
+		func(a T) bool { return less(a, lessThan) },
 		iterator)
 }
 
 // AscendLessThan calls the iterator for every value in the tree within the range
 // [first, pivot), until iterator returns false.
-func (t *bTree) AscendLessThan(pivot bItem, iterator treeIterator) {
-	if t.root == nil {
-		return
-	}
-	t.root.iterate(
-		func(a bItem) bool { return true },
-		func(a bItem) bool { return a.Less(pivot) },
+func (t *bTree[T]) AscendLessThan(pivot T, iterator treeIterator[T]) {
+	less := t.cow.less
+	t.ascend(
+		func(a T) bool { return true },
+		func(a T) bool { return less(a, pivot) },
 		iterator)
 }
 
 // AscendGreaterOrEqual calls the iterator for every value in the tree within
 // the range [pivot, last], until iterator returns false.
-func (t *bTree) AscendGreaterOrEqual(pivot bItem, iterator treeIterator) {
-	if t.root == nil {
-		return
-	}
-	t.root.iterate(
-		func(a bItem) bool { return !a.Less(pivot) },
-		func(a bItem) bool { return true },
+func (t *bTree[T]) AscendGreaterOrEqual(pivot T, iterator treeIterator[T]) {
+	less := t.cow.less
+	t.ascend(
+		func(a T) bool { return !less(a, pivot) },
+		func(a T) bool { return true },
 		iterator)
 }
 
 // Ascend calls the iterator for every value in the tree within the range
 // [first, last], until iterator returns false.
-func (t *bTree) Ascend(iterator treeIterator) {
+func (t *bTree[T]) Ascend(iterator treeIterator[T]) {
+	t.ascend(
+		func(a T) bool { return true },
+		func(a T) bool { return true },
+		iterator)
+}
+
+func (t *bTree[T]) ascend(from, to func(T) bool, iterator treeIterator[T]) {
 	if t.root == nil {
 		return
 	}
-	t.root.iterate(
-		func(a bItem) bool { return true },
-		func(a bItem) bool { return true },
+	t.root.iterate(from, to, iterator)
+}
+
+// DescendRange calls the iterator for every value in the tree within the range
+// [lessOrEqual, greaterThan), in descending order, until iterator returns false.
+func (t *bTree[T]) DescendRange(lessOrEqual, greaterThan T, iterator treeIterator[T]) {
+	less := t.cow.less
+	t.descend(
+		func(a T) bool { return !less(lessOrEqual, a) },
+		func(a T) bool { return less(greaterThan, a) },
+		iterator)
+}
+
+// DescendLessOrEqual calls the iterator for every value in the tree within the
+// range [pivot, first], in descending order, until iterator returns false.
+func (t *bTree[T]) DescendLessOrEqual(pivot T, iterator treeIterator[T]) {
+	less := t.cow.less
+	t.descend(
+		func(a T) bool { return !less(pivot, a) },
+		func(a T) bool { return true },
+		iterator)
+}
+
+// DescendGreaterThan calls the iterator for every value in the tree within
+// the range [last, pivot), in descending order, until iterator returns false.
+func (t *bTree[T]) DescendGreaterThan(pivot T, iterator treeIterator[T]) {
+	less := t.cow.less
+	t.descend(
+		func(a T) bool { return true },
+		func(a T) bool { return less(pivot, a) },
 		iterator)
 }
 
-// Get looks for the key item in the tree, returning it.  It returns nil if
-// unable to find that item.
-func (t *bTree) Get(key bItem) bItem {
+// Descend calls the iterator for every value in the tree within the range
+// [last, first], in descending order, until iterator returns false.
+func (t *bTree[T]) Descend(iterator treeIterator[T]) {
+	t.descend(
+		func(a T) bool { return true },
+		func(a T) bool { return true },
+		iterator)
+}
+
+func (t *bTree[T]) descend(from, to func(T) bool, iterator treeIterator[T]) {
 	if t.root == nil {
-		return nil
+		return
+	}
+	t.root.reverse(from, to, iterator)
+}
+
+// Get looks for the key item in the tree, returning it.  It returns
+// (zeroValue, false) if unable to find that item.
+func (t *bTree[T]) Get(key T) (_ T, _ bool) {
+	if t.root == nil {
+		return
 	}
 	return t.root.get(key)
 }
 
+// Rank returns the number of items in the tree which are less than key.
+func (t *bTree[T]) Rank(key T) int {
+	if t.root == nil {
+		return 0
+	}
+	return t.root.rank(key)
+}
+
+// Select returns the item at position 'pos' (zero-based) in the order of
+// the tree.  It returns (zeroValue, false) if pos is out of range.
+func (t *bTree[T]) Select(pos int) (_ T, _ bool) {
+	if t.root == nil || pos < 0 || pos >= t.root.size {
+		return
+	}
+	return t.root.nth(pos), true
+}
+
 // Has returns true if the given key is in the tree.
-func (t *bTree) Has(key bItem) bool {
-	return t.Get(key) != nil
+func (t *bTree[T]) Has(key T) bool {
+	_, ok := t.Get(key)
+	return ok
 }
 
 // Len returns the number of items currently in the tree.
-func (t *bTree) Len() int {
+func (t *bTree[T]) Len() int {
 	return t.length
 }
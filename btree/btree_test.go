@@ -22,16 +22,16 @@ import (
 	. "github.com/onsi/gomega"
 )
 
-// Int implements the Item interface for integers.
+// Int is the item type used in tests.
 type Int int
 
-// Less returns true if int(a) < int(b).
-func (a Int) Less(b bItem) bool {
-	return a < b.(Int)
+// lessInt returns true if int(a) < int(b).
+func lessInt(a, b Int) bool {
+	return a < b
 }
 
 // perm returns a random permutation of n Int items in the range [0, n).
-func perm(n int) (out []bItem) {
+func perm(n int) (out []Int) {
 	for _, v := range rand.Perm(n) {
 		out = append(out, Int(v))
 	}
@@ -39,7 +39,7 @@ func perm(n int) (out []bItem) {
 }
 
 // rang returns an ordered list of Int items in the range [0, n).
-func rang(n int) (out []bItem) {
+func rang(n int) (out []Int) {
 	for i := 0; i < n; i++ {
 		out = append(out, Int(i))
 	}
@@ -47,7 +47,7 @@ func rang(n int) (out []bItem) {
 }
 
 // rang returns a reversed ordered list of Int items in the range (n, 0].
-func rangInv(n int) (out []bItem) {
+func rangInv(n int) (out []Int) {
 	for i := n - 1; i >= 0; i-- {
 		out = append(out, Int(i))
 	}
@@ -55,71 +55,107 @@ func rangInv(n int) (out []bItem) {
 }
 
 // all extracts all items from a tree in order as a slice.
-func all(t *bTree) (out []bItem) {
-	t.Ascend(func(a bItem) bool {
+func all(t *bTree[Int]) (out []Int) {
+	t.Ascend(func(a Int) bool {
 		out = append(out, a)
 		return true
 	})
 	return
 }
 
+// checkSizes verifies subtree sizes of all nodes, returns the size of n.
+func checkSizes(n *node[Int]) int {
+	size := len(n.items)
+	for _, c := range n.children {
+		size += checkSizes(c)
+	}
+	Expect(n.size).To(Equal(size))
+	return size
+}
+
+// checkShape verifies the B-Tree invariants, returns the depth of n.
+func checkShape(t *bTree[Int], n *node[Int], root bool) int {
+	Expect(len(n.items)).To(BeNumerically("<=", t.maxItems()))
+	if !root {
+		Expect(len(n.items)).To(BeNumerically(">=", t.minItems()))
+	}
+	if len(n.children) == 0 {
+		return 1
+	}
+
+	Expect(n.children).To(HaveLen(len(n.items) + 1))
+	depth := checkShape(t, n.children[0], false)
+	for _, c := range n.children[1:] {
+		Expect(checkShape(t, c, false)).To(Equal(depth))
+	}
+	return depth + 1
+}
+
 var btreeDegree = flag.Int("degree", 32, "B-Tree degree")
 
 var _ = Describe("bTree", func() {
 
 	It("should insert and delete", func() {
-		tr := newTree(*btreeDegree)
+		tr := newTree(*btreeDegree, lessInt)
 		const treeSize = 10000
 		for i := 0; i < 10; i++ {
 			for _, item := range perm(treeSize) {
-				Expect(tr.ReplaceOrInsert(item)).To(BeNil())
+				_, ok := tr.ReplaceOrInsert(item)
+				Expect(ok).To(BeFalse())
 			}
 			for _, item := range perm(treeSize) {
-				Expect(tr.ReplaceOrInsert(item)).NotTo(BeNil())
+				out, ok := tr.ReplaceOrInsert(item)
+				Expect(ok).To(BeTrue())
+				Expect(out).To(Equal(item))
 			}
 			Expect(all(tr)).To(Equal(rang(treeSize)))
+			Expect(checkSizes(tr.root)).To(Equal(treeSize))
 
 			for _, item := range perm(treeSize) {
-				Expect(tr.Delete(item)).NotTo(BeNil())
+				out, ok := tr.Delete(item)
+				Expect(ok).To(BeTrue())
+				Expect(out).To(Equal(item))
 			}
+			_, ok := tr.Delete(Int(0))
+			Expect(ok).To(BeFalse())
 			Expect(all(tr)).To(BeEmpty())
 		}
 	})
 
 	It("should delete min", func() {
-		tr := newTree(3)
+		tr := newTree(3, lessInt)
 		for _, v := range perm(100) {
 			tr.ReplaceOrInsert(v)
 		}
 
-		coll := make([]bItem, 0, 100)
-		for v := tr.DeleteMin(); v != nil; v = tr.DeleteMin() {
+		coll := make([]Int, 0, 100)
+		for v, ok := tr.DeleteMin(); ok; v, ok = tr.DeleteMin() {
 			coll = append(coll, v)
 		}
 		Expect(coll).To(Equal(rang(100)))
 	})
 
 	It("should delete max", func() {
-		tr := newTree(3)
+		tr := newTree(3, lessInt)
 		for _, v := range perm(100) {
 			tr.ReplaceOrInsert(v)
 		}
 
-		coll := make([]bItem, 0, 100)
-		for v := tr.DeleteMax(); v != nil; v = tr.DeleteMax() {
+		coll := make([]Int, 0, 100)
+		for v, ok := tr.DeleteMax(); ok; v, ok = tr.DeleteMax() {
 			coll = append(coll, v)
 		}
 		Expect(coll).To(Equal(rangInv(100)))
 	})
 
 	It("should ascend range #1", func() {
-		tr := newTree(2)
+		tr := newTree(2, lessInt)
 		for _, v := range perm(100) {
 			tr.ReplaceOrInsert(v)
 		}
 
-		coll := make([]bItem, 0, 100)
-		tr.AscendRange(Int(40), Int(60), func(a bItem) bool {
+		coll := make([]Int, 0, 100)
+		tr.AscendRange(Int(40), Int(60), func(a Int) bool {
 			coll = append(coll, a)
 			return true
 		})
@@ -127,14 +163,14 @@ var _ = Describe("bTree", func() {
 	})
 
 	It("should ascend range #2", func() {
-		tr := newTree(2)
+		tr := newTree(2, lessInt)
 		for _, v := range perm(100) {
 			tr.ReplaceOrInsert(v)
 		}
 
-		coll := make([]bItem, 0, 100)
-		tr.AscendRange(Int(40), Int(60), func(a bItem) bool {
-			if a.(Int) > 50 {
+		coll := make([]Int, 0, 100)
+		tr.AscendRange(Int(40), Int(60), func(a Int) bool {
+			if a > 50 {
 				return false
 			}
 			coll = append(coll, a)
@@ -144,13 +180,13 @@ var _ = Describe("bTree", func() {
 	})
 
 	It("should ascend less than #1", func() {
-		tr := newTree(*btreeDegree)
+		tr := newTree(*btreeDegree, lessInt)
 		for _, v := range perm(100) {
 			tr.ReplaceOrInsert(v)
 		}
 
-		coll := make([]bItem, 0, 100)
-		tr.AscendLessThan(Int(60), func(a bItem) bool {
+		coll := make([]Int, 0, 100)
+		tr.AscendLessThan(Int(60), func(a Int) bool {
 			coll = append(coll, a)
 			return true
 		})
@@ -158,14 +194,14 @@ var _ = Describe("bTree", func() {
 	})
 
 	It("should ascend less than #2", func() {
-		tr := newTree(*btreeDegree)
+		tr := newTree(*btreeDegree, lessInt)
 		for _, v := range perm(100) {
 			tr.ReplaceOrInsert(v)
 		}
 
-		coll := make([]bItem, 0, 100)
-		tr.AscendLessThan(Int(60), func(a bItem) bool {
-			if a.(Int) > 50 {
+		coll := make([]Int, 0, 100)
+		tr.AscendLessThan(Int(60), func(a Int) bool {
+			if a > 50 {
 				return false
 			}
 			coll = append(coll, a)
@@ -175,13 +211,13 @@ var _ = Describe("bTree", func() {
 	})
 
 	It("should ascend goe #1", func() {
-		tr := newTree(*btreeDegree)
+		tr := newTree(*btreeDegree, lessInt)
 		for _, v := range perm(100) {
 			tr.ReplaceOrInsert(v)
 		}
 
-		coll := make([]bItem, 0, 100)
-		tr.AscendGreaterOrEqual(Int(40), func(a bItem) bool {
+		coll := make([]Int, 0, 100)
+		tr.AscendGreaterOrEqual(Int(40), func(a Int) bool {
 			coll = append(coll, a)
 			return true
 		})
@@ -189,14 +225,14 @@ var _ = Describe("bTree", func() {
 	})
 
 	It("should ascend goe #3", func() {
-		tr := newTree(*btreeDegree)
+		tr := newTree(*btreeDegree, lessInt)
 		for _, v := range perm(100) {
 			tr.ReplaceOrInsert(v)
 		}
 
-		coll := make([]bItem, 0, 100)
-		tr.AscendGreaterOrEqual(Int(40), func(a bItem) bool {
-			if a.(Int) > 50 {
+		coll := make([]Int, 0, 100)
+		tr.AscendGreaterOrEqual(Int(40), func(a Int) bool {
+			if a > 50 {
 				return false
 			}
 			coll = append(coll, a)
@@ -205,4 +241,163 @@ var _ = Describe("bTree", func() {
 		Expect(coll).To(Equal(rang(100)[40:51]))
 	})
 
+	It("should descend", func() {
+		tr := newTree(2, lessInt)
+		for _, v := range perm(100) {
+			tr.ReplaceOrInsert(v)
+		}
+
+		coll := make([]Int, 0, 100)
+		tr.Descend(func(a Int) bool {
+			coll = append(coll, a)
+			return true
+		})
+		Expect(coll).To(Equal(rangInv(100)))
+	})
+
+	It("should descend range", func() {
+		tr := newTree(2, lessInt)
+		for _, v := range perm(100) {
+			tr.ReplaceOrInsert(v)
+		}
+
+		coll := make([]Int, 0, 100)
+		tr.DescendRange(Int(60), Int(40), func(a Int) bool {
+			coll = append(coll, a)
+			return true
+		})
+		Expect(coll).To(Equal(rangInv(100)[39:59]))
+
+		coll = coll[:0]
+		tr.DescendRange(Int(60), Int(40), func(a Int) bool {
+			if a < 50 {
+				return false
+			}
+			coll = append(coll, a)
+			return true
+		})
+		Expect(coll).To(Equal(rangInv(100)[39:50]))
+	})
+
+	It("should descend less or equal", func() {
+		tr := newTree(*btreeDegree, lessInt)
+		for _, v := range perm(100) {
+			tr.ReplaceOrInsert(v)
+		}
+
+		coll := make([]Int, 0, 100)
+		tr.DescendLessOrEqual(Int(40), func(a Int) bool {
+			coll = append(coll, a)
+			return true
+		})
+		Expect(coll).To(Equal(rangInv(100)[59:]))
+	})
+
+	It("should descend greater than", func() {
+		tr := newTree(*btreeDegree, lessInt)
+		for _, v := range perm(100) {
+			tr.ReplaceOrInsert(v)
+		}
+
+		coll := make([]Int, 0, 100)
+		tr.DescendGreaterThan(Int(40), func(a Int) bool {
+			coll = append(coll, a)
+			return true
+		})
+		Expect(coll).To(Equal(rangInv(100)[:59]))
+	})
+
+	It("should clone", func() {
+		tr := newTree(*btreeDegree, lessInt)
+		for _, v := range perm(100) {
+			tr.ReplaceOrInsert(v)
+		}
+
+		clone := tr.Clone()
+		for i := 0; i < 50; i++ {
+			tr.Delete(Int(i))
+		}
+		for i := 100; i < 150; i++ {
+			clone.ReplaceOrInsert(Int(i))
+		}
+
+		Expect(all(tr)).To(Equal(rang(100)[50:]))
+		Expect(all(clone)).To(Equal(rang(150)))
+		Expect(tr.Len()).To(Equal(50))
+		Expect(clone.Len()).To(Equal(150))
+	})
+
+	It("should maintain subtree sizes", func() {
+		tr := newTree(2, lessInt)
+		for _, v := range perm(500) {
+			tr.ReplaceOrInsert(v)
+		}
+		Expect(checkSizes(tr.root)).To(Equal(500))
+
+		clone := tr.Clone()
+		for _, v := range perm(500)[:300] {
+			tr.Delete(v)
+			tr.ReplaceOrInsert(v + 1000)
+		}
+		for i := 0; i < 50; i++ {
+			tr.DeleteMin()
+			tr.DeleteMax()
+		}
+		Expect(checkSizes(tr.root)).To(Equal(400))
+		Expect(checkSizes(clone.root)).To(Equal(500))
+	})
+
+	It("should rank and select", func() {
+		tr := newTree(*btreeDegree, lessInt)
+		Expect(tr.Rank(Int(5))).To(Equal(0))
+		_, ok := tr.Select(0)
+		Expect(ok).To(BeFalse())
+
+		for _, v := range perm(100) {
+			tr.ReplaceOrInsert(v * 2)
+		}
+		for i := 0; i < 100; i++ {
+			Expect(tr.Rank(Int(i * 2))).To(Equal(i))
+			Expect(tr.Rank(Int(i*2 + 1))).To(Equal(i + 1))
+
+			item, ok := tr.Select(i)
+			Expect(ok).To(BeTrue())
+			Expect(item).To(Equal(Int(i * 2)))
+		}
+		Expect(tr.Rank(Int(-1))).To(Equal(0))
+		_, ok = tr.Select(100)
+		Expect(ok).To(BeFalse())
+		_, ok = tr.Select(-1)
+		Expect(ok).To(BeFalse())
+	})
+
+	It("should load sorted items", func() {
+		for _, degree := range []int{2, 3, 32} {
+			for _, size := range []int{0, 1, 2, 3, 4, 5, 7, 8, 63, 64, 65, 100, 1000, 4097} {
+				tr := newTree(degree, lessInt)
+				tr.ReplaceOrInsert(Int(-1))
+				tr.Load(rang(size))
+
+				Expect(tr.Len()).To(Equal(size))
+				Expect(all(tr)).To(Equal(rang(size)))
+				if size == 0 {
+					Expect(tr.root).To(BeNil())
+					continue
+				}
+				checkShape(tr, tr.root, true)
+				Expect(checkSizes(tr.root)).To(Equal(size))
+
+				// tree must remain fully functional
+				for _, v := range perm(size) {
+					if v%2 == 0 {
+						tr.Delete(v)
+					}
+					tr.ReplaceOrInsert(v + Int(size))
+				}
+				checkShape(tr, tr.root, true)
+				Expect(checkSizes(tr.root)).To(Equal(tr.Len()))
+			}
+		}
+	})
+
 })
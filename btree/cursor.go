@@ -0,0 +1,96 @@
+package btree
+
+import "github.com/bsm/rumcask"
+
+// Cursor is a stateful iterator over a KeyStore or a Snapshot. Each
+// move re-seeks from the current key, so cursors over a KeyStore
+// tolerate concurrent modifications.
+type Cursor struct {
+	view  func() *bTree[pair]
+	key   []byte
+	ref   rumcask.PageRef
+	valid bool
+}
+
+// Seek moves the cursor to the first key >= key, a nil key
+// seeks to the first key. Returns false if there is no such key.
+func (c *Cursor) Seek(key []byte) bool {
+	c.valid = false
+	if key == nil {
+		c.view().Ascend(c.take)
+	} else {
+		c.view().AscendGreaterOrEqual(pair{K: key}, c.take)
+	}
+	return c.valid
+}
+
+// Last moves the cursor to the last key.
+// Returns false if the store is empty.
+func (c *Cursor) Last() bool {
+	c.valid = false
+	c.view().Descend(c.take)
+	return c.valid
+}
+
+// Next moves the cursor to the next key.
+// Returns false if there is none, or if the cursor is not positioned.
+func (c *Cursor) Next() bool {
+	if !c.valid {
+		return false
+	}
+
+	c.valid = false
+	c.view().AscendGreaterOrEqual(pair{K: c.key}, c.takeOther)
+	return c.valid
+}
+
+// Prev moves the cursor to the previous key.
+// Returns false if there is none, or if the cursor is not positioned.
+func (c *Cursor) Prev() bool {
+	if !c.valid {
+		return false
+	}
+
+	c.valid = false
+	c.view().DescendLessOrEqual(pair{K: c.key}, c.takeOther)
+	return c.valid
+}
+
+// Valid returns true if the cursor is positioned at a key
+func (c *Cursor) Valid() bool { return c.valid }
+
+// Key returns the key at the current position
+func (c *Cursor) Key() []byte {
+	if !c.valid {
+		return nil
+	}
+	return c.key
+}
+
+// Ref returns the ref at the current position
+func (c *Cursor) Ref() rumcask.PageRef {
+	if !c.valid {
+		return rumcask.PageRef{}
+	}
+	return c.ref
+}
+
+// Positions the cursor at item
+func (c *Cursor) take(kv pair) bool {
+	c.key, c.ref, c.valid = kv.K, kv.R, true
+	return false
+}
+
+// Positions the cursor at item, unless it is the current key
+func (c *Cursor) takeOther(kv pair) bool {
+	if !c.valid && c.equal(kv.K, c.key) {
+		return true
+	}
+	return c.take(kv)
+}
+
+// Returns true if keys a and b are equivalent
+func (c *Cursor) equal(a, b []byte) bool {
+	less := c.view().cow.less
+	return !less(pair{K: a}, pair{K: b}) && !less(pair{K: b}, pair{K: a})
+}
@@ -2,59 +2,87 @@ package btree
 
 import (
 	"bytes"
+	"sort"
 	"sync"
+	"sync/atomic"
 
 	"github.com/bsm/rumcask"
 )
 
+// A key/ref pair, stored by value in tree nodes
 type pair struct {
 	K []byte
 	R rumcask.PageRef
 }
 
-func (kv *pair) Less(than bItem) bool {
-	return bytes.Compare(kv.K, than.(*pair).K) < 0
+func lessPair(a, b pair) bool {
+	return bytes.Compare(a.K, b.K) < 0
+}
+
+// Returns the pair ordering of a comparator
+func pairLess(cmp rumcask.Comparator) lessFunc[pair] {
+	if cmp == rumcask.BytewiseComparator {
+		return lessPair
+	}
+	return func(a, b pair) bool { return cmp.Compare(a.K, b.K) < 0 }
 }
 
 // Iterator allows callers to iterate the key/ref pairs
-// in lexical order. When this function returns false,
+// in the order of the store's comparator. When this function returns false,
 // iteration will stop immediately.
-type Iterator func(key []byte, ref rumcask.PageRef) bool
+type Iterator = rumcask.Iterator
 
 // A btree based KeyStore implementation.
 // Keys are iterable and are held in memory.
+//
+// Writers modify a copy-on-write tree and publish a frozen
+// clone after each change. Readers and iterations work on
+// the latest published clone and never block writers.
 type KeyStore struct {
-	tree *bTree
-	lock sync.RWMutex
+	tree *bTree[pair] // mutable, guarded by lock
+	snap atomic.Value // *Snapshot
+	cmp  rumcask.Comparator
+	lock sync.Mutex
 }
 
-// NewKeyStore creates a new, empty BTree key store
+// NewKeyStore creates a new, empty BTree key store,
+// keys are ordered lexically. Use NewKeyStoreWithComparator
+// for other orderings, NewKeyStore keeps its signature for
+// compatibility with existing callers.
 func NewKeyStore(degree int) *KeyStore {
-	return &KeyStore{tree: newTree(degree)}
+	return NewKeyStoreWithComparator(degree, nil)
 }
 
-// Fetch retrieves the ref at key
-func (s *KeyStore) Fetch(key []byte) (_ rumcask.PageRef, _ bool) {
-	s.lock.RLock()
-	defer s.lock.RUnlock()
-
-	item := s.tree.Get(&pair{K: key})
-	if item == nil {
-		return
+// NewKeyStoreWithComparator creates a new, empty BTree key store,
+// keys are ordered by cmp. A nil cmp orders keys lexically.
+func NewKeyStoreWithComparator(degree int, cmp rumcask.Comparator) *KeyStore {
+	if cmp == nil {
+		cmp = rumcask.BytewiseComparator
 	}
-	return item.(*pair).R, true
+
+	s := &KeyStore{tree: newTree(degree, pairLess(cmp)), cmp: cmp}
+	s.publish()
+	return s
+}
+
+// Comparator implements rumcask.SortedKeyStore
+func (s *KeyStore) Comparator() rumcask.Comparator {
+	return s.cmp
+}
+
+// Fetch retrieves the ref at key
+func (s *KeyStore) Fetch(key []byte) (rumcask.PageRef, bool) {
+	return s.Snapshot().Fetch(key)
 }
 
 // Store stores a key/ref pair
-func (s *KeyStore) Store(key []byte, ref rumcask.PageRef) (_ rumcask.PageRef, _ bool) {
+func (s *KeyStore) Store(key []byte, ref rumcask.PageRef) (rumcask.PageRef, bool) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
-	item := s.tree.ReplaceOrInsert(&pair{key, ref})
-	if item == nil {
-		return
-	}
-	return item.(*pair).R, true
+	item, ok := s.tree.ReplaceOrInsert(pair{K: key, R: ref})
+	s.publish()
+	return item.R, ok
 }
 
 // Delete deletes a key
@@ -62,28 +90,204 @@ func (s *KeyStore) Delete(key []byte) (_ rumcask.PageRef, _ bool) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
-	item := s.tree.Delete(&pair{K: key})
-	if item == nil {
+	item, ok := s.tree.Delete(pair{K: key})
+	if !ok {
 		return
 	}
-	return item.(*pair).R, true
+	s.publish()
+	return item.R, true
+}
+
+// Reset implements rumcask.Resetter
+func (s *KeyStore) Reset() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.tree = newTree(s.tree.degree, s.tree.cow.less)
+	s.publish()
+	return nil
+}
+
+// BulkLoad implements rumcask.BulkLoader. Keys provided in the
+// order of the store's comparator are loaded in linear time,
+// unsorted input is sorted first.
+func (s *KeyStore) BulkLoad(feed func(rumcask.Iterator) error) error {
+	less := pairLess(s.cmp)
+
+	var list []pair
+	sorted := true
+	if err := feed(func(key []byte, ref rumcask.PageRef) bool {
+		kv := pair{K: key, R: ref}
+		if n := len(list); n != 0 && !less(list[n-1], kv) {
+			sorted = false
+		}
+		list = append(list, kv)
+		return true
+	}); err != nil {
+		return err
+	}
+
+	if !sorted {
+		sort.SliceStable(list, func(i, j int) bool { return less(list[i], list[j]) })
+		list = dedupePairs(list, less)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.tree.Load(list)
+	s.publish()
+	return nil
+}
+
+// Removes equivalent pairs from a sorted list, the last one wins
+func dedupePairs(list []pair, less lessFunc[pair]) []pair {
+	out := list[:0]
+	for i, kv := range list {
+		if i+1 < len(list) && !less(kv, list[i+1]) {
+			continue
+		}
+		out = append(out, kv)
+	}
+	return out
 }
 
 // Len returns the number of keys in the store
 func (s *KeyStore) Len() int {
-	s.lock.RLock()
-	defer s.lock.RUnlock()
+	return s.Snapshot().Len()
+}
 
+// Iterate iterates over a range of keys >= min and < max.
+// A nil min or max leaves the range unbounded.
+func (s *KeyStore) Iterate(min, max []byte, each Iterator) {
+	s.Snapshot().Iterate(min, max, each)
+}
+
+// ReverseIterate iterates over a range of keys >= min and < max
+// in reverse order. A nil min or max leaves the range unbounded.
+func (s *KeyStore) ReverseIterate(min, max []byte, each Iterator) {
+	s.Snapshot().ReverseIterate(min, max, each)
+}
+
+// ForEach iterates over all keys
+func (s *KeyStore) ForEach(each Iterator) {
+	s.Snapshot().ForEach(each)
+}
+
+// Rank returns the number of keys < key
+func (s *KeyStore) Rank(key []byte) int {
+	return s.Snapshot().Rank(key)
+}
+
+// Select returns the key/ref pair at position n (zero-based)
+// in key order. Returns false if n is out of range.
+func (s *KeyStore) Select(n int) ([]byte, rumcask.PageRef, bool) {
+	return s.Snapshot().Select(n)
+}
+
+// CountRange returns the number of keys >= min and < max.
+// A nil min or max leaves the range unbounded.
+func (s *KeyStore) CountRange(min, max []byte) int {
+	return s.Snapshot().CountRange(min, max)
+}
+
+// Cursor returns a new, unpositioned cursor. Each move
+// works on the latest version of the store.
+func (s *KeyStore) Cursor() *Cursor {
+	return &Cursor{view: func() *bTree[pair] { return s.Snapshot().tree }}
+}
+
+// Snapshot returns a frozen, point-in-time view of the store
+func (s *KeyStore) Snapshot() *Snapshot {
+	return s.snap.Load().(*Snapshot)
+}
+
+// Publishes a frozen clone of the tree, requires lock
+func (s *KeyStore) publish() {
+	s.snap.Store(&Snapshot{tree: s.tree.Clone()})
+}
+
+// --------------------------------------------------------------------
+
+// Snapshot is an immutable view of a KeyStore. It is safe
+// for concurrent use and does not block writers.
+type Snapshot struct {
+	tree *bTree[pair]
+}
+
+// Fetch retrieves the ref at key
+func (s *Snapshot) Fetch(key []byte) (rumcask.PageRef, bool) {
+	item, ok := s.tree.Get(pair{K: key})
+	return item.R, ok
+}
+
+// Len returns the number of keys in the snapshot
+func (s *Snapshot) Len() int {
 	return s.tree.Len()
 }
 
-// Iterate iterates over a range of keys >= min and < max
-func (s *KeyStore) Iterate(min, max []byte, each Iterator) {
-	s.lock.RLock()
-	defer s.lock.RUnlock()
+// Iterate iterates over a range of keys >= min and < max.
+// A nil min or max leaves the range unbounded.
+func (s *Snapshot) Iterate(min, max []byte, each Iterator) {
+	lower, upper := s.bounds(min, max)
+	s.tree.ascend(lower, upper, func(kv pair) bool { return each(kv.K, kv.R) })
+}
+
+// ReverseIterate iterates over a range of keys >= min and < max
+// in reverse order. A nil min or max leaves the range unbounded.
+func (s *Snapshot) ReverseIterate(min, max []byte, each Iterator) {
+	lower, upper := s.bounds(min, max)
+	s.tree.descend(upper, lower, func(kv pair) bool { return each(kv.K, kv.R) })
+}
+
+// Returns predicates for keys >= min and keys < max
+func (s *Snapshot) bounds(min, max []byte) (lower, upper func(pair) bool) {
+	less := s.tree.cow.less
+	lower = func(pair) bool { return true }
+	upper = func(pair) bool { return true }
+	if min != nil {
+		lower = func(kv pair) bool { return !less(kv, pair{K: min}) }
+	}
+	if max != nil {
+		upper = func(kv pair) bool { return less(kv, pair{K: max}) }
+	}
+	return
+}
+
+// ForEach iterates over all keys
+func (s *Snapshot) ForEach(each Iterator) {
+	s.tree.Ascend(func(kv pair) bool { return each(kv.K, kv.R) })
+}
+
+// Rank returns the number of keys < key
+func (s *Snapshot) Rank(key []byte) int {
+	return s.tree.Rank(pair{K: key})
+}
+
+// Select returns the key/ref pair at position n (zero-based)
+// in key order. Returns false if n is out of range.
+func (s *Snapshot) Select(n int) ([]byte, rumcask.PageRef, bool) {
+	kv, ok := s.tree.Select(n)
+	return kv.K, kv.R, ok
+}
+
+// CountRange returns the number of keys >= min and < max.
+// A nil min or max leaves the range unbounded.
+func (s *Snapshot) CountRange(min, max []byte) int {
+	lower, upper := 0, s.tree.Len()
+	if min != nil {
+		lower = s.tree.Rank(pair{K: min})
+	}
+	if max != nil {
+		upper = s.tree.Rank(pair{K: max})
+	}
+	if upper > lower {
+		return upper - lower
+	}
+	return 0
+}
 
-	s.tree.AscendRange(&pair{K: min}, &pair{K: max}, func(item bItem) bool {
-		kv := item.(*pair)
-		return each(kv.K, kv.R)
-	})
+// Cursor returns a new, unpositioned cursor over the snapshot
+func (s *Snapshot) Cursor() *Cursor {
+	return &Cursor{view: func() *bTree[pair] { return s.tree }}
 }
@@ -1,6 +1,13 @@
 package btree
 
 import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"sync"
+	"sync/atomic"
 	"testing"
 
 	"github.com/bsm/rumcask"
@@ -11,6 +18,11 @@ import (
 var _ = Describe("KeyStore", func() {
 	var subject *KeyStore
 	var _ rumcask.KeyStore = subject // interface assertions
+	var _ rumcask.UnorderedIterator = subject
+	var _ rumcask.SortedKeyStore = subject
+	var _ rumcask.OrderedIterator = subject
+	var _ rumcask.Counter = subject
+	var _ rumcask.Resetter = subject
 
 	BeforeEach(func() {
 		subject = NewKeyStore(3)
@@ -50,8 +62,473 @@ var _ = Describe("KeyStore", func() {
 		Expect(subject.Len()).To(Equal(2))
 	})
 
+	It("should iterate all", func() {
+		subject.Store([]byte("key2"), rumcask.PageRef{ID: 7, Offset: 8096})
+		subject.Store([]byte("key1"), rumcask.PageRef{ID: 1, Offset: 1024})
+
+		var keys []string
+		subject.ForEach(func(key []byte, _ rumcask.PageRef) bool {
+			keys = append(keys, string(key))
+			return true
+		})
+		Expect(keys).To(Equal([]string{"key1", "key2"}))
+	})
+
+	It("should iterate ranges", func() {
+		for _, key := range []string{"a", "b", "c", "d"} {
+			subject.Store([]byte(key), rumcask.PageRef{})
+		}
+
+		var keys []string
+		collect := func(key []byte, _ rumcask.PageRef) bool {
+			keys = append(keys, string(key))
+			return true
+		}
+
+		subject.Iterate([]byte("b"), []byte("d"), collect)
+		Expect(keys).To(Equal([]string{"b", "c"}))
+
+		keys = keys[:0]
+		subject.Iterate([]byte("b"), nil, collect)
+		Expect(keys).To(Equal([]string{"b", "c", "d"}))
+
+		keys = keys[:0]
+		subject.ReverseIterate([]byte("b"), []byte("d"), collect)
+		Expect(keys).To(Equal([]string{"c", "b"}))
+
+		keys = keys[:0]
+		subject.ReverseIterate(nil, nil, collect)
+		Expect(keys).To(Equal([]string{"d", "c", "b", "a"}))
+	})
+
+	It("should move cursors", func() {
+		for i, key := range []string{"a", "c", "e"} {
+			subject.Store([]byte(key), rumcask.PageRef{ID: uint32(i)})
+		}
+
+		cursor := subject.Cursor()
+		Expect(cursor.Valid()).To(BeFalse())
+		Expect(cursor.Next()).To(BeFalse())
+
+		Expect(cursor.Seek([]byte("b"))).To(BeTrue())
+		Expect(cursor.Key()).To(Equal([]byte("c")))
+		Expect(cursor.Ref()).To(Equal(rumcask.PageRef{ID: 1}))
+
+		Expect(cursor.Next()).To(BeTrue())
+		Expect(cursor.Key()).To(Equal([]byte("e")))
+		Expect(cursor.Prev()).To(BeTrue())
+		Expect(cursor.Prev()).To(BeTrue())
+		Expect(cursor.Key()).To(Equal([]byte("a")))
+		Expect(cursor.Prev()).To(BeFalse())
+		Expect(cursor.Key()).To(BeNil())
+
+		Expect(cursor.Last()).To(BeTrue())
+		Expect(cursor.Key()).To(Equal([]byte("e")))
+		Expect(cursor.Next()).To(BeFalse())
+		Expect(cursor.Seek([]byte("f"))).To(BeFalse())
+	})
+
+	It("should tolerate modifications between moves", func() {
+		for _, key := range []string{"a", "b", "c"} {
+			subject.Store([]byte(key), rumcask.PageRef{})
+		}
+
+		cursor := subject.Cursor()
+		Expect(cursor.Seek([]byte("b"))).To(BeTrue())
+		subject.Delete([]byte("b"))
+		subject.Store([]byte("bb"), rumcask.PageRef{})
+
+		Expect(cursor.Next()).To(BeTrue())
+		Expect(cursor.Key()).To(Equal([]byte("bb")))
+		Expect(cursor.Next()).To(BeTrue())
+		Expect(cursor.Key()).To(Equal([]byte("c")))
+	})
+
+	It("should support order statistics", func() {
+		for i, key := range []string{"a", "c", "e", "g"} {
+			subject.Store([]byte(key), rumcask.PageRef{ID: uint32(i)})
+		}
+
+		Expect(subject.Rank([]byte("a"))).To(Equal(0))
+		Expect(subject.Rank([]byte("d"))).To(Equal(2))
+		Expect(subject.Rank([]byte("z"))).To(Equal(4))
+
+		key, ref, ok := subject.Select(2)
+		Expect(ok).To(BeTrue())
+		Expect(key).To(Equal([]byte("e")))
+		Expect(ref).To(Equal(rumcask.PageRef{ID: 2}))
+		_, _, ok = subject.Select(4)
+		Expect(ok).To(BeFalse())
+
+		Expect(subject.CountRange([]byte("b"), []byte("g"))).To(Equal(2))
+		Expect(subject.CountRange([]byte("c"), nil)).To(Equal(3))
+		Expect(subject.CountRange(nil, nil)).To(Equal(4))
+		Expect(subject.CountRange([]byte("g"), []byte("a"))).To(Equal(0))
+	})
+
+	It("should order keys by comparator", func() {
+		subject = NewKeyStoreWithComparator(3, rumcask.ReverseComparator(rumcask.BytewiseComparator))
+		Expect(subject.Comparator().Name()).To(Equal("reverse(bytewise)"))
+		for _, key := range []string{"a", "c", "b", "d"} {
+			subject.Store([]byte(key), rumcask.PageRef{})
+		}
+
+		var keys []string
+		collect := func(key []byte, _ rumcask.PageRef) bool {
+			keys = append(keys, string(key))
+			return true
+		}
+
+		subject.Iterate(nil, nil, collect)
+		Expect(keys).To(Equal([]string{"d", "c", "b", "a"}))
+
+		keys = keys[:0]
+		subject.Iterate([]byte("c"), []byte("a"), collect)
+		Expect(keys).To(Equal([]string{"c", "b"}))
+
+		keys = keys[:0]
+		subject.ReverseIterate(nil, []byte("b"), collect)
+		Expect(keys).To(Equal([]string{"c", "d"}))
+		Expect(subject.CountRange(nil, []byte("b"))).To(Equal(2))
+
+		cursor := subject.Cursor()
+		Expect(cursor.Seek(nil)).To(BeTrue())
+		Expect(cursor.Key()).To(Equal([]byte("d")))
+		Expect(cursor.Next()).To(BeTrue())
+		Expect(cursor.Key()).To(Equal([]byte("c")))
+	})
+
+	It("should treat equivalent keys as equal", func() {
+		subject = NewKeyStoreWithComparator(3, rumcask.CaseFoldComparator)
+		subject.Store([]byte("Key"), rumcask.PageRef{ID: 1})
+		prev, ok := subject.Store([]byte("KEY"), rumcask.PageRef{ID: 2})
+		Expect(ok).To(BeTrue())
+		Expect(prev).To(Equal(rumcask.PageRef{ID: 1}))
+
+		ref, ok := subject.Fetch([]byte("key"))
+		Expect(ok).To(BeTrue())
+		Expect(ref).To(Equal(rumcask.PageRef{ID: 2}))
+		Expect(subject.Len()).To(Equal(1))
+	})
+
+	It("should bulk load", func() {
+		feed := func(keys ...string) func(rumcask.Iterator) error {
+			return func(each rumcask.Iterator) error {
+				for i, key := range keys {
+					each([]byte(key), rumcask.PageRef{ID: uint32(i)})
+				}
+				return nil
+			}
+		}
+
+		subject.Store([]byte("x"), rumcask.PageRef{})
+		Expect(subject.BulkLoad(feed("a", "b", "c", "d", "e", "f", "g"))).NotTo(HaveOccurred())
+		Expect(subject.Len()).To(Equal(7))
+		_, ok := subject.Fetch([]byte("x"))
+		Expect(ok).To(BeFalse())
+		ref, ok := subject.Fetch([]byte("e"))
+		Expect(ok).To(BeTrue())
+		Expect(ref).To(Equal(rumcask.PageRef{ID: 4}))
+
+		Expect(subject.BulkLoad(feed("c", "a", "b", "a"))).NotTo(HaveOccurred())
+		var keys []string
+		subject.ForEach(func(key []byte, _ rumcask.PageRef) bool {
+			keys = append(keys, string(key))
+			return true
+		})
+		Expect(keys).To(Equal([]string{"a", "b", "c"}))
+		ref, _ = subject.Fetch([]byte("a"))
+		Expect(ref).To(Equal(rumcask.PageRef{ID: 3}))
+	})
+
+	It("should bulk load checkpoints on open", func() {
+		dir, err := ioutil.TempDir("", "rumcask-btree")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(dir)
+
+		db, err := rumcask.Open(dir, subject)
+		Expect(err).NotTo(HaveOccurred())
+		for i := 0; i < 100; i++ {
+			_, err = db.Set([]byte(fmt.Sprintf("key%03d", i)), []byte("val"))
+			Expect(err).NotTo(HaveOccurred())
+		}
+		Expect(db.Close()).NotTo(HaveOccurred())
+
+		store := &countingKeyStore{KeyStore: NewKeyStore(3)}
+		db, err = rumcask.Open(dir, store)
+		Expect(err).NotTo(HaveOccurred())
+		defer db.Close()
+
+		Expect(store.bulkLoads).To(Equal(1))
+		Expect(store.Len()).To(Equal(100))
+		val, err := db.Get([]byte("key042"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(val).To(Equal([]byte("val")))
+	})
+
+	It("should list key ranges through the DB", func() {
+		dir, err := ioutil.TempDir("", "rumcask-btree")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(dir)
+
+		db, err := rumcask.Open(dir, subject)
+		Expect(err).NotTo(HaveOccurred())
+		defer db.Close()
+
+		for _, key := range []string{"d", "b", "a", "c"} {
+			_, err = db.Set([]byte(key), []byte("val"))
+			Expect(err).NotTo(HaveOccurred())
+		}
+		keys, err := db.Keys()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(keys).To(Equal([][]byte{[]byte("a"), []byte("b"), []byte("c"), []byte("d")}))
+		keys, err = db.KeyRange([]byte("b"), []byte("d"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(keys).To(Equal([][]byte{[]byte("b"), []byte("c")}))
+
+		Expect(db.Clear()).NotTo(HaveOccurred())
+		Expect(subject.Len()).To(Equal(0))
+		Expect(subject.Snapshot().Len()).To(Equal(0))
+	})
+
+	It("should get equivalent keys through the DB", func() {
+		dir, err := ioutil.TempDir("", "rumcask-btree")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(dir)
+
+		subject = NewKeyStoreWithComparator(8, rumcask.CaseFoldComparator)
+		db, err := rumcask.OpenWithOptions(dir, subject, &rumcask.Options{BloomFalsePositiveRate: 0.01})
+		Expect(err).NotTo(HaveOccurred())
+		defer db.Close()
+
+		_, err = db.Set([]byte("a"), []byte("val"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(db.Get([]byte("A"))).To(Equal([]byte("val")))
+		Expect(db.Get([]byte("a"))).To(Equal([]byte("val")))
+		_, err = db.Get([]byte("b"))
+		Expect(err).To(Equal(rumcask.ERROR_NOT_FOUND))
+
+		stats, err := db.Stats()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stats.ChecksumFailures).To(BeZero())
+
+		report, err := db.Verify(nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.OK()).To(BeTrue())
+	})
+
+	It("should take snapshots", func() {
+		subject.Store([]byte("key1"), rumcask.PageRef{ID: 1})
+		snap := subject.Snapshot()
+
+		subject.Store([]byte("key1"), rumcask.PageRef{ID: 2})
+		subject.Store([]byte("key2"), rumcask.PageRef{ID: 3})
+		Expect(subject.Len()).To(Equal(2))
+
+		Expect(snap.Len()).To(Equal(1))
+		ref, ok := snap.Fetch([]byte("key1"))
+		Expect(ok).To(BeTrue())
+		Expect(ref).To(Equal(rumcask.PageRef{ID: 1}))
+		_, ok = snap.Fetch([]byte("key2"))
+		Expect(ok).To(BeFalse())
+	})
+
+	It("should not block writers while iterating", func() {
+		for i := 0; i < 100; i++ {
+			subject.Store([]byte{byte(i)}, rumcask.PageRef{})
+		}
+
+		n := 0
+		subject.ForEach(func(key []byte, _ rumcask.PageRef) bool {
+			subject.Delete(key)
+			subject.Store(append(key, 'x'), rumcask.PageRef{})
+			n++
+			return true
+		})
+		Expect(n).To(Equal(100))
+		Expect(subject.Len()).To(Equal(100))
+		_, ok := subject.Fetch([]byte{0, 'x'})
+		Expect(ok).To(BeTrue())
+	})
+
+	It("should support concurrent readers", func() {
+		var wg sync.WaitGroup
+		for w := 0; w < 4; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				defer GinkgoRecover()
+
+				for i := 0; i < 500; i++ {
+					key := []byte(fmt.Sprintf("%d.%d", w, i))
+					subject.Store(key, rumcask.PageRef{ID: uint32(i)})
+					ref, ok := subject.Fetch(key)
+					Expect(ok).To(BeTrue())
+					Expect(ref.ID).To(Equal(uint32(i)))
+					subject.Iterate(nil, nil, func(_ []byte, _ rumcask.PageRef) bool { return true })
+					if i%2 == 0 {
+						subject.Delete(key)
+					}
+				}
+			}(w)
+		}
+		wg.Wait()
+		Expect(subject.Len()).To(Equal(1000))
+	})
+
 })
 
+func BenchmarkKeyStore_Fetch(b *testing.B)     { benchKeyStore_fetch(b, NewKeyStore(32)) }
+func BenchmarkKeyStore_Store(b *testing.B)     { benchKeyStore_store(b, NewKeyStore(32)) }
+func BenchmarkKeyStore_Iterate(b *testing.B)   { benchKeyStore_iterate(b, NewKeyStore(32)) }
+func BenchmarkIfaceStore_Fetch(b *testing.B)   { benchKeyStore_fetch(b, newIfaceStore(32)) }
+func BenchmarkIfaceStore_Store(b *testing.B)   { benchKeyStore_store(b, newIfaceStore(32)) }
+func BenchmarkIfaceStore_Iterate(b *testing.B) { benchKeyStore_iterate(b, newIfaceStore(32)) }
+
+type benchStore interface {
+	rumcask.KeyStore
+	Iterate(min, max []byte, each Iterator)
+}
+
+const benchStoreSize = 100000
+
+func benchKeys(n int) [][]byte {
+	keys := make([][]byte, n)
+	for i, v := range rand.Perm(n) {
+		keys[i] = []byte(fmt.Sprintf("KEY%08d", v))
+	}
+	return keys
+}
+
+func benchKeyStore_fetch(b *testing.B, store benchStore) {
+	keys := benchKeys(benchStoreSize)
+	for _, key := range keys {
+		store.Store(key, rumcask.PageRef{})
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, ok := store.Fetch(keys[i%len(keys)]); !ok {
+			b.Fatal("key not found")
+		}
+	}
+}
+
+func benchKeyStore_store(b *testing.B, store benchStore) {
+	keys := benchKeys(benchStoreSize)
+	for _, key := range keys[:benchStoreSize/2] {
+		store.Store(key, rumcask.PageRef{})
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		store.Store(keys[i%len(keys)], rumcask.PageRef{Offset: uint32(i)})
+	}
+}
+
+func benchKeyStore_iterate(b *testing.B, store benchStore) {
+	for _, key := range benchKeys(benchStoreSize) {
+		store.Store(key, rumcask.PageRef{})
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		n := 0
+		store.Iterate(nil, nil, func(_ []byte, _ rumcask.PageRef) bool {
+			n++
+			return true
+		})
+		if n != benchStoreSize {
+			b.Fatalf("expected %d keys, got %d", benchStoreSize, n)
+		}
+	}
+}
+
+// ifaceStore mirrors the previous KeyStore implementation, which boxed
+// each pair behind an interface, for comparison in benchmarks.
+type ifaceStore struct {
+	tree *bTree[ifaceItem]
+	snap atomic.Value
+	lock sync.Mutex
+}
+
+type ifaceItem interface {
+	Less(than ifaceItem) bool
+}
+
+type ifacePair struct {
+	K []byte
+	R rumcask.PageRef
+}
+
+func (kv *ifacePair) Less(than ifaceItem) bool {
+	return bytes.Compare(kv.K, than.(*ifacePair).K) < 0
+}
+
+func newIfaceStore(degree int) *ifaceStore {
+	s := &ifaceStore{tree: newTree(degree, func(a, b ifaceItem) bool { return a.Less(b) })}
+	s.snap.Store(s.tree.Clone())
+	return s
+}
+
+func (s *ifaceStore) Fetch(key []byte) (_ rumcask.PageRef, _ bool) {
+	item, ok := s.snap.Load().(*bTree[ifaceItem]).Get(&ifacePair{K: key})
+	if !ok {
+		return
+	}
+	return item.(*ifacePair).R, true
+}
+
+func (s *ifaceStore) Store(key []byte, ref rumcask.PageRef) (_ rumcask.PageRef, _ bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	item, ok := s.tree.ReplaceOrInsert(&ifacePair{K: key, R: ref})
+	s.snap.Store(s.tree.Clone())
+	if !ok {
+		return
+	}
+	return item.(*ifacePair).R, true
+}
+
+func (s *ifaceStore) Delete(key []byte) (_ rumcask.PageRef, _ bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	item, ok := s.tree.Delete(&ifacePair{K: key})
+	if !ok {
+		return
+	}
+	s.snap.Store(s.tree.Clone())
+	return item.(*ifacePair).R, true
+}
+
+func (s *ifaceStore) Iterate(min, max []byte, each Iterator) {
+	tree := s.snap.Load().(*bTree[ifaceItem])
+	iter := func(item ifaceItem) bool {
+		kv := item.(*ifacePair)
+		return each(kv.K, kv.R)
+	}
+	if max == nil {
+		tree.AscendGreaterOrEqual(&ifacePair{K: min}, iter)
+	} else {
+		tree.AscendRange(&ifacePair{K: min}, &ifacePair{K: max}, iter)
+	}
+}
+
+type countingKeyStore struct {
+	*KeyStore
+	bulkLoads int
+}
+
+func (s *countingKeyStore) BulkLoad(feed func(rumcask.Iterator) error) error {
+	s.bulkLoads++
+	return s.KeyStore.BulkLoad(feed)
+}
+
 /** Test hook **/
 
 func TestSuite(t *testing.T) {
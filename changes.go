@@ -0,0 +1,213 @@
+package rumcask
+
+import "sync"
+
+// Position is a location in the log, records are
+// ordered by page ID and offset
+type Position = PageRef
+
+// Change is a Set or Delete event
+type Change struct {
+	Key   []byte
+	Value []byte // nil for deletes
+
+	// Position of the record
+	Position Position
+	// Next is the position to resume from
+	Next Position
+}
+
+// Deleted returns true if the change is a delete
+func (c *Change) Deleted() bool {
+	return c.Value == nil
+}
+
+// ChangeFeed streams changes in log order. It replays the
+// history from the pages, then follows live writes.
+// Next and Change must not be called concurrently,
+// Close is safe to call from other goroutines.
+type ChangeFeed struct {
+	db     *DB
+	pos    Position
+	change Change
+	err    error
+
+	closer chan struct{}
+	once   sync.Once
+}
+
+// Changes returns a feed of all changes from position from, which
+// is usually the Next position of the last processed change. The
+// zero position starts at the oldest available record. Returns
+// ERROR_BAD_POSITION if the position is not available.
+func (db *DB) Changes(from Position) (*ChangeFeed, error) {
+	if from == (Position{}) {
+		from.ID = db.firstPageID()
+	}
+	if from.Offset < PAGE_HEADER_LEN {
+		from.Offset = PAGE_HEADER_LEN
+	}
+
+	page := db.page(from.ID)
+	if page == nil || from.Offset > page.pos() {
+		return nil, ERROR_BAD_POSITION
+	}
+	return &ChangeFeed{db: db, pos: from, closer: make(chan struct{})}, nil
+}
+
+// Position returns the current end of the log
+func (db *DB) Position() Position {
+	db.cLock.Lock()
+	defer db.cLock.Unlock()
+
+	return db.position()
+}
+
+// Next advances to the next change, blocks until one is
+// available. Returns false when the feed or the DB is closed,
+// or on errors.
+func (f *ChangeFeed) Next() bool {
+	for {
+		select {
+		case <-f.closer:
+			return false
+		case <-f.db.closer:
+			return false
+		default:
+		}
+
+		wait := f.db.notifier()
+		ok, err := f.read()
+		if err != nil {
+			select {
+			case <-f.db.closer: // pages were closed
+			default:
+				f.err = err
+			}
+			return false
+		} else if ok {
+			return true
+		}
+
+		select {
+		case <-wait:
+		case <-f.closer:
+			return false
+		case <-f.db.closer:
+			return false
+		}
+	}
+}
+
+// Change returns the current change. Key and Value are
+// only valid until the next call to Next.
+func (f *ChangeFeed) Change() *Change {
+	return &f.change
+}
+
+// Err returns the error which stopped the feed, if any
+func (f *ChangeFeed) Err() error {
+	return f.err
+}
+
+// Close closes the feed
+func (f *ChangeFeed) Close() error {
+	f.once.Do(func() { close(f.closer) })
+	return nil
+}
+
+// Reads the next record, returns false if none is available yet
+func (f *ChangeFeed) read() (bool, error) {
+	for {
+		// Check before reading, sealed pages are complete
+		sealed := f.db.currentID() != f.pos.ID
+
+		page := f.db.acquirePage(f.pos.ID)
+		if page == nil {
+			return false, ERROR_BAD_POSITION
+		}
+
+		if f.pos.Offset < page.pos() {
+			key, val, _, err := page.read(f.pos.Offset)
+			page.release()
+			if err != nil {
+				return false, err
+			}
+			if len(val) == 0 {
+				val = nil
+			}
+
+			next := f.pos
+			next.Offset += uint32(len(key)+len(val)) + OH_FULL
+			f.change = Change{Key: key, Value: val, Position: f.pos, Next: next}
+			f.pos = next
+			return true, nil
+		}
+		page.release()
+
+		if !sealed {
+			return false, nil
+		}
+		f.pos = Position{ID: f.db.nextPageID(f.pos.ID), Offset: PAGE_HEADER_LEN}
+	}
+}
+
+// --------------------------------------------------------------------
+
+// Returns a channel, which is closed on the next write
+func (db *DB) notifier() <-chan struct{} {
+	db.nLock.Lock()
+	defer db.nLock.Unlock()
+
+	if db.changed == nil {
+		db.changed = make(chan struct{})
+	}
+	return db.changed
+}
+
+// Wakes up all waiting feeds
+func (db *DB) notify() {
+	db.nLock.Lock()
+	defer db.nLock.Unlock()
+
+	if db.changed != nil {
+		close(db.changed)
+		db.changed = nil
+	}
+}
+
+// Returns the ID of the current page
+func (db *DB) currentID() uint32 {
+	db.pLock.RLock()
+	defer db.pLock.RUnlock()
+
+	return db.current.id
+}
+
+// Returns the ID of the oldest page
+func (db *DB) firstPageID() uint32 {
+	db.pLock.RLock()
+	defer db.pLock.RUnlock()
+
+	first := db.current.id
+	for id := range db.pages {
+		if id < first {
+			first = id
+		}
+	}
+	return first
+}
+
+// Returns the ID of the page following id
+func (db *DB) nextPageID(id uint32) uint32 {
+	db.pLock.RLock()
+	defer db.pLock.RUnlock()
+
+	next := db.current.id
+	for pid := range db.pages {
+		if pid > id && pid < next {
+			next = pid
+		}
+	}
+	return next
+}
@@ -0,0 +1,113 @@
+package rumcask
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ChangeFeed", func() {
+	var subject *DB
+
+	var next = func(feed *ChangeFeed) string {
+		Expect(feed.Next()).To(BeTrue())
+		c := feed.Change()
+		if c.Deleted() {
+			return "-" + string(c.Key)
+		}
+		return string(c.Key) + "=" + string(c.Value)
+	}
+
+	BeforeEach(func() {
+		var err error
+		subject, err = Open(testDir, NewHashKeyStore())
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = subject.Set([]byte("key1"), []byte("val1"))
+		Expect(err).NotTo(HaveOccurred())
+		_, err = subject.Set([]byte("key2"), []byte("val2"))
+		Expect(err).NotTo(HaveOccurred())
+		_, err = subject.Delete([]byte("key1"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(subject.nextPage()).NotTo(HaveOccurred())
+		_, err = subject.Set([]byte("key3"), []byte("val3"))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		subject.Close()
+	})
+
+	It("should replay history", func() {
+		feed, err := subject.Changes(Position{})
+		Expect(err).NotTo(HaveOccurred())
+		defer feed.Close()
+
+		Expect(next(feed)).To(Equal("key1=val1"))
+		Expect(feed.Change().Position).To(Equal(Position{ID: 0, Offset: 128}))
+		Expect(feed.Change().Next).To(Equal(Position{ID: 0, Offset: 144}))
+		Expect(next(feed)).To(Equal("key2=val2"))
+		Expect(next(feed)).To(Equal("-key1"))
+		Expect(next(feed)).To(Equal("key3=val3"))
+		Expect(feed.Change().Position).To(Equal(Position{ID: 1, Offset: 128}))
+		Expect(feed.Change().Next).To(Equal(subject.Position()))
+	})
+
+	It("should resume from positions", func() {
+		feed, err := subject.Changes(Position{ID: 0, Offset: 144})
+		Expect(err).NotTo(HaveOccurred())
+		defer feed.Close()
+		Expect(next(feed)).To(Equal("key2=val2"))
+
+		feed, err = subject.Changes(Position{ID: 0, Offset: 172})
+		Expect(err).NotTo(HaveOccurred())
+		defer feed.Close()
+		Expect(next(feed)).To(Equal("key3=val3"))
+
+		_, err = subject.Changes(Position{ID: 7})
+		Expect(err).To(Equal(ERROR_BAD_POSITION))
+		_, err = subject.Changes(Position{ID: 0, Offset: 4096})
+		Expect(err).To(Equal(ERROR_BAD_POSITION))
+	})
+
+	It("should follow live writes", func() {
+		feed, err := subject.Changes(subject.Position())
+		Expect(err).NotTo(HaveOccurred())
+		defer feed.Close()
+
+		changes := make(chan string, 10)
+		go func() {
+			defer GinkgoRecover()
+			defer close(changes)
+			for feed.Next() {
+				changes <- string(feed.Change().Key)
+			}
+		}()
+
+		Consistently(changes, 10*time.Millisecond).ShouldNot(Receive())
+		_, err = subject.Set([]byte("key4"), []byte("val4"))
+		Expect(err).NotTo(HaveOccurred())
+		Eventually(changes).Should(Receive(Equal("key4")))
+
+		Expect(subject.nextPage()).NotTo(HaveOccurred())
+		_, err = subject.Delete([]byte("key4"))
+		Expect(err).NotTo(HaveOccurred())
+		Eventually(changes).Should(Receive(Equal("key4")))
+
+		Expect(feed.Close()).NotTo(HaveOccurred())
+		Eventually(changes).Should(BeClosed())
+		Expect(feed.Err()).NotTo(HaveOccurred())
+	})
+
+	It("should stop when the DB is closed", func() {
+		feed, err := subject.Changes(subject.Position())
+		Expect(err).NotTo(HaveOccurred())
+
+		done := make(chan bool)
+		go func() { done <- feed.Next() }()
+		Expect(subject.Close()).NotTo(HaveOccurred())
+		Eventually(done).Should(Receive(BeFalse()))
+	})
+
+})
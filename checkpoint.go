@@ -0,0 +1,279 @@
+package rumcask
+
+import (
+	"bufio"
+	"bytes"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+)
+
+var _CHECKPOINT_MAGIC = []byte{'R', 'U', 'M', 'C', 'C', 'K', 'P'}
+
+// Each checkpoint file contains:
+//
+// 	MAGIC WORD        7 bytes
+// 	VERSION           1 byte
+// 	POSITION          8 bytes (page ID + offset)
+// 	PAGE COUNT        4 bytes
+// 	PAGES             8 bytes each (page ID + size)
+// 	ORDERING          2 bytes length + comparator name
+// 	ENTRIES           variable
+// 	END MARKER        2 bytes (0xffff)
+// 	CRC-32            4 bytes
+//
+// Entries are encoded as key length (2 bytes), key,
+// page ID (4 bytes) and offset (4 bytes). If an ordering
+// is given, entries are sorted by that comparator.
+type checkpoint struct {
+	Position PageRef
+	Pages    []PageRef // page sizes, stored as ID + Offset
+	Ordering string    // comparator name, blank if unsorted
+}
+
+const checkpointEnd = 0xffff
+
+// Returns true if the checkpoint matches the given pages
+func (c *checkpoint) matches(pages []*Page) bool {
+	sizes := make(map[uint32]uint32, len(c.Pages))
+	for _, ref := range c.Pages {
+		sizes[ref.ID] = ref.Offset
+	}
+
+	seen := 0
+	for _, page := range pages {
+		if page.id > c.Position.ID {
+			continue
+		}
+
+		size, ok := sizes[page.id]
+		if !ok {
+			return false
+		} else if page.id == c.Position.ID && page.pos() < size {
+			return false
+		} else if page.id < c.Position.ID && page.pos() != size {
+			return false
+		}
+		seen++
+	}
+	return seen == len(c.Pages) && sizes[c.Position.ID] == c.Position.Offset
+}
+
+// Writes a checkpoint file, atomically
+func (c *checkpoint) write(fname string, keys UnorderedIterator) error {
+	tmp := fname + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	defer file.Close()
+
+	w := newCheckpointWriter(file)
+	w.Write(_CHECKPOINT_MAGIC)
+	w.Write([]byte{VERSION})
+	w.PutUint32(c.Position.ID)
+	w.PutUint32(c.Position.Offset)
+	w.PutUint32(uint32(len(c.Pages)))
+	for _, ref := range c.Pages {
+		w.PutUint32(ref.ID)
+		w.PutUint32(ref.Offset)
+	}
+	w.PutUint16(uint16(len(c.Ordering)))
+	w.Write([]byte(c.Ordering))
+
+	keys.ForEach(func(key []byte, ref PageRef) bool {
+		w.PutUint16(uint16(len(key)))
+		w.Write(key)
+		w.PutUint32(ref.ID)
+		w.PutUint32(ref.Offset)
+		return w.err == nil
+	})
+	w.PutUint16(checkpointEnd)
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	if err := file.Sync(); err != nil {
+		return err
+	} else if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, fname)
+}
+
+// Reads a checkpoint file header, validates the checksum
+func readCheckpoint(fname string) (*checkpoint, *checkpointReader, error) {
+	file, err := os.Open(fname)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	} else if info.Size() < 28 {
+		file.Close()
+		return nil, nil, ERROR_CHECKPOINT_INVALID
+	}
+	size := info.Size()
+
+	// Validate checksum first
+	csum := make([]byte, 4)
+	if _, err := file.ReadAt(csum, size-4); err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	hash := crc32.NewIEEE()
+	if _, err := io.Copy(hash, io.NewSectionReader(file, 0, size-4)); err != nil {
+		file.Close()
+		return nil, nil, err
+	} else if hash.Sum32() != binLE.Uint32(csum) {
+		file.Close()
+		return nil, nil, ERROR_CHECKPOINT_INVALID
+	}
+
+	r := &checkpointReader{
+		file: file,
+		r:    bufio.NewReader(io.NewSectionReader(file, 0, size-4)),
+		buf:  make([]byte, MAX_KEY_LEN+8),
+	}
+
+	head := r.read(8)
+	if r.err != nil || !bytes.Equal(head[:7], _CHECKPOINT_MAGIC) || head[7] != VERSION {
+		r.Close()
+		return nil, nil, ERROR_CHECKPOINT_INVALID
+	}
+
+	c := &checkpoint{Position: r.readRef()}
+	n := int(binLE.Uint32(r.read(4)))
+	for i := 0; i < n && r.err == nil; i++ {
+		c.Pages = append(c.Pages, r.readRef())
+	}
+	if olen := int(binLE.Uint16(r.read(2))); olen <= MAX_KEY_LEN {
+		c.Ordering = string(r.read(olen))
+	} else {
+		r.err = ERROR_CHECKPOINT_INVALID
+	}
+	if r.err != nil {
+		r.Close()
+		return nil, nil, ERROR_CHECKPOINT_INVALID
+	}
+	return c, r, nil
+}
+
+// Collects the sizes of the given pages
+func checkpointPages(pages map[uint32]*Page, pos PageRef) []PageRef {
+	refs := make([]PageRef, 0, len(pages))
+	for id, page := range pages {
+		if id < pos.ID {
+			refs = append(refs, PageRef{id, page.pos()})
+		}
+	}
+	refs = append(refs, pos)
+	sort.Sort(pageRefsByID(refs))
+	return refs
+}
+
+type pageRefsByID []PageRef
+
+func (p pageRefsByID) Len() int           { return len(p) }
+func (p pageRefsByID) Less(i, j int) bool { return p[i].ID < p[j].ID }
+func (p pageRefsByID) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+// Helper to write checkpoints
+type checkpointWriter struct {
+	w    *bufio.Writer
+	hash hash.Hash32
+	buf  []byte
+	err  error
+}
+
+func newCheckpointWriter(w io.Writer) *checkpointWriter {
+	hash := crc32.NewIEEE()
+	return &checkpointWriter{
+		w:    bufio.NewWriter(io.MultiWriter(w, hash)),
+		hash: hash,
+		buf:  make([]byte, 4),
+	}
+}
+
+func (w *checkpointWriter) Write(p []byte) {
+	if w.err == nil {
+		_, w.err = w.w.Write(p)
+	}
+}
+
+func (w *checkpointWriter) PutUint16(v uint16) {
+	binLE.PutUint16(w.buf, v)
+	w.Write(w.buf[:2])
+}
+
+func (w *checkpointWriter) PutUint32(v uint32) {
+	binLE.PutUint32(w.buf, v)
+	w.Write(w.buf[:4])
+}
+
+func (w *checkpointWriter) Close() error {
+	if w.err == nil {
+		w.err = w.w.Flush()
+	}
+	if w.err == nil {
+		binLE.PutUint32(w.buf, w.hash.Sum32())
+		_, w.err = w.w.Write(w.buf[:4])
+	}
+	if w.err == nil {
+		w.err = w.w.Flush()
+	}
+	return w.err
+}
+
+// Helper to read checkpoints
+type checkpointReader struct {
+	file *os.File
+	r    *bufio.Reader
+	buf  []byte
+	err  error
+}
+
+func (r *checkpointReader) read(n int) []byte {
+	if r.err != nil {
+		return r.buf[:n]
+	}
+	_, r.err = io.ReadFull(r.r, r.buf[:n])
+	return r.buf[:n]
+}
+
+func (r *checkpointReader) readRef() PageRef {
+	b := r.read(8)
+	return PageRef{binLE.Uint32(b[0:]), binLE.Uint32(b[4:])}
+}
+
+// Reads all entries, calls the iterator for each of them
+func (r *checkpointReader) Each(each func(key []byte, ref PageRef)) error {
+	for {
+		klen := int(binLE.Uint16(r.read(2)))
+		if r.err != nil {
+			break
+		} else if klen == checkpointEnd {
+			return nil
+		} else if klen > MAX_KEY_LEN {
+			return ERROR_CHECKPOINT_INVALID
+		}
+
+		b := r.read(klen + 8)
+		if r.err != nil {
+			break
+		}
+		key := append([]byte(nil), b[:klen]...)
+		each(key, PageRef{binLE.Uint32(b[klen:]), binLE.Uint32(b[klen+4:])})
+	}
+	return ERROR_CHECKPOINT_INVALID
+}
+
+func (r *checkpointReader) Close() error {
+	return r.file.Close()
+}
@@ -0,0 +1,173 @@
+package rumcask
+
+import (
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("checkpoint", func() {
+	var subject *DB
+	var set = func(key, value string) {
+		_, err := subject.Set([]byte(key), []byte(value))
+		Expect(err).NotTo(HaveOccurred())
+	}
+	var reopen = func() *HashKeyStore {
+		keys := NewHashKeyStore()
+		db, err := Open(testDir, keys)
+		Expect(err).NotTo(HaveOccurred())
+		subject = db
+		return keys
+	}
+
+	BeforeEach(func() {
+		reopen()
+		set("key1", "val1")
+		set("key2", "val2")
+		Expect(subject.nextPage()).NotTo(HaveOccurred())
+		set("key3", "val3")
+	})
+
+	AfterEach(func() {
+		subject.Close()
+	})
+
+	It("should write checkpoints on close", func() {
+		Expect(subject.Close()).NotTo(HaveOccurred())
+
+		cp, r, err := readCheckpoint(filepath.Join(testDir, "CHECKPOINT"))
+		Expect(err).NotTo(HaveOccurred())
+		defer r.Close()
+
+		Expect(cp.Position).To(Equal(PageRef{ID: 1, Offset: 144}))
+		Expect(cp.Pages).To(Equal([]PageRef{{ID: 0, Offset: 160}, {ID: 1, Offset: 144}}))
+		Expect(cp.Ordering).To(BeEmpty())
+
+		refs := make(map[string]PageRef)
+		Expect(r.Each(func(key []byte, ref PageRef) { refs[string(key)] = ref })).NotTo(HaveOccurred())
+		Expect(refs).To(Equal(map[string]PageRef{
+			"key1": {ID: 0, Offset: 128},
+			"key2": {ID: 0, Offset: 144},
+			"key3": {ID: 1, Offset: 128},
+		}))
+	})
+
+	It("should record the ordering of sorted stores", func() {
+		Expect(subject.Close()).NotTo(HaveOccurred())
+		db, err := Open(testDir, sortedTestKeyStore{NewHashKeyStore(), Int64Comparator})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(db.Close()).NotTo(HaveOccurred())
+
+		cp, r, err := readCheckpoint(filepath.Join(testDir, "CHECKPOINT"))
+		Expect(err).NotTo(HaveOccurred())
+		defer r.Close()
+		Expect(cp.Ordering).To(Equal("int64"))
+	})
+
+	It("should load checkpoints and replay later records", func() {
+		Expect(subject.checkpoint()).NotTo(HaveOccurred())
+		set("key4", "val4")
+		set("key1", "valX")
+		_, err := subject.Delete([]byte("key2"))
+		Expect(err).NotTo(HaveOccurred())
+
+		// Simulate a crash, skip the final checkpoint
+		subject.opts.NoCheckpoints = true
+		Expect(subject.Close()).NotTo(HaveOccurred())
+
+		// Corrupt a superseded value, the page must not be parsed again
+		file, err := os.OpenFile(filepath.Join(testDir, "00000000.rcp"), os.O_WRONLY, 0644)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = file.WriteAt([]byte{'X'}, 138)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(file.Close()).NotTo(HaveOccurred())
+
+		keys := reopen()
+		Expect(keys.refs).To(Equal(map[string]PageRef{
+			"key1": {ID: 1, Offset: 160},
+			"key3": {ID: 1, Offset: 128},
+			"key4": {ID: 1, Offset: 144},
+		}))
+		val, err := subject.Get([]byte("key1"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(val).To(Equal([]byte("valX")))
+	})
+
+	It("should ignore checkpoints which don't match the pages", func() {
+		Expect(subject.Close()).NotTo(HaveOccurred())
+
+		// Add a record with another checkpoint-less store
+		db, err := OpenWithOptions(testDir, NewHashKeyStore(), &Options{NoCheckpoints: true})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(db.nextPage()).NotTo(HaveOccurred())
+		_, err = db.Set([]byte("key5"), []byte("val5"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(db.Close()).NotTo(HaveOccurred())
+
+		// Truncate the first page
+		Expect(os.Truncate(filepath.Join(testDir, "00000000.rcp"), 144)).NotTo(HaveOccurred())
+
+		keys := reopen()
+		Expect(keys.refs).To(Equal(map[string]PageRef{
+			"key1": {ID: 0, Offset: 128},
+			"key3": {ID: 1, Offset: 128},
+			"key5": {ID: 2, Offset: 128},
+		}))
+	})
+
+	It("should ignore corrupt checkpoints", func() {
+		Expect(subject.Close()).NotTo(HaveOccurred())
+
+		fname := filepath.Join(testDir, "CHECKPOINT")
+		data, err := ioutil.ReadFile(fname)
+		Expect(err).NotTo(HaveOccurred())
+		data[30] ^= 0xff
+		Expect(ioutil.WriteFile(fname, data, 0644)).NotTo(HaveOccurred())
+
+		_, _, err = readCheckpoint(fname)
+		Expect(err).To(Equal(ERROR_CHECKPOINT_INVALID))
+
+		keys := reopen()
+		Expect(keys.refs).To(HaveLen(3))
+	})
+
+	It("should reset the store if entries cannot be loaded", func() {
+		subject.keys.Store([]byte("ghost"), PageRef{ID: 0, Offset: 128})
+		Expect(subject.Close()).NotTo(HaveOccurred())
+
+		// Drop the end marker, keep the checksum valid
+		fname := filepath.Join(testDir, "CHECKPOINT")
+		data, err := ioutil.ReadFile(fname)
+		Expect(err).NotTo(HaveOccurred())
+		data = data[:len(data)-6]
+		data = append(data, 0, 0, 0, 0)
+		binLE.PutUint32(data[len(data)-4:], crc32.ChecksumIEEE(data[:len(data)-4]))
+		Expect(ioutil.WriteFile(fname, data, 0644)).NotTo(HaveOccurred())
+
+		keys := reopen()
+		Expect(keys.refs).To(Equal(map[string]PageRef{
+			"key1": {ID: 0, Offset: 128},
+			"key2": {ID: 0, Offset: 144},
+			"key3": {ID: 1, Offset: 128},
+		}))
+	})
+
+	It("should write periodic checkpoints", func() {
+		Expect(subject.Close()).NotTo(HaveOccurred())
+		Expect(os.Remove(filepath.Join(testDir, "CHECKPOINT"))).NotTo(HaveOccurred())
+
+		db, err := OpenWithOptions(testDir, NewHashKeyStore(), &Options{CheckpointInterval: 10e6})
+		Expect(err).NotTo(HaveOccurred())
+		subject = db
+
+		Eventually(func() error {
+			_, err := os.Stat(filepath.Join(testDir, "CHECKPOINT"))
+			return err
+		}).ShouldNot(HaveOccurred())
+	})
+
+})
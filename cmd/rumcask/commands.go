@@ -0,0 +1,646 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/bsm/rumcask"
+)
+
+// A key/value pair, as printed by scan and get
+type pair struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// An exported record, keys and values are base64 encoded
+type record struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+}
+
+func runGet(c *cli, db *rumcask.DB, args []string) error {
+	if len(args) != 1 {
+		return usageError("expected a single key")
+	}
+
+	val, err := db.Get([]byte(args[0]))
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.print(pair{Key: args[0], Value: string(val)}, "")
+	}
+	_, err = fmt.Fprintf(c.stdout, "%s\n", val)
+	return err
+}
+
+func runSet(c *cli, db *rumcask.DB, args []string) error {
+	var val []byte
+	switch len(args) {
+	case 1:
+		var err error
+		if val, err = ioutil.ReadAll(c.stdin); err != nil {
+			return err
+		}
+	case 2:
+		val = []byte(args[1])
+	default:
+		return usageError("expected a key and an optional value")
+	}
+
+	replaced, err := db.Set([]byte(args[0]), val)
+	if err != nil {
+		return err
+	}
+
+	status := "created"
+	if replaced {
+		status = "updated"
+	}
+	return c.print(struct {
+		Key      string `json:"key"`
+		Replaced bool   `json:"replaced"`
+	}{Key: args[0], Replaced: replaced}, "%s %s", status, display([]byte(args[0])))
+}
+
+func runDel(c *cli, db *rumcask.DB, args []string) error {
+	if len(args) == 0 {
+		return usageError("expected at least one key")
+	}
+
+	n := 0
+	for _, key := range args {
+		ok, err := db.Delete([]byte(key))
+		if err != nil {
+			return err
+		} else if ok {
+			n++
+		}
+	}
+	return c.print(struct {
+		Deleted int `json:"deleted"`
+	}{Deleted: n}, "deleted %d of %d keys", n, len(args))
+}
+
+func runKeys(c *cli, db *rumcask.DB, args []string) error {
+	keys, err := listKeys(db, "keys", args)
+	if err != nil {
+		return err
+	}
+
+	if c.json {
+		list := make([]string, len(keys))
+		for i, key := range keys {
+			list[i] = string(key)
+		}
+		return c.print(list, "")
+	}
+	for _, key := range keys {
+		if _, err := fmt.Fprintln(c.stdout, display(key)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runScan(c *cli, db *rumcask.DB, args []string) error {
+	keys, err := listKeys(db, "scan", args)
+	if err != nil {
+		return err
+	}
+
+	list := make([]pair, 0, len(keys))
+	for _, key := range keys {
+		val, err := db.Get(key)
+		if err != nil {
+			return fmt.Errorf("%s: %v", display(key), err)
+		}
+		if c.json {
+			list = append(list, pair{Key: string(key), Value: string(val)})
+		} else if _, err := fmt.Fprintf(c.stdout, "%s\t%s\n", display(key), display(val)); err != nil {
+			return err
+		}
+	}
+	if c.json {
+		return c.print(list, "")
+	}
+	return nil
+}
+
+func runStats(c *cli, db *rumcask.DB, args []string) error {
+	if len(args) != 0 {
+		return usageError("unexpected arguments")
+	}
+
+	s, err := db.Stats()
+	if err != nil {
+		return err
+	}
+
+	pos := db.Position()
+	return c.print(struct {
+		Keys             int              `json:"keys"`
+		Pages            int              `json:"pages"`
+		Bytes            int64            `json:"bytes"`
+		LiveBytes        int64            `json:"live_bytes"`
+		DeadBytes        int64            `json:"dead_bytes"`
+		Position         rumcask.Position `json:"position"`
+		Fill             float64          `json:"fill"`
+		Gets             uint64           `json:"gets"`
+		Sets             uint64           `json:"sets"`
+		Deletes          uint64           `json:"deletes"`
+		Misses           uint64           `json:"misses"`
+		ChecksumFailures uint64           `json:"checksum_failures"`
+		PageRotations    uint64           `json:"page_rotations"`
+	}{
+		Keys:             s.Keys,
+		Pages:            s.Pages,
+		Bytes:            s.TotalBytes,
+		LiveBytes:        s.LiveBytes,
+		DeadBytes:        s.DeadBytes,
+		Position:         pos,
+		Fill:             s.CurrentPageFill,
+		Gets:             s.Gets,
+		Sets:             s.Sets,
+		Deletes:          s.Deletes,
+		Misses:           s.Misses,
+		ChecksumFailures: s.ChecksumFailures,
+		PageRotations:    s.PageRotations,
+	},
+		"keys:     %d\npages:    %d\nbytes:    %d (%d live, %d dead)\nposition: %d/%d (%.2f%% full)",
+		s.Keys, s.Pages, s.TotalBytes, s.LiveBytes, s.DeadBytes, pos.ID, pos.Offset, s.CurrentPageFill*100)
+}
+
+func runVerify(c *cli, db *rumcask.DB, args []string) error {
+	flags := flag.NewFlagSet("verify", flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	rate := flags.Int64("rate", 0, "maximum bytes read per second")
+	if err := flags.Parse(args); err != nil {
+		return usageError(err.Error())
+	} else if flags.NArg() != 0 {
+		return usageError("unexpected arguments")
+	}
+
+	if db == nil {
+		m, err := rumcask.VerifyBackup(c.dir)
+		if err != nil {
+			return err
+		}
+		return c.print(struct {
+			Backup   string           `json:"backup"`
+			Files    int              `json:"files"`
+			Position rumcask.Position `json:"position"`
+		}{Backup: c.dir, Files: len(m.Files), Position: m.Position},
+			"backup ok: %d files at position %d/%d", len(m.Files), m.Position.ID, m.Position.Offset)
+	}
+
+	report, err := db.Verify(&rumcask.VerifyOptions{BytesPerSecond: *rate})
+	if err != nil {
+		return err
+	}
+
+	type pageReport struct {
+		ID        uint32   `json:"id"`
+		Size      uint32   `json:"size"`
+		HeaderErr string   `json:"header_error,omitempty"`
+		Records   int      `json:"records"`
+		Corrupt   []uint32 `json:"corrupt,omitempty"`
+		Skipped   int64    `json:"skipped,omitempty"`
+	}
+	type refError struct {
+		Key   string          `json:"key"`
+		Ref   rumcask.PageRef `json:"ref"`
+		Error string          `json:"error"`
+	}
+
+	pages := make([]pageReport, 0, len(report.Pages))
+	for _, p := range report.Pages {
+		pr := pageReport{ID: p.ID, Size: p.Size, Records: p.Records, Corrupt: p.Corrupt, Skipped: p.Skipped}
+		if p.HeaderErr != nil {
+			pr.HeaderErr = p.HeaderErr.Error()
+			if !c.json {
+				fmt.Fprintf(c.stdout, "page %d: header: %v\n", p.ID, p.HeaderErr)
+			}
+		}
+		if len(p.Corrupt) != 0 && !c.json {
+			fmt.Fprintf(c.stdout, "page %d: %d corrupt records at offsets %v, %d bytes skipped\n", p.ID, len(p.Corrupt), p.Corrupt, p.Skipped)
+		}
+		pages = append(pages, pr)
+	}
+
+	refs := make([]refError, 0, len(report.BadRefs))
+	for _, e := range report.BadRefs {
+		refs = append(refs, refError{Key: string(e.Key), Ref: e.Ref, Error: e.Err.Error()})
+		if !c.json {
+			fmt.Fprintf(c.stdout, "key %s: %d/%d: %v\n", display(e.Key), e.Ref.ID, e.Ref.Offset, e.Err)
+		}
+	}
+
+	if err := c.print(struct {
+		OK      bool         `json:"ok"`
+		Pages   []pageReport `json:"pages"`
+		Records int          `json:"records"`
+		Corrupt int          `json:"corrupt"`
+		Keys    int          `json:"keys"`
+		BadRefs []refError   `json:"bad_refs"`
+	}{OK: report.OK(), Pages: pages, Records: report.Records, Corrupt: report.Corrupt, Keys: report.Keys, BadRefs: refs},
+		"checked %d pages, %d records, %d keys: %d corrupt records, %d bad keys",
+		len(pages), report.Records, report.Keys, report.Corrupt, len(refs)); err != nil {
+		return err
+	}
+	if !report.OK() {
+		return fmt.Errorf("verification failed")
+	}
+	return nil
+}
+
+func runCompact(c *cli, db *rumcask.DB, args []string) error {
+	if len(args) != 0 {
+		return usageError("unexpected arguments")
+	}
+
+	_, before, err := pageFiles(c.dir)
+	if err != nil {
+		return err
+	}
+	if err := db.Compact(); err != nil {
+		return err
+	}
+	_, after, err := pageFiles(c.dir)
+	if err != nil {
+		return err
+	}
+
+	return c.print(struct {
+		Before int64 `json:"before"`
+		After  int64 `json:"after"`
+	}{Before: before, After: after}, "compacted %d bytes to %d bytes", before, after)
+}
+
+func runBackup(c *cli, db *rumcask.DB, args []string) error {
+	flags := flag.NewFlagSet("backup", flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	since := flags.String("since", "", "base backup directory, for incremental backups")
+	if err := flags.Parse(args); err != nil {
+		return usageError(err.Error())
+	} else if flags.NArg() != 1 {
+		return usageError("expected a destination")
+	}
+
+	var base *rumcask.Manifest
+	if *since != "" {
+		var err error
+		if base, err = rumcask.ReadManifest(*since); err != nil {
+			return err
+		}
+	}
+
+	dest := flags.Arg(0)
+	if dest == "-" {
+		_, err := db.BackupSinceTo(c.stdout, base)
+		return err
+	}
+
+	m, err := db.BackupSince(dest, base)
+	if err != nil {
+		return err
+	}
+	return c.print(m, "backup of %d files at position %d/%d written to %s",
+		len(m.Files), m.Position.ID, m.Position.Offset, dest)
+}
+
+func runDump(c *cli, _ *rumcask.DB, args []string) error {
+	flags := flag.NewFlagSet("dump", flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	limit := flags.Int("preview", 32, "maximum number of key and value bytes to print")
+	if err := flags.Parse(args); err != nil {
+		return usageError(err.Error())
+	} else if flags.NArg() == 0 {
+		return usageError("expected at least one page")
+	}
+
+	type dumpRecord struct {
+		Offset   uint32 `json:"offset"`
+		KeyLen   int    `json:"key_len"`
+		ValueLen int    `json:"value_len"`
+		Deleted  bool   `json:"deleted"`
+		Valid    bool   `json:"valid"`
+		Skipped  uint32 `json:"skipped,omitempty"`
+		Key      string `json:"key"`
+		Value    string `json:"value"`
+	}
+	type pageDump struct {
+		File      string            `json:"file"`
+		Size      int64             `json:"size"`
+		Version   uint8             `json:"version"`
+		Stats     rumcask.PageStats `json:"stats"`
+		HeaderErr string            `json:"header_error,omitempty"`
+		Records   []dumpRecord      `json:"records"`
+		Valid     int               `json:"valid"`
+		Deleted   int               `json:"deleted"`
+		Corrupt   int               `json:"corrupt"`
+		Skipped   int64             `json:"skipped"`
+	}
+
+	var dumps []pageDump
+	for _, arg := range flags.Args() {
+		fname := arg
+		if id, err := strconv.ParseUint(arg, 10, 32); err == nil {
+			fname = filepath.Join(c.dir, fmt.Sprintf("%08d.rcp", id))
+		}
+
+		var w *tabwriter.Writer
+		if !c.json {
+			w = tabwriter.NewWriter(c.stdout, 0, 8, 2, ' ', 0)
+			fmt.Fprintf(c.stdout, "%s:\n", fname)
+			fmt.Fprintln(w, "OFFSET\tKLEN\tVLEN\tDEL\tCRC\tKEY\tVALUE")
+		}
+
+		var records []dumpRecord
+		d, err := rumcask.DumpPage(fname, func(rec *rumcask.DumpRecord) bool {
+			key, val := truncate(rec.Key, *limit), truncate(rec.Value, *limit)
+			if c.json {
+				records = append(records, dumpRecord{
+					Offset:   rec.Offset,
+					KeyLen:   rec.KeyLen,
+					ValueLen: rec.ValueLen,
+					Deleted:  rec.Deleted,
+					Valid:    rec.Valid,
+					Skipped:  rec.Skipped,
+					Key:      string(key),
+					Value:    string(val),
+				})
+				return true
+			}
+
+			crc := "ok"
+			if !rec.Valid {
+				crc = fmt.Sprintf("BAD, skipped %d bytes", rec.Skipped)
+			}
+			fmt.Fprintf(w, "%d\t%d\t%d\t%s\t%s\t%s\t%s\n",
+				rec.Offset, rec.KeyLen, rec.ValueLen, yesNo(rec.Deleted), crc, preview(key, rec.KeyLen), preview(val, rec.ValueLen))
+			return true
+		})
+		if err != nil {
+			return err
+		}
+
+		if c.json {
+			pd := pageDump{
+				File:    fname,
+				Size:    d.Size,
+				Version: d.Version,
+				Stats:   d.Stats,
+				Records: records,
+				Valid:   d.Records,
+				Deleted: d.Deleted,
+				Corrupt: d.Corrupt,
+				Skipped: d.Skipped,
+			}
+			if d.HeaderErr != nil {
+				pd.HeaderErr = d.HeaderErr.Error()
+			}
+			dumps = append(dumps, pd)
+			continue
+		}
+
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		if d.HeaderErr != nil {
+			fmt.Fprintf(c.stdout, "header: %v\n", d.HeaderErr)
+		} else {
+			fmt.Fprintf(c.stdout, "header: version %d, written %d, deleted %d\n", d.Version, d.Stats.Written, d.Stats.Deleted)
+		}
+		fmt.Fprintf(c.stdout, "%d bytes, %d valid records, %d deleted, %d corrupt, %d bytes skipped\n",
+			d.Size, d.Records, d.Deleted, d.Corrupt, d.Skipped)
+	}
+
+	if c.json {
+		return c.print(dumps, "")
+	}
+	return nil
+}
+
+func runRepair(c *cli, _ *rumcask.DB, args []string) error {
+	if len(args) != 0 {
+		return usageError("unexpected arguments")
+	}
+
+	report, err := rumcask.Repair(c.dir)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.print(report, "")
+	}
+
+	for _, p := range report.Pages {
+		if p.HeaderErr != "" {
+			fmt.Fprintf(c.stdout, "page %d: header: %s\n", p.ID, p.HeaderErr)
+		}
+		fmt.Fprintf(c.stdout, "page %d: salvaged %d records, dropped %d records at offsets %v, %d bytes\n",
+			p.ID, p.Salvaged, len(p.Dropped), p.Dropped, p.Skipped)
+	}
+	if report.LostFound == "" {
+		_, err = fmt.Fprintln(c.stdout, "no damaged pages found")
+	} else {
+		_, err = fmt.Fprintf(c.stdout, "moved %d original pages to %s\n", len(report.Pages), report.LostFound)
+	}
+	return err
+}
+
+func runExport(c *cli, db *rumcask.DB, args []string) error {
+	w, err := c.output(args)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	keys, err := db.Keys()
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+	for _, key := range keys {
+		val, err := db.Get(key)
+		if err != nil {
+			return fmt.Errorf("%s: %v", display(key), err)
+		}
+		if err := enc.Encode(record{Key: key, Value: val}); err != nil {
+			return err
+		}
+	}
+	return w.Close()
+}
+
+func runImport(c *cli, db *rumcask.DB, args []string) error {
+	r, err := c.input(args)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	n := 0
+	for dec := json.NewDecoder(r); ; n++ {
+		var rec record
+		if err := dec.Decode(&rec); err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("record %d: %v", n+1, err)
+		}
+		if _, err := db.Set(rec.Key, rec.Value); err != nil {
+			return fmt.Errorf("record %d: %v", n+1, err)
+		}
+	}
+	return c.print(struct {
+		Imported int `json:"imported"`
+	}{Imported: n}, "imported %d records", n)
+}
+
+// --------------------------------------------------------------------
+
+// Parses range flags, returns the matching keys in bytewise order
+func listKeys(db *rumcask.DB, name string, args []string) ([][]byte, error) {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	prefix := flags.String("prefix", "", "only keys with this prefix, overrides -min and -max")
+	min := flags.String("min", "", "only keys >= min")
+	max := flags.String("max", "", "only keys < max")
+	limit := flags.Int("limit", 0, "maximum number of keys")
+	if err := flags.Parse(args); err != nil {
+		return nil, usageError(err.Error())
+	} else if flags.NArg() != 0 {
+		return nil, usageError("unexpected arguments")
+	}
+
+	var lo, hi []byte
+	if *min != "" {
+		lo = []byte(*min)
+	}
+	if *max != "" {
+		hi = []byte(*max)
+	}
+	if *prefix != "" {
+		lo, hi = []byte(*prefix), prefixEnd([]byte(*prefix))
+	}
+
+	keys, err := db.KeyRange(lo, hi)
+	if err == rumcask.ERROR_NOT_SUPPORTED {
+		if keys, err = db.Keys(); err == nil {
+			keys = filterKeys(keys, lo, hi)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if *limit > 0 && len(keys) > *limit {
+		keys = keys[:*limit]
+	}
+	return keys, nil
+}
+
+// Returns the smallest key greater than all keys with
+// the prefix, nil if there is none
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte(nil), prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+// Returns sorted keys >= lo and < hi
+func filterKeys(keys [][]byte, lo, hi []byte) [][]byte {
+	out := keys[:0]
+	for _, key := range keys {
+		if (lo == nil || bytes.Compare(key, lo) >= 0) && (hi == nil || bytes.Compare(key, hi) < 0) {
+			out = append(out, key)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return bytes.Compare(out[i], out[j]) < 0 })
+	return out
+}
+
+// Returns the number and total size of page files in dir
+func pageFiles(dir string) (int, int64, error) {
+	names, err := filepath.Glob(filepath.Join(dir, "*.rcp"))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var size int64
+	for _, name := range names {
+		info, err := os.Stat(name)
+		if err != nil {
+			return 0, 0, err
+		}
+		size += info.Size()
+	}
+	return len(names), size, nil
+}
+
+// Returns up to n bytes of b
+func truncate(b []byte, n int) []byte {
+	if len(b) > n {
+		return b[:n]
+	}
+	return b
+}
+
+// Returns a printable preview of the first bytes of a field
+// with length n
+func preview(b []byte, n int) string {
+	if len(b) < n {
+		return display(b) + "..."
+	}
+	return display(b)
+}
+
+func yesNo(v bool) string {
+	if v {
+		return "yes"
+	}
+	return "no"
+}
+
+// Opens the file named by the optional argument, stdout by default
+func (c *cli) output(args []string) (io.WriteCloser, error) {
+	switch {
+	case len(args) > 1:
+		return nil, usageError("expected an optional file name")
+	case len(args) == 0 || args[0] == "-":
+		return nopWriteCloser{c.stdout}, nil
+	}
+	return os.Create(args[0])
+}
+
+// Opens the file named by the optional argument, stdin by default
+func (c *cli) input(args []string) (io.ReadCloser, error) {
+	switch {
+	case len(args) > 1:
+		return nil, usageError("expected an optional file name")
+	case len(args) == 0 || args[0] == "-":
+		return ioutil.NopCloser(c.stdin), nil
+	}
+	return os.Open(args[0])
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
@@ -0,0 +1,178 @@
+// Command rumcask inspects and maintains rumcask databases.
+//
+// Usage:
+//
+//	rumcask [-dir DIR] [-json] COMMAND [ARGS]
+//
+// Commands which only read open the database read-only and
+// may run next to other readers. Commands which write require
+// exclusive access and fail while another process has the
+// database open.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/bsm/rumcask"
+	"github.com/bsm/rumcask/btree"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// A subcommand
+type command struct {
+	name  string
+	args  string
+	help  string
+	write bool // requires exclusive access
+	noDB  bool // runs without opening the DB
+	run   func(c *cli, db *rumcask.DB, args []string) error
+}
+
+var commands = []command{
+	{name: "get", args: "KEY", help: "print the value of a key", run: runGet},
+	{name: "set", args: "KEY [VALUE]", help: "store a value, reads it from stdin if omitted", write: true, run: runSet},
+	{name: "del", args: "KEY...", help: "delete keys", write: true, run: runDel},
+	{name: "keys", args: "[-prefix P] [-min K] [-max K] [-limit N]", help: "list keys in order", run: runKeys},
+	{name: "scan", args: "[-prefix P] [-min K] [-max K] [-limit N]", help: "list keys and values in order", run: runScan},
+	{name: "stats", help: "print database statistics", run: runStats},
+	{name: "verify", args: "[-rate BYTES]", help: "validate all pages, records and keys, or a backup", run: runVerify},
+	{name: "compact", help: "reclaim the space of overwritten and deleted records", write: true, run: runCompact},
+	{name: "backup", args: "[-since BASE] DEST", help: "write a backup to DEST, a tar stream to stdout if DEST is -", run: runBackup},
+	{name: "dump", args: "[-preview N] PAGE...", help: "print the records of page files or page IDs, without opening the DB", noDB: true, run: runDump},
+	{name: "repair", help: "salvage readable records of damaged pages, requires exclusive access", noDB: true, run: runRepair},
+	{name: "export", args: "[FILE]", help: "write all records as JSON lines", run: runExport},
+	{name: "import", args: "[FILE]", help: "read records from JSON lines", write: true, run: runImport},
+}
+
+// Command line context
+type cli struct {
+	dir  string
+	json bool
+
+	stdin          io.Reader
+	stdout, stderr io.Writer
+}
+
+// Usage errors exit with status 2
+type usageError string
+
+func (e usageError) Error() string { return string(e) }
+
+// Runs the command line, returns the exit status
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	c := &cli{stdin: stdin, stdout: stdout, stderr: stderr}
+
+	flags := flag.NewFlagSet("rumcask", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.StringVar(&c.dir, "dir", ".", "database directory")
+	flags.BoolVar(&c.json, "json", false, "print JSON output")
+	flags.Usage = func() { c.usage(flags) }
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	args = flags.Args()
+	if len(args) == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	for _, cmd := range commands {
+		if cmd.name == args[0] {
+			return c.exec(cmd, args[1:])
+		}
+	}
+	fmt.Fprintf(stderr, "rumcask: unknown command %q\n", args[0])
+	flags.Usage()
+	return 2
+}
+
+// Opens the DB and runs a command
+func (c *cli) exec(cmd command, args []string) int {
+	var db *rumcask.DB
+	var err error
+
+	// Backups are verified without a DB
+	if !cmd.noDB && (cmd.name != "verify" || !isBackup(c.dir)) {
+		if db, err = c.open(!cmd.write); err != nil {
+			fmt.Fprintf(c.stderr, "rumcask: %s: %v\n", c.dir, err)
+			return 1
+		}
+	}
+
+	err = cmd.run(c, db, args)
+	if db != nil {
+		if e := db.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+
+	switch err.(type) {
+	case nil:
+		return 0
+	case usageError:
+		fmt.Fprintf(c.stderr, "rumcask %s: %v\nusage: rumcask %s %s\n", cmd.name, err, cmd.name, cmd.args)
+		return 2
+	default:
+		fmt.Fprintf(c.stderr, "rumcask %s: %v\n", cmd.name, err)
+		return 1
+	}
+}
+
+// Opens the DB with an ordered key store. DBs created with a
+// custom comparator are opened with a hash key store instead.
+func (c *cli) open(readOnly bool) (*rumcask.DB, error) {
+	opts := &rumcask.Options{ReadOnly: readOnly}
+	db, err := rumcask.OpenWithOptions(c.dir, btree.NewKeyStore(32), opts)
+	if err == rumcask.ERROR_COMPARATOR_MISMATCH {
+		db, err = rumcask.OpenWithOptions(c.dir, rumcask.NewHashKeyStore(), opts)
+	}
+	return db, err
+}
+
+func (c *cli) usage(flags *flag.FlagSet) {
+	fmt.Fprintf(c.stderr, "usage: rumcask [-dir DIR] [-json] COMMAND [ARGS]\n\nFlags:\n")
+	flags.PrintDefaults()
+	fmt.Fprintf(c.stderr, "\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(c.stderr, "  %-8s %s\n", cmd.name, cmd.help)
+		if cmd.args != "" {
+			fmt.Fprintf(c.stderr, "           %s %s\n", cmd.name, cmd.args)
+		}
+	}
+}
+
+// Prints v as JSON, or formats a human readable line
+func (c *cli) print(v interface{}, format string, args ...interface{}) error {
+	if c.json {
+		return json.NewEncoder(c.stdout).Encode(v)
+	}
+	_, err := fmt.Fprintf(c.stdout, format+"\n", args...)
+	return err
+}
+
+// Returns a printable version of b, quoted if it contains
+// invalid UTF-8 or control characters
+func display(b []byte) string {
+	if !utf8.Valid(b) || bytes.IndexFunc(b, func(r rune) bool { return !unicode.IsPrint(r) }) != -1 {
+		return strconv.Quote(string(b))
+	}
+	return string(b)
+}
+
+// Returns true if dir contains a backup manifest
+func isBackup(dir string) bool {
+	_, err := rumcask.ReadManifest(dir)
+	return err == nil
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bsm/rumcask"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("rumcask", func() {
+	var dir string
+	var stdin string
+	var stdout, stderr *bytes.Buffer
+
+	var exec = func(args ...string) int {
+		stdout, stderr = new(bytes.Buffer), new(bytes.Buffer)
+		return run(append([]string{"-dir", dir}, args...), strings.NewReader(stdin), stdout, stderr)
+	}
+
+	BeforeEach(func() {
+		dir = filepath.Join(testDir, "db")
+		stdin = ""
+
+		db, err := rumcask.Open(dir, rumcask.NewHashKeyStore())
+		Expect(err).NotTo(HaveOccurred())
+		for _, kv := range [][2]string{{"b1", "v1"}, {"a1", "v2"}, {"b2", "v\x003"}} {
+			_, err := db.Set([]byte(kv[0]), []byte(kv[1]))
+			Expect(err).NotTo(HaveOccurred())
+		}
+		Expect(db.Close()).To(Succeed())
+	})
+
+	It("should print usage", func() {
+		Expect(exec()).To(Equal(2))
+		Expect(stderr.String()).To(ContainSubstring("Commands:"))
+		Expect(exec("unknown")).To(Equal(2))
+		Expect(exec("get")).To(Equal(2))
+		Expect(stderr.String()).To(ContainSubstring("usage: rumcask get KEY"))
+	})
+
+	It("should get values", func() {
+		Expect(exec("get", "b1")).To(Equal(0))
+		Expect(stdout.String()).To(Equal("v1\n"))
+
+		Expect(exec("-json", "get", "b1")).To(Equal(0))
+		Expect(stdout.String()).To(MatchJSON(`{"key":"b1","value":"v1"}`))
+
+		Expect(exec("get", "missing")).To(Equal(1))
+		Expect(stderr.String()).To(ContainSubstring("not found"))
+	})
+
+	It("should set and delete values", func() {
+		Expect(exec("set", "c1", "v4")).To(Equal(0))
+		Expect(stdout.String()).To(Equal("created c1\n"))
+
+		stdin = "v5"
+		Expect(exec("-json", "set", "c1")).To(Equal(0))
+		Expect(stdout.String()).To(MatchJSON(`{"key":"c1","replaced":true}`))
+		Expect(exec("get", "c1")).To(Equal(0))
+		Expect(stdout.String()).To(Equal("v5\n"))
+
+		Expect(exec("del", "c1", "missing")).To(Equal(0))
+		Expect(stdout.String()).To(Equal("deleted 1 of 2 keys\n"))
+		Expect(exec("get", "c1")).To(Equal(1))
+	})
+
+	It("should list keys and values", func() {
+		Expect(exec("keys")).To(Equal(0))
+		Expect(stdout.String()).To(Equal("a1\nb1\nb2\n"))
+
+		Expect(exec("keys", "-prefix", "b", "-limit", "1")).To(Equal(0))
+		Expect(stdout.String()).To(Equal("b1\n"))
+
+		Expect(exec("-json", "keys", "-min", "a2", "-max", "b2")).To(Equal(0))
+		Expect(stdout.String()).To(MatchJSON(`["b1"]`))
+
+		Expect(exec("scan", "-prefix", "b")).To(Equal(0))
+		Expect(stdout.String()).To(Equal("b1\tv1\nb2\t\"v\\x003\"\n"))
+
+		Expect(exec("-json", "scan", "-max", "b")).To(Equal(0))
+		Expect(stdout.String()).To(MatchJSON(`[{"key":"a1","value":"v2"}]`))
+	})
+
+	It("should print stats", func() {
+		Expect(exec("-json", "stats")).To(Equal(0))
+
+		var stats struct {
+			Keys, Pages int
+			Position    rumcask.Position
+			LiveBytes   int64 `json:"live_bytes"`
+			DeadBytes   int64 `json:"dead_bytes"`
+		}
+		Expect(json.Unmarshal(stdout.Bytes(), &stats)).To(Succeed())
+		Expect(stats.Keys).To(Equal(3))
+		Expect(stats.Pages).To(Equal(1))
+		Expect(stats.LiveBytes).To(Equal(int64(3*12 + 1)))
+		Expect(stats.DeadBytes).To(Equal(int64(0)))
+		Expect(stats.Position).To(Equal(rumcask.Position{ID: 0, Offset: 128 + 3*12 + 1}))
+	})
+
+	It("should verify DBs and backups", func() {
+		Expect(exec("verify")).To(Equal(0))
+		Expect(stdout.String()).To(Equal("checked 1 pages, 3 records, 3 keys: 0 corrupt records, 0 bad keys\n"))
+
+		fname := filepath.Join(dir, "00000000.rcp")
+		file, err := os.OpenFile(fname, os.O_WRONLY, 0)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = file.WriteAt([]byte{'X'}, 128+6)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(file.Close()).To(Succeed())
+
+		Expect(exec("verify", "-rate", "100000")).To(Equal(1))
+		Expect(stdout.String()).To(Equal("page 0: 1 corrupt records at offsets [128], 12 bytes skipped\n" +
+			"key b1: 0/128: rumcask: invalid checksum\n" +
+			"checked 1 pages, 2 records, 3 keys: 1 corrupt records, 1 bad keys\n"))
+		Expect(stderr.String()).To(ContainSubstring("verification failed"))
+
+		backup := filepath.Join(testDir, "backup")
+		Expect(exec("backup", backup)).To(Equal(0))
+		Expect(stdout.String()).To(HavePrefix("backup of 1 files"))
+
+		dir = backup
+		Expect(exec("verify")).To(Equal(0))
+		Expect(stdout.String()).To(HavePrefix("backup ok: 1 files"))
+	})
+
+	It("should compact", func() {
+		Expect(exec("set", "b1", "v6")).To(Equal(0))
+		Expect(exec("-json", "compact")).To(Equal(0))
+		Expect(stdout.String()).To(MatchJSON(`{"before":177,"after":177}`))
+	})
+
+	It("should export and import records", func() {
+		Expect(exec("export")).To(Equal(0))
+		Expect(stdout.String()).To(Equal(`{"key":"YTE=","value":"djI="}` + "\n" +
+			`{"key":"YjE=","value":"djE="}` + "\n" +
+			`{"key":"YjI=","value":"dgAz"}` + "\n"))
+
+		stdin = stdout.String()
+		dir = filepath.Join(testDir, "copy")
+		Expect(exec("import")).To(Equal(0))
+		Expect(stdout.String()).To(Equal("imported 3 records\n"))
+		Expect(exec("get", "b2")).To(Equal(0))
+		Expect(stdout.String()).To(Equal("v\x003\n"))
+
+		stdin = "{bad"
+		Expect(exec("import")).To(Equal(1))
+		Expect(stderr.String()).To(ContainSubstring("record 1"))
+	})
+
+	It("should dump pages", func() {
+		Expect(exec("dump", "0")).To(Equal(0))
+		Expect(stdout.String()).To(MatchRegexp(`OFFSET\s+KLEN\s+VLEN\s+DEL\s+CRC\s+KEY\s+VALUE\n`))
+		Expect(stdout.String()).To(MatchRegexp(`128\s+2\s+2\s+no\s+ok\s+b1\s+v1\n`))
+		Expect(stdout.String()).To(HaveSuffix("165 bytes, 3 valid records, 0 deleted, 0 corrupt, 0 bytes skipped\n"))
+
+		fname := filepath.Join(dir, "00000000.rcp")
+		file, err := os.OpenFile(fname, os.O_WRONLY, 0)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = file.WriteAt([]byte{'X'}, 128+6)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(file.Close()).To(Succeed())
+
+		Expect(exec("-json", "dump", "-preview", "1", fname)).To(Equal(0))
+		var dumps []struct {
+			Records []struct {
+				Offset  uint32
+				Valid   bool
+				Skipped uint32
+				Key     string
+			}
+			Corrupt int
+		}
+		Expect(json.Unmarshal(stdout.Bytes(), &dumps)).To(Succeed())
+		Expect(dumps).To(HaveLen(1))
+		Expect(dumps[0].Corrupt).To(Equal(1))
+		Expect(dumps[0].Records).To(HaveLen(3))
+		Expect(dumps[0].Records[0].Valid).To(BeFalse())
+		Expect(dumps[0].Records[0].Skipped).To(Equal(uint32(12)))
+		Expect(dumps[0].Records[0].Key).To(Equal("X"))
+	})
+
+	It("should repair pages", func() {
+		Expect(exec("repair")).To(Equal(0))
+		Expect(stdout.String()).To(Equal("no damaged pages found\n"))
+
+		fname := filepath.Join(dir, "00000000.rcp")
+		file, err := os.OpenFile(fname, os.O_WRONLY, 0)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = file.WriteAt([]byte{'X'}, 140+6)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(file.Close()).To(Succeed())
+
+		Expect(exec("repair")).To(Equal(0))
+		Expect(stdout.String()).To(HavePrefix("page 0: salvaged 2 records, dropped 1 records at offsets [140], 12 bytes\n" +
+			"moved 1 original pages to " + filepath.Join(dir, "lost+found")))
+
+		Expect(exec("keys")).To(Equal(0))
+		Expect(stdout.String()).To(Equal("b1\nb2\n"))
+	})
+
+	It("should respect locks", func() {
+		db, err := rumcask.OpenWithOptions(dir, rumcask.NewHashKeyStore(), &rumcask.Options{ReadOnly: true})
+		Expect(err).NotTo(HaveOccurred())
+		defer db.Close()
+
+		Expect(exec("get", "b1")).To(Equal(0))
+		Expect(exec("set", "b1", "v7")).To(Equal(1))
+		Expect(stderr.String()).To(ContainSubstring("locked"))
+	})
+
+	It("should not create DBs when reading", func() {
+		dir = filepath.Join(testDir, "missing")
+		Expect(exec("keys")).To(Equal(1))
+		_, err := os.Stat(dir)
+		Expect(os.IsNotExist(err)).To(BeTrue())
+	})
+
+})
+
+// --------------------------------------------------------------------
+
+var testDir string
+
+func TestSuite(t *testing.T) {
+	RegisterFailHandler(Fail)
+	BeforeEach(func() {
+		var err error
+		testDir, err = ioutil.TempDir("", "rumcask-cmd-tests")
+		Expect(err).NotTo(HaveOccurred())
+	})
+	AfterEach(func() {
+		os.RemoveAll(testDir)
+	})
+	RunSpecs(t, "rumcask/cmd/rumcask")
+}
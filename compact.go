@@ -0,0 +1,132 @@
+package rumcask
+
+import (
+	"os"
+	"sort"
+	"sync/atomic"
+)
+
+// Compact rewrites the live records of all sealed pages to the
+// end of the log and removes the sealed pages, reclaiming the
+// space of overwritten and deleted records. Tombstones of sealed
+// pages are dropped, as no older records remain.
+//
+// Writes are only blocked while a single record is moved. Running
+// backups and reads finish on the files of removed pages. Change
+// feeds and followers positioned in removed pages stop with
+// ERROR_BAD_POSITION.
+func (db *DB) Compact() error {
+	db.xLock.Lock()
+	defer db.xLock.Unlock()
+
+	db.cLock.Lock()
+	readOnly := db.readOnly
+	sealed := db.sealedPages()
+	db.cLock.Unlock()
+
+	if readOnly {
+		return ERROR_READ_ONLY
+	} else if len(sealed) == 0 {
+		return nil
+	}
+
+	for _, page := range sealed {
+		if err := db.compactPage(page); err != nil {
+			return err
+		}
+	}
+
+	// Persist the moved records before the originals are removed
+	if err := db.syncPagesAfter(sealed[len(sealed)-1].id); err != nil {
+		return err
+	}
+	if err := db.removePages(sealed); err != nil {
+		return err
+	}
+	return db.checkpoint()
+}
+
+// Returns all pages before the current one, ordered by ID.
+// Requires cLock.
+func (db *DB) sealedPages() []*Page {
+	db.pLock.RLock()
+	defer db.pLock.RUnlock()
+
+	pages := make([]*Page, 0, len(db.pages))
+	for id, page := range db.pages {
+		if id < db.current.id {
+			pages = append(pages, page)
+		}
+	}
+	sort.Slice(pages, func(i, j int) bool { return pages[i].id < pages[j].id })
+	return pages
+}
+
+// Moves all live records of a sealed page to the end of the log
+func (db *DB) compactPage(page *Page) error {
+	iter := newPageIterator(page)
+	for iter.First(); iter.Valid(); iter.Next() {
+		if len(iter.value) == 0 {
+			continue
+		}
+		if err := db.moveRecord(iter.key, iter.value, PageRef{page.id, iter.offset}); err != nil {
+			return err
+		}
+	}
+	return iter.Error()
+}
+
+// Rewrites a record, unless the key was modified since
+func (db *DB) moveRecord(key, value []byte, ref PageRef) error {
+	db.cLock.Lock()
+	defer db.cLock.Unlock()
+
+	if cur, ok := db.keys.Fetch(key); !ok || cur != ref {
+		return nil
+	}
+
+	offset, err := db.write(key, value)
+	if err != nil {
+		return err
+	}
+	db.applyRecord(key, value, PageRef{db.current.id, offset})
+	return nil
+}
+
+// Syncs all pages with an ID greater than id
+func (db *DB) syncPagesAfter(id uint32) error {
+	db.pLock.RLock()
+	defer db.pLock.RUnlock()
+
+	for pid, page := range db.pages {
+		if pid <= id {
+			continue
+		}
+		if err := page.file.Sync(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Unregisters and unlinks pages, oldest first, so a partial
+// removal never resurrects deleted keys
+func (db *DB) removePages(pages []*Page) error {
+	db.cLock.Lock()
+	defer db.cLock.Unlock()
+
+	for _, page := range pages {
+		db.pLock.Lock()
+		delete(db.pages, page.id)
+		atomic.AddUint64(&db.removals, 1)
+		db.pLock.Unlock()
+
+		if err := os.Remove(bloomName(page)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := page.unlink(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package rumcask
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Compact", func() {
+	var subject *DB
+	var keys *HashKeyStore
+
+	var set = func(key, value string) {
+		_, err := subject.Set([]byte(key), []byte(value))
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	BeforeEach(func() {
+		var err error
+		keys = NewHashKeyStore()
+		subject, err = OpenWithOptions(testDir, keys, &Options{BloomFalsePositiveRate: 0.01})
+		Expect(err).NotTo(HaveOccurred())
+
+		set("key1", "val1")
+		set("key2", "val2")
+		set("key3", "val3")
+		Expect(subject.nextPage()).To(Succeed())
+		set("key2", "valX")
+		_, err = subject.Delete([]byte("key3"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(subject.nextPage()).To(Succeed())
+		set("key4", "val4")
+	})
+
+	AfterEach(func() {
+		subject.Close()
+	})
+
+	It("should move live records and remove sealed pages", func() {
+		Expect(subject.Compact()).To(Succeed())
+		Expect(subject.pages).To(HaveLen(1))
+		Expect(subject.pages).To(HaveKey(uint32(2)))
+		Expect(keys.refs).To(Equal(map[string]PageRef{
+			"key4": {ID: 2, Offset: 128},
+			"key1": {ID: 2, Offset: 144},
+			"key2": {ID: 2, Offset: 160},
+		}))
+
+		Expect(subject.Get([]byte("key1"))).To(Equal([]byte("val1")))
+		Expect(subject.Get([]byte("key2"))).To(Equal([]byte("valX")))
+		_, err := subject.Get([]byte("key3"))
+		Expect(err).To(Equal(ERROR_NOT_FOUND))
+
+		items, _ := filepath.Glob(filepath.Join(testDir, "*.rc?"))
+		Expect(items).To(ConsistOf(filepath.Join(testDir, "00000002.rcp")))
+	})
+
+	It("should reopen compacted DBs", func() {
+		Expect(subject.Compact()).To(Succeed())
+		Expect(subject.Close()).To(Succeed())
+
+		var err error
+		keys = NewHashKeyStore()
+		subject, err = OpenWithOptions(testDir, keys, &Options{NoCheckpoints: true})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(keys.refs).To(HaveLen(3))
+		Expect(subject.Get([]byte("key2"))).To(Equal([]byte("valX")))
+		_, err = subject.Get([]byte("key3"))
+		Expect(err).To(Equal(ERROR_NOT_FOUND))
+	})
+
+	It("should do nothing without sealed pages", func() {
+		Expect(subject.Compact()).To(Succeed())
+		Expect(subject.Compact()).To(Succeed())
+		Expect(subject.pages).To(HaveLen(1))
+		Expect(keys.refs).To(HaveLen(3))
+	})
+
+	It("should reject read-only DBs", func() {
+		subject.readOnly = true
+		Expect(subject.Compact()).To(Equal(ERROR_READ_ONLY))
+	})
+
+	It("should stop feeds in removed pages", func() {
+		feed, err := subject.Changes(Position{})
+		Expect(err).NotTo(HaveOccurred())
+		defer feed.Close()
+
+		Expect(subject.Compact()).To(Succeed())
+		Expect(feed.Next()).To(BeFalse())
+		Expect(feed.Err()).To(Equal(ERROR_BAD_POSITION))
+	})
+
+	It("should not wait for readers of removed pages", func() {
+		_, pages := subject.snapshotPages()
+		Expect(pages).To(HaveLen(3))
+
+		Expect(subject.Compact()).To(Succeed())
+		Expect(subject.Get([]byte("key1"))).To(Equal([]byte("val1")))
+
+		// Snapshots remain readable until released
+		key, val, _, err := pages[0].page.read(128)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(key)).To(Equal("key1"))
+		Expect(string(val)).To(Equal("val1"))
+		releasePages(pages)
+
+		_, _, _, err = pages[0].page.read(128)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should serialize concurrent compactions", func() {
+		var wg sync.WaitGroup
+		for n := 0; n < 4; n++ {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+				Expect(subject.Compact()).To(Succeed())
+			}()
+		}
+		wg.Wait()
+
+		Expect(subject.pages).To(HaveLen(1))
+		Expect(keys.refs).To(HaveLen(3))
+		Expect(subject.Get([]byte("key2"))).To(Equal([]byte("valX")))
+	})
+
+	It("should compact while reading and writing", func() {
+		var wg sync.WaitGroup
+		stop := make(chan struct{})
+		for n := 0; n < 2; n++ {
+			wg.Add(1)
+			go func(n int) {
+				defer GinkgoRecover()
+				defer wg.Done()
+				for i := 0; ; i++ {
+					select {
+					case <-stop:
+						return
+					default:
+					}
+					key := []byte(fmt.Sprintf("bg%d.%d", n, i%50))
+					_, err := subject.Set(key, []byte("value"))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(subject.Get([]byte("key1"))).To(Equal([]byte("val1")))
+				}
+			}(n)
+		}
+
+		for i := 0; i < 5; i++ {
+			subject.cLock.Lock()
+			Expect(subject.nextPage()).To(Succeed())
+			subject.cLock.Unlock()
+			Expect(subject.Compact()).To(Succeed())
+		}
+		close(stop)
+		wg.Wait()
+
+		Expect(subject.Get([]byte("key2"))).To(Equal([]byte("valX")))
+		Expect(subject.pages).To(HaveLen(1))
+	})
+
+})
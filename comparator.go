@@ -0,0 +1,112 @@
+package rumcask
+
+import (
+	"bytes"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Comparator defines a total ordering of keys
+type Comparator interface {
+	// Name identifies the ordering. It is stored with the DB,
+	// so that it cannot be reopened with a different ordering.
+	Name() string
+
+	// Compare returns a negative value, zero or a positive
+	// value if a is less than, equal to, or greater than b
+	Compare(a, b []byte) int
+}
+
+// SortedKeyStore is implemented by KeyStores which maintain
+// their keys in the order of a Comparator. If the store is
+// also an UnorderedIterator, ForEach must visit keys in order.
+type SortedKeyStore interface {
+	KeyStore
+
+	// Comparator returns the ordering of the keys
+	Comparator() Comparator
+}
+
+// BulkLoader is implemented by SortedKeyStores which can be
+// built efficiently from keys in comparator order. Open uses
+// it to load checkpoints which were written in that order.
+type BulkLoader interface {
+	SortedKeyStore
+
+	// BulkLoad replaces all stored keys with the ones passed
+	// to the iterator by feed. Returns the error of feed.
+	BulkLoad(feed func(Iterator) error) error
+}
+
+var (
+	// BytewiseComparator orders keys lexically
+	BytewiseComparator Comparator = bytewiseComparator{}
+
+	// Int64Comparator orders 8-byte, big-endian encoded signed integers
+	// numerically. Keys of other lengths are ordered consistently, but
+	// their ordering is not meaningful.
+	Int64Comparator Comparator = int64Comparator{}
+
+	// CaseFoldComparator orders UTF-8 keys lexically, ignoring case.
+	// Keys which only differ in case are considered equal. Invalid
+	// UTF-8 bytes are ordered with U+FFFD, bytewise among themselves.
+	CaseFoldComparator Comparator = caseFoldComparator{}
+)
+
+// ReverseComparator returns a comparator which reverses the ordering of c
+func ReverseComparator(c Comparator) Comparator {
+	return reverseComparator{c}
+}
+
+type bytewiseComparator struct{}
+
+func (bytewiseComparator) Name() string            { return "bytewise" }
+func (bytewiseComparator) Compare(a, b []byte) int { return bytes.Compare(a, b) }
+
+type int64Comparator struct{}
+
+func (int64Comparator) Name() string { return "int64" }
+
+// Flips the sign bit, so negative values sort first
+func (int64Comparator) Compare(a, b []byte) int {
+	if len(a) == 0 || len(b) == 0 {
+		return len(a) - len(b)
+	}
+	if a0, b0 := a[0]^0x80, b[0]^0x80; a0 != b0 {
+		if a0 < b0 {
+			return -1
+		}
+		return 1
+	}
+	return bytes.Compare(a[1:], b[1:])
+}
+
+type caseFoldComparator struct{}
+
+func (caseFoldComparator) Name() string { return "casefold" }
+
+func (caseFoldComparator) Compare(a, b []byte) int {
+	for len(a) != 0 && len(b) != 0 {
+		ra, na := utf8.DecodeRune(a)
+		rb, nb := utf8.DecodeRune(b)
+		if ra, rb = unicode.ToLower(ra), unicode.ToLower(rb); ra != rb {
+			if ra < rb {
+				return -1
+			}
+			return 1
+		}
+		// Invalid bytes all decode to RuneError, keep them distinct
+		if ra == utf8.RuneError && (na == 1 || nb == 1) {
+			if c := bytes.Compare(a[:na], b[:nb]); c != 0 {
+				return c
+			}
+		}
+		a, b = a[na:], b[nb:]
+	}
+	return len(a) - len(b)
+}
+
+type reverseComparator struct{ Comparator }
+
+func (c reverseComparator) Name() string            { return "reverse(" + c.Comparator.Name() + ")" }
+func (c reverseComparator) Compare(a, b []byte) int { return -c.Comparator.Compare(a, b) }
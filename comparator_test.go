@@ -0,0 +1,57 @@
+package rumcask
+
+import (
+	"sort"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Comparator", func() {
+	var sorted = func(cmp Comparator, keys ...string) []string {
+		sort.Slice(keys, func(i, j int) bool {
+			return cmp.Compare([]byte(keys[i]), []byte(keys[j])) < 0
+		})
+		return keys
+	}
+
+	It("should have names", func() {
+		Expect(BytewiseComparator.Name()).To(Equal("bytewise"))
+		Expect(Int64Comparator.Name()).To(Equal("int64"))
+		Expect(CaseFoldComparator.Name()).To(Equal("casefold"))
+		Expect(ReverseComparator(Int64Comparator).Name()).To(Equal("reverse(int64)"))
+	})
+
+	It("should order bytewise", func() {
+		Expect(sorted(BytewiseComparator, "b", "B", "a", "ab")).To(Equal([]string{"B", "a", "ab", "b"}))
+		Expect(sorted(ReverseComparator(BytewiseComparator), "b", "B", "a", "ab")).To(Equal([]string{"b", "ab", "a", "B"}))
+	})
+
+	It("should order signed integers", func() {
+		enc := func(n int64) string {
+			b := make([]byte, 8)
+			for i := range b {
+				b[i] = byte(uint64(n) >> uint(56-8*i))
+			}
+			return string(b)
+		}
+		Expect(sorted(Int64Comparator, enc(5), enc(-1), enc(0), enc(-300), enc(1<<40))).To(Equal([]string{
+			enc(-300), enc(-1), enc(0), enc(5), enc(1 << 40),
+		}))
+		Expect(Int64Comparator.Compare([]byte{}, []byte{})).To(Equal(0))
+	})
+
+	It("should order case-insensitively", func() {
+		Expect(sorted(CaseFoldComparator, "b", "Ä", "A", "äb", "C")).To(Equal([]string{"A", "b", "C", "Ä", "äb"}))
+		Expect(CaseFoldComparator.Compare([]byte("Straße"), []byte("STRASSE"))).NotTo(Equal(0))
+		Expect(CaseFoldComparator.Compare([]byte("MixedCase"), []byte("mixedcase"))).To(Equal(0))
+
+		// invalid UTF-8
+		Expect(CaseFoldComparator.Compare([]byte("a\xfe"), []byte("A\xff"))).To(Equal(-1))
+		Expect(CaseFoldComparator.Compare([]byte("a\xff"), []byte("A\xfe"))).To(Equal(1))
+		Expect(CaseFoldComparator.Compare([]byte("a\xff"), []byte("A\xff"))).To(Equal(0))
+		Expect(CaseFoldComparator.Compare([]byte("\xff"), []byte("\uFFFD"))).NotTo(Equal(0))
+		Expect(sorted(CaseFoldComparator, "\xff", "b", "\xfe", "\uFFFD")).To(Equal([]string{"b", "\uFFFD", "\xfe", "\xff"}))
+	})
+
+})
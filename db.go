@@ -6,64 +6,175 @@ import (
 	"path/filepath"
 	"runtime"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type DB struct {
-	dir     string
-	flock   *fileLock
-	pages   map[uint32]*Page
-	current *Page
-	keys    KeyStore
+	dir      string
+	opts     *Options
+	flock    *fileLock
+	pages    map[uint32]*Page
+	current  *Page
+	keys     KeyStore
+	inline   InlineKeyStore // nil, unless enabled
+	match    Comparator     // nil, unless lookup keys may differ from stored ones
+	blooms   bool           // true, if bloom filters are checked before lookups
+	indexes  map[string]*index
+	changed  chan struct{} // closed on write, see notifier
+	replicas map[*replica]struct{}
+	marks    map[*markSet]struct{} // collected by backups, guarded by cLock
+	readOnly bool
+	closed   bool
+
+	bloomLookups, bloomAvoided uint64
+
+	// Operation counters, see Stats
+	gets, sets, deletes, misses, badChecksums, rotations uint64
+
+	removals uint64 // number of removed pages, incremented under pLock
+
+	// Live record tracking, guarded by cLock, see Stats
+	live      int64
+	liveKnown bool
+	liveGen   uint64       // incremented when tracking is reset
+	dead      []pageRecord // superseded records, sizes not yet subtracted
+
+	closer, eoloop chan struct{}
+	rotated        chan struct{} // nil, unless the key store is persistent
 
 	cLock sync.Mutex
 	pLock sync.RWMutex
+	iLock sync.RWMutex // guards indexes, writers also hold cLock
+	nLock sync.Mutex   // guards changed
+	rLock sync.Mutex   // guards replicas
+	xLock sync.Mutex   // serializes Compact
+	sLock sync.Mutex   // serializes live byte calculations
 }
 
 // Open opens a new database in the given directory.
 // A new directory will be created if the given path does not exist.
 func Open(dir string, keys KeyStore) (*DB, error) {
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return nil, err
+	return OpenWithOptions(dir, keys, nil)
+}
+
+// OpenWithOptions opens a new database in the given directory, using
+// custom options.
+func OpenWithOptions(dir string, keys KeyStore, opts *Options) (*DB, error) {
+	opts = opts.norm()
+	if !opts.ReadOnly {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
 	}
 
-	flock, err := newFileLock(filepath.Join(dir, "LOCK"))
+	flock, err := newFileLock(filepath.Join(dir, "LOCK"), opts.ReadOnly)
 	if err != nil {
 		return nil, err
 	}
 
 	db := &DB{
-		dir:   dir,
-		flock: flock,
-		pages: make(map[uint32]*Page),
-		keys:  keys,
+		dir:      dir,
+		opts:     opts,
+		flock:    flock,
+		pages:    make(map[uint32]*Page),
+		keys:     keys,
+		readOnly: opts.ReadOnly,
+		closer:   make(chan struct{}),
+		eoloop:   make(chan struct{}),
+	}
+	if store, ok := keys.(DigestKeyStore); ok {
+		// Digest stores may return refs for other keys
+		store.SetKeyReader(db.readKeyAt)
+		db.match = BytewiseComparator
+	}
+	if store, ok := keys.(SortedKeyStore); ok && store.Comparator() != BytewiseComparator {
+		// Equal keys may differ bytewise, which bloom filters cannot match
+		db.match = store.Comparator()
+		db.opts.BloomFalsePositiveRate = 0
+	}
+	if _, ok := keys.(PersistentKeyStore); ok {
+		// In-memory lookups are cheaper than checking each page's filter
+		db.blooms = db.opts.BloomFalsePositiveRate != 0
+		if !opts.ReadOnly {
+			db.rotated = make(chan struct{}, 1)
+		}
+	}
+	if store, ok := keys.(InlineKeyStore); ok && db.opts.InlineValueSize > 0 {
+		db.inline = store
+	}
+	if err := db.openMeta(); err != nil {
+		db.flock.release()
+		return nil, err
 	}
 	if err := db.openPages(); err != nil {
-		db.Close()
+		close(db.eoloop)
+		db.closed = true
+		db.closePages()
+		db.flock.release()
 		return nil, err
 	}
 
+	go db.loop()
 	runtime.SetFinalizer(db, (*DB).Close)
 	return db, nil
 }
 
 // Get retrieves a value from the DB
 func (db *DB) Get(key []byte) ([]byte, error) {
-	ref, ok := db.keys.Fetch(key)
-	if !ok {
+	atomic.AddUint64(&db.gets, 1)
+	val, err := db.get(key)
+	if err == ERROR_NOT_FOUND {
+		atomic.AddUint64(&db.misses, 1)
+	}
+	return val, err
+}
+
+func (db *DB) get(key []byte) ([]byte, error) {
+	if db.blooms && !db.mayContain(key) {
 		return nil, ERROR_NOT_FOUND
 	}
 
-	page := db.page(ref.ID)
-	if page == nil {
+	for {
+		removals := atomic.LoadUint64(&db.removals)
+		val, err := db.lookup(key)
+
+		// Retry if Compact removed a page in the meantime,
+		// the record may have been moved
+		if err != ERROR_NOT_FOUND || atomic.LoadUint64(&db.removals) == removals {
+			return val, err
+		}
+	}
+}
+
+func (db *DB) lookup(key []byte) ([]byte, error) {
+	var ref PageRef
+	var ok bool
+	if db.inline != nil {
+		var val []byte
+		if ref, val, ok = db.inline.FetchInline(key); val != nil {
+			return append([]byte(nil), val...), nil
+		}
+	} else {
+		ref, ok = db.keys.Fetch(key)
+	}
+	if !ok {
 		return nil, ERROR_NOT_FOUND
 	}
 
-	return page.readKey(key, ref.Offset)
+	val, err := db.readValue(key, ref)
+	if err == nil && db.inline != nil && len(val) <= db.opts.InlineValueSize {
+		// Skipped if the key was modified in the meantime
+		db.inline.CacheInline(key, ref, val)
+	}
+	return val, err
 }
 
 // Set sets a key, value pair. Returns true if key was replaced,
 // or false if the key is new
 func (db *DB) Set(key, value []byte) (bool, error) {
+	atomic.AddUint64(&db.sets, 1)
+
 	klen, vlen := len(key), len(value)
 	if klen < 1 {
 		return false, ERROR_KEY_BLANK
@@ -78,41 +189,310 @@ func (db *DB) Set(key, value []byte) (bool, error) {
 	db.cLock.Lock()
 	defer db.cLock.Unlock()
 
+	if db.readOnly {
+		return false, ERROR_READ_ONLY
+	}
+
 	offset, err := db.write(key, value)
 	if err != nil {
 		return false, err
 	}
-
-	pref, ok := db.keys.Store(key, PageRef{db.current.id, offset})
-	if ok {
-		db.page(pref.ID).deleted()
-	}
-	return ok, nil
+	return db.applyRecord(key, value, PageRef{db.current.id, offset}), nil
 }
 
 // Delete deletes a key. Returns true if key was found,
 // or false if the key was not stored in the first place
 func (db *DB) Delete(key []byte) (bool, error) {
+	atomic.AddUint64(&db.deletes, 1)
+
 	db.cLock.Lock()
 	defer db.cLock.Unlock()
 
-	// Try to remove key, return if not stored
+	if db.readOnly {
+		return false, ERROR_READ_ONLY
+	}
+
+	// Return if not stored
+	if _, ok := db.keys.Fetch(key); !ok {
+		return false, nil
+	}
+
+	// Append a tombstone first, a failed write leaves the key
+	if _, err := db.write(key, nil); err != nil {
+		return false, err
+	}
+
+	// Digest stores may fail to verify the key
 	pref, ok := db.keys.Delete(key)
 	if !ok {
 		return ok, nil
 	}
+	db.updateIndexes(key, nil)
+	db.untrackLive(pref)
+	return ok, db.markDeleted(pref)
+}
 
-	page, ok := db.pages[pref.ID]
+// Len returns the number of keys. Returns ERROR_NOT_SUPPORTED
+// unless the key store is a Counter.
+func (db *DB) Len() (int, error) {
+	store, ok := db.keys.(Counter)
 	if !ok {
-		return ok, nil
+		return 0, ERROR_NOT_SUPPORTED
+	}
+	return store.Len(), nil
+}
+
+// Keys returns a copy of all keys, in order if the key store
+// is an OrderedIterator. Returns ERROR_NOT_SUPPORTED unless the
+// key store is iterable.
+func (db *DB) Keys() ([][]byte, error) {
+	if store, ok := db.keys.(OrderedIterator); ok {
+		return collectKeys(func(each Iterator) { store.Iterate(nil, nil, each) }), nil
+	}
+	if store, ok := db.keys.(UnorderedIterator); ok {
+		return collectKeys(store.ForEach), nil
+	}
+	return nil, ERROR_NOT_SUPPORTED
+}
+
+// KeyRange returns a copy of all keys >= min and < max, in order.
+// A nil min or max leaves the range unbounded. Returns
+// ERROR_NOT_SUPPORTED unless the key store is an OrderedIterator.
+func (db *DB) KeyRange(min, max []byte) ([][]byte, error) {
+	store, ok := db.keys.(OrderedIterator)
+	if !ok {
+		return nil, ERROR_NOT_SUPPORTED
+	}
+	return collectKeys(func(each Iterator) { store.Iterate(min, max, each) }), nil
+}
+
+// Clear deletes all keys, appending a tombstone for each. Returns
+// ERROR_NOT_SUPPORTED unless the key store is an UnorderedIterator
+// and a Resetter.
+func (db *DB) Clear() error {
+	iter, ok := db.keys.(UnorderedIterator)
+	if !ok {
+		return ERROR_NOT_SUPPORTED
+	}
+	store, ok := db.keys.(Resetter)
+	if !ok {
+		return ERROR_NOT_SUPPORTED
+	}
+
+	db.cLock.Lock()
+	defer db.cLock.Unlock()
+
+	if db.readOnly {
+		return ERROR_READ_ONLY
+	}
+
+	keys, refs := collectRefs(iter)
+	for i, key := range keys {
+		if _, err := db.write(key, nil); err != nil {
+			// Keep the store in sync with the tombstones written so far
+			for _, key := range keys[:i] {
+				db.keys.Delete(key)
+			}
+			return err
+		}
+		// Markers are advisory, the tombstone is authoritative
+		db.markDeleted(refs[i])
+	}
+
+	db.iLock.Lock()
+	for _, x := range db.indexes {
+		x.reset()
+	}
+	db.iLock.Unlock()
+
+	db.resetLive(true)
+	return store.Reset()
+}
+
+// AddIndex registers a secondary index and builds it from
+// the stored records. Indexes are held in memory and must
+// be added each time the DB is opened. Returns
+// ERROR_NOT_SUPPORTED unless the key store is an
+// UnorderedIterator.
+func (db *DB) AddIndex(name string, fn IndexFunc) error {
+	db.cLock.Lock()
+	defer db.cLock.Unlock()
+
+	if _, ok := db.indexes[name]; ok {
+		return ERROR_INDEX_EXISTS
+	}
+	return db.buildIndex(name, fn)
+}
+
+// RebuildIndex rebuilds a secondary index from the stored records
+func (db *DB) RebuildIndex(name string) error {
+	db.cLock.Lock()
+	defer db.cLock.Unlock()
+
+	x, ok := db.indexes[name]
+	if !ok {
+		return ERROR_INDEX_NOT_FOUND
 	}
-	return ok, page.delete(pref.Offset)
+	return db.buildIndex(name, x.fn)
+}
+
+// DropIndex removes a secondary index
+func (db *DB) DropIndex(name string) error {
+	db.cLock.Lock()
+	defer db.cLock.Unlock()
+
+	if _, ok := db.indexes[name]; !ok {
+		return ERROR_INDEX_NOT_FOUND
+	}
+
+	db.iLock.Lock()
+	delete(db.indexes, name)
+	db.iLock.Unlock()
+	return nil
+}
+
+// Lookup returns the primary keys, which the named index maps
+// to value, in lexical order
+func (db *DB) Lookup(name string, value []byte) ([][]byte, error) {
+	db.iLock.RLock()
+	defer db.iLock.RUnlock()
+
+	x, ok := db.indexes[name]
+	if !ok {
+		return nil, ERROR_INDEX_NOT_FOUND
+	}
+	return x.lookup(value), nil
 }
 
 // Close closes the database again
 func (db *DB) Close() (err error) {
+	db.cLock.Lock()
+	closed := db.closed
+	db.closed = true
+	db.cLock.Unlock()
+
+	if closed {
+		return nil
+	}
 	defer db.flock.release()
 
+	close(db.closer)
+	<-db.eoloop // wait for loop to exit
+
+	if db.opts.ReadOnly {
+		return db.closePages()
+	}
+	err = db.checkpoint()
+
+	if db.current.bloom != nil {
+		if e := db.current.bloom.write(bloomName(db.current)); e != nil {
+			err = e
+		}
+	}
+	if e := db.closePages(); e != nil {
+		err = e
+	}
+	return
+}
+
+// BloomStats returns bloom filter metrics
+func (db *DB) BloomStats() BloomStats {
+	return BloomStats{
+		Lookups: atomic.LoadUint64(&db.bloomLookups),
+		Avoided: atomic.LoadUint64(&db.bloomAvoided),
+	}
+}
+
+// Reads the value stored at ref
+func (db *DB) readValue(key []byte, ref PageRef) ([]byte, error) {
+	page := db.acquirePage(ref.ID)
+	if page == nil {
+		return nil, ERROR_NOT_FOUND
+	}
+	defer page.release()
+
+	var val []byte
+	var err error
+	if db.match != nil {
+		val, err = page.readMatching(key, ref.Offset, db.match)
+	} else {
+		val, err = page.readKey(key, ref.Offset)
+	}
+	if err == ERROR_BAD_CHECKSUM {
+		atomic.AddUint64(&db.badChecksums, 1)
+	}
+	return val, err
+}
+
+// Builds an index from all stored records and registers it,
+// requires cLock
+func (db *DB) buildIndex(name string, fn IndexFunc) error {
+	iter, ok := db.keys.(UnorderedIterator)
+	if !ok {
+		return ERROR_NOT_SUPPORTED
+	}
+
+	keys, refs := collectRefs(iter)
+
+	x := newIndex(fn)
+	for i, key := range keys {
+		val, err := db.readValue(key, refs[i])
+		if err != nil {
+			return err
+		}
+		x.set(key, val)
+	}
+
+	db.iLock.Lock()
+	defer db.iLock.Unlock()
+
+	if db.indexes == nil {
+		db.indexes = make(map[string]*index)
+	}
+	db.indexes[name] = x
+	return nil
+}
+
+// Updates all indexes, a nil value removes the key. Requires cLock.
+func (db *DB) updateIndexes(key, value []byte) {
+	if len(db.indexes) == 0 {
+		return
+	}
+
+	db.iLock.Lock()
+	defer db.iLock.Unlock()
+
+	for _, x := range db.indexes {
+		if value == nil {
+			x.remove(string(key))
+		} else {
+			x.set(key, value)
+		}
+	}
+}
+
+// Copies all keys and their refs
+func collectRefs(iter UnorderedIterator) (keys [][]byte, refs []PageRef) {
+	iter.ForEach(func(key []byte, ref PageRef) bool {
+		keys = append(keys, append([]byte(nil), key...))
+		refs = append(refs, ref)
+		return true
+	})
+	return
+}
+
+// Copies the keys visited by iter
+func collectKeys(iter func(Iterator)) [][]byte {
+	var keys [][]byte
+	iter(func(key []byte, _ PageRef) bool {
+		keys = append(keys, append([]byte(nil), key...))
+		return true
+	})
+	return keys
+}
+
+// Closes all pages
+func (db *DB) closePages() (err error) {
 	for _, page := range db.pages {
 		if e := page.close(); e != nil {
 			err = e
@@ -128,7 +508,87 @@ func (db *DB) write(key, value []byte) (uint32, error) {
 			return 0, err
 		}
 	}
-	return db.current.write(key, value)
+
+	offset, err := db.current.write(key, value)
+	if err != nil {
+		return offset, err
+	}
+	if len(value) != 0 && db.current.bloom != nil {
+		db.current.bloom.add(key, db.current.pos())
+	}
+	db.notify()
+	return offset, nil
+}
+
+// Returns false if bloom filters indicate that key is absent
+func (db *DB) mayContain(key []byte) bool {
+	atomic.AddUint64(&db.bloomLookups, 1)
+
+	h1, h2 := bloomHash(key)
+	db.pLock.RLock()
+	defer db.pLock.RUnlock()
+
+	for _, page := range db.pages {
+		if page.bloom == nil || page.bloom.has(h1, h2) {
+			return true
+		}
+	}
+	atomic.AddUint64(&db.bloomAvoided, 1)
+	return false
+}
+
+// Applies a record, written to the log at ref, to the key
+// store and indexes. Returns true if the key was stored before.
+// Requires cLock.
+func (db *DB) applyRecord(key, value []byte, ref PageRef) bool {
+	if len(value) == 0 {
+		pref, ok := db.keys.Delete(key)
+		if ok {
+			db.updateIndexes(key, nil)
+			db.untrackLive(pref)
+			// Markers are advisory, the tombstone is authoritative
+			db.markDeleted(pref)
+		}
+		return ok
+	}
+
+	var pref PageRef
+	var ok bool
+	if db.inline != nil && len(value) <= db.opts.InlineValueSize {
+		pref, ok = db.inline.StoreInline(key, ref, value)
+	} else {
+		pref, ok = db.keys.Store(key, ref)
+	}
+	if ok {
+		db.page(pref.ID).deleted()
+		db.untrackLive(pref)
+	}
+	db.trackLive(key, value)
+	db.updateIndexes(key, value)
+	return ok
+}
+
+// Loads bloom filters for all pages
+func (db *DB) loadBlooms(pages []*Page) error {
+	for _, page := range pages {
+		bloom, err := loadPageBloom(page, db.opts.BloomFalsePositiveRate)
+		if err != nil {
+			return err
+		}
+		page.bloom = bloom
+	}
+	return nil
+}
+
+// Reads the key stored at ref
+func (db *DB) readKeyAt(ref PageRef) ([]byte, error) {
+	page := db.acquirePage(ref.ID)
+	if page == nil {
+		return nil, ERROR_NOT_FOUND
+	}
+	defer page.release()
+
+	return page.key(ref.Offset)
 }
 
 // Gets the page by ID
@@ -139,6 +599,49 @@ func (db *DB) page(id uint32) *Page {
 	return db.pages[id]
 }
 
+// Gets the page by ID, keeps it readable until released,
+// even if it is removed by Compact in the meantime
+func (db *DB) acquirePage(id uint32) *Page {
+	db.pLock.RLock()
+	defer db.pLock.RUnlock()
+
+	page := db.pages[id]
+	if page != nil {
+		page.acquire()
+	}
+	return page
+}
+
+// Validates the DB metadata against the key store, records
+// the comparator on first use
+func (db *DB) openMeta() error {
+	store, ok := db.keys.(SortedKeyStore)
+	if !ok {
+		return nil
+	}
+
+	fname := filepath.Join(db.dir, "META")
+	meta, err := readMeta(fname)
+	if os.IsNotExist(err) {
+		meta, err = make(dbMeta), nil
+	}
+	if err != nil {
+		return err
+	}
+
+	name := store.Comparator().Name()
+	if prev, ok := meta[metaComparator]; ok {
+		if prev != name {
+			return ERROR_COMPARATOR_MISMATCH
+		}
+		return nil
+	} else if db.opts.ReadOnly {
+		return nil
+	}
+	meta[metaComparator] = name
+	return meta.write(fname)
+}
+
 // Opens all existing pages
 func (db *DB) openPages() error {
 	names, err := filepath.Glob(filepath.Join(db.dir, "*.rcp"))
@@ -146,27 +649,191 @@ func (db *DB) openPages() error {
 		return err
 	}
 
+	open := openPage
+	if db.opts.ReadOnly {
+		open = openReadOnlyPage
+	}
+
+	pages := make([]*Page, 0, len(names))
 	for _, name := range names {
-		page, err := openPage(name)
+		page, err := open(name)
 		if err != nil {
 			return err
 		}
-		if err := page.parse(db.keys); err != nil {
-			return err
-		}
 		db.makeCurrent(page)
+		pages = append(pages, page)
 	}
 
 	if db.current == nil {
-		page, err := openPage(db.pageName(0))
+		page, err := open(db.pageName(0))
 		if err != nil {
 			return err
 		}
 		db.makeCurrent(page)
 	}
+	if err := db.loadKeys(pages); err != nil {
+		return err
+	}
+	if db.opts.BloomFalsePositiveRate == 0 {
+		return nil
+	}
+	if len(pages) == 0 {
+		pages = append(pages, db.current)
+	}
+	return db.loadBlooms(pages)
+}
+
+// Populates the key store from the given pages
+func (db *DB) loadKeys(pages []*Page) error {
+	// Repaired pages invalidate persisted refs
+	marker := filepath.Join(db.dir, rebuildKeysName)
+	_, err := os.Stat(marker)
+	rebuild := err == nil
+
+	pos, ok := PageRef{}, false
+	if store, isPersistent := db.keys.(PersistentKeyStore); isPersistent {
+		if pos, ok = store.Checkpoint(); ok && (rebuild || !db.canReplay(pos)) {
+			ok = false
+		}
+		if !ok {
+			if err := store.Reset(); err != nil {
+				return err
+			}
+		}
+	} else if !db.opts.NoCheckpoints {
+		var err error
+		if pos, ok, err = db.loadCheckpoint(pages); err != nil {
+			return err
+		}
+	}
+
+	for _, page := range pages {
+		if ok && page.id < pos.ID {
+			continue
+		}
+
+		from := uint32(0)
+		if ok && page.id == pos.ID {
+			from = pos.Offset
+		}
+		if err := page.parse(db.keys, from); err != nil {
+			return err
+		}
+	}
+
+	if rebuild && !db.readOnly {
+		return os.Remove(marker)
+	}
 	return nil
 }
 
+// Loads the checkpoint file into the key store, if present and valid.
+// Returns the position from which pages must be replayed. Returns an
+// error if a partially loaded store cannot be reset.
+func (db *DB) loadCheckpoint(pages []*Page) (PageRef, bool, error) {
+	cp, r, err := readCheckpoint(db.checkpointName())
+	if err != nil {
+		return PageRef{}, false, nil
+	}
+	defer r.Close()
+
+	if !cp.matches(pages) || !db.canReplay(cp.Position) {
+		return PageRef{}, false, nil
+	}
+
+	// Checksums are validated before, errors are unlikely
+	// but leave the store partially populated
+	if err := db.loadEntries(cp, r); err != nil {
+		store, ok := db.keys.(Resetter)
+		if !ok {
+			return PageRef{}, false, err
+		}
+		if err := store.Reset(); err != nil {
+			return PageRef{}, false, err
+		}
+		return PageRef{}, false, nil
+	}
+	return cp.Position, true, nil
+}
+
+// Populates the key store from checkpoint entries, uses bulk
+// loading if entries are sorted in the order of the store
+func (db *DB) loadEntries(cp *checkpoint, r *checkpointReader) error {
+	if store, ok := db.keys.(BulkLoader); ok && cp.Ordering != "" && cp.Ordering == store.Comparator().Name() {
+		return store.BulkLoad(func(each Iterator) error {
+			return r.Each(func(key []byte, ref PageRef) { each(key, ref) })
+		})
+	}
+	return r.Each(func(key []byte, ref PageRef) { db.keys.Store(key, ref) })
+}
+
+// Returns true if the log can be replayed from pos
+func (db *DB) canReplay(pos PageRef) bool {
+	page, ok := db.pages[pos.ID]
+	return ok && pos.Offset >= PAGE_HEADER_LEN && pos.Offset <= page.pos()
+}
+
+// Writes a checkpoint file, if the key store supports it.
+// Persistent key stores are committed instead.
+func (db *DB) checkpoint() error {
+	if store, ok := db.keys.(PersistentKeyStore); ok {
+		db.cLock.Lock()
+		pos := db.position()
+		db.cLock.Unlock()
+
+		// Later writes are replayed on load
+		return store.Commit(pos)
+	}
+
+	keys, ok := db.keys.(UnorderedIterator)
+	if !ok || db.opts.NoCheckpoints {
+		return nil
+	}
+
+	// All writes prior to pos are reflected in the store,
+	// later writes are replayed on load
+	db.cLock.Lock()
+	pos := db.position()
+	db.pLock.RLock()
+	cp := &checkpoint{Position: pos, Pages: checkpointPages(db.pages, pos)}
+	db.pLock.RUnlock()
+	db.cLock.Unlock()
+
+	if store, ok := db.keys.(SortedKeyStore); ok {
+		cp.Ordering = store.Comparator().Name()
+	}
+
+	return cp.write(db.checkpointName(), keys)
+}
+
+// Background loop, writes periodic checkpoints and
+// commits persistent key stores when pages rotate
+func (db *DB) loop() {
+	defer close(db.eoloop)
+
+	var tick <-chan time.Time
+	if db.opts.CheckpointInterval != 0 {
+		ticker := time.NewTicker(db.opts.CheckpointInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-db.closer:
+			return
+		case <-tick:
+		case <-db.rotated:
+		}
+		db.checkpoint()
+	}
+}
+
+// Returns the current end position of the log
+func (db *DB) position() PageRef {
+	return PageRef{db.current.id, db.current.pos()}
+}
+
 // Creates a new page and moves the cursor
 func (db *DB) nextPage() error {
 	page, err := openPage(db.pageName(db.current.id + 1))
@@ -174,16 +841,42 @@ func (db *DB) nextPage() error {
 		return err
 	}
 
+	// Seal the bloom filter of the previous page. Failures are
+	// not critical, filters are rebuilt on open.
+	if prev := db.current; prev.bloom != nil {
+		prev.bloom.write(bloomName(prev))
+		page.bloom = newPageBloom(db.opts.BloomFalsePositiveRate)
+	}
+
 	db.makeCurrent(page)
+	db.signalRotation()
 	return nil
 }
 
+// Counts a page rotation, notifies the background loop.
+// Requires cLock.
+func (db *DB) signalRotation() {
+	atomic.AddUint64(&db.rotations, 1)
+	select {
+	case db.rotated <- struct{}{}:
+	default:
+	}
+}
+
 // Adds a new page to the registry, sets as current
 func (db *DB) makeCurrent(page *Page) {
+	db.pLock.Lock()
+	defer db.pLock.Unlock()
+
 	db.pages[page.id] = page
 	db.current = page
 }
 
+// Generate the checkpoint file name
+func (db *DB) checkpointName() string {
+	return filepath.Join(db.dir, "CHECKPOINT")
+}
+
 // Generate a page file name
 func (db *DB) pageName(id uint32) string {
 	return filepath.Join(db.dir, fmt.Sprintf("%08d.rcp", id))
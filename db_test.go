@@ -7,6 +7,7 @@ import (
 	"math/rand"
 	"os"
 	"path/filepath"
+	"sync"
 	"testing"
 
 	. "github.com/onsi/ginkgo"
@@ -84,6 +85,23 @@ var _ = Describe("DB", func() {
 		}))
 	})
 
+	It("should commit persistent key stores", func() {
+		Expect(subject.Close()).To(Succeed())
+		store := &persistentTestKeyStore{HashKeyStore: NewHashKeyStore()}
+		var err error
+		subject, err = Open(testDir, store)
+		Expect(err).NotTo(HaveOccurred())
+
+		fill()
+		Eventually(store.committed).ShouldNot(BeEmpty())
+		Expect(store.committed()[0].ID).To(Equal(uint32(1)))
+
+		Expect(subject.nextPage()).To(Succeed())
+		Expect(subject.Compact()).To(Succeed())
+		commits := store.committed()
+		Expect(commits[len(commits)-1]).To(Equal(subject.position()))
+	})
+
 	It("should validate arguments", func() {
 		_, err := subject.Set([]byte(""), []byte("val1"))
 		Expect(err).To(Equal(ERROR_KEY_BLANK))
@@ -129,7 +147,8 @@ var _ = Describe("DB", func() {
 
 		Expect(subject.pages).To(HaveLen(2))
 		Expect(subject.pages[0].header.Stats).To(Equal(PageStats{3, 2}))
-		Expect(subject.pages[1].header.Stats).To(Equal(PageStats{3, 0}))
+		Expect(subject.pages[1].header.Stats).To(Equal(PageStats{4, 0}))
+		Expect(subject.current.offset).To(Equal(uint32(188)))
 		Expect(keys.refs).To(Equal(map[string]PageRef{
 			"key1": {ID: 0, Offset: 128},
 			"key2": {ID: 1, Offset: 144},
@@ -139,6 +158,62 @@ var _ = Describe("DB", func() {
 
 		_, err = subject.Get([]byte("key3"))
 		Expect(err).To(Equal(ERROR_NOT_FOUND))
+
+		key, val, _, err := subject.current.read(176)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(key)).To(Equal("key3"))
+		Expect(val).To(BeEmpty())
+	})
+
+	It("should keep keys if tombstones cannot be written", func() {
+		fill()
+		Expect(subject.current.file.Close()).To(Succeed())
+
+		ok, err := subject.Delete([]byte("key1"))
+		Expect(err).To(HaveOccurred())
+		Expect(ok).To(BeFalse())
+		Expect(keys.refs).To(HaveKeyWithValue("key1", PageRef{ID: 0, Offset: 128}))
+		Expect(subject.pages[0].header.Stats).To(Equal(PageStats{3, 1}))
+	})
+
+	It("should count, list and clear keys", func() {
+		fill()
+		n, err := subject.Len()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(n).To(Equal(5))
+
+		list, err := subject.Keys()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(list).To(ConsistOf([]byte("key1"), []byte("key2"), []byte("key3"), []byte("key4"), []byte("key5")))
+		_, err = subject.KeyRange(nil, nil)
+		Expect(err).To(Equal(ERROR_NOT_SUPPORTED))
+
+		Expect(subject.Clear()).NotTo(HaveOccurred())
+		Expect(keys.refs).To(BeEmpty())
+		Expect(subject.pages[0].header.Stats).To(Equal(PageStats{3, 3}))
+		_, err = subject.Get([]byte("key1"))
+		Expect(err).To(Equal(ERROR_NOT_FOUND))
+		Expect(subject.Close()).NotTo(HaveOccurred())
+
+		// tombstones must survive a replay
+		keys = NewHashKeyStore()
+		subject, err = OpenWithOptions(testDir, keys, &Options{NoCheckpoints: true})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(keys.refs).To(BeEmpty())
+	})
+
+	It("should reject unsupported operations", func() {
+		Expect(subject.Close()).NotTo(HaveOccurred())
+
+		var err error
+		subject, err = Open(testDir, struct{ KeyStore }{NewHashKeyStore()})
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = subject.Len()
+		Expect(err).To(Equal(ERROR_NOT_SUPPORTED))
+		_, err = subject.Keys()
+		Expect(err).To(Equal(ERROR_NOT_SUPPORTED))
+		Expect(subject.Clear()).To(Equal(ERROR_NOT_SUPPORTED))
 	})
 
 	It("should reopen DBs", func() {
@@ -153,7 +228,7 @@ var _ = Describe("DB", func() {
 
 		Expect(subject.pages).To(HaveLen(2))
 		Expect(subject.pages[0].header.Stats).To(Equal(PageStats{3, 2}))
-		Expect(subject.pages[1].header.Stats).To(Equal(PageStats{3, 0}))
+		Expect(subject.pages[1].header.Stats).To(Equal(PageStats{4, 0}))
 
 		Expect(subject.current).NotTo(BeNil())
 		Expect(subject.current.id).To(Equal(uint32(1)))
@@ -165,8 +240,138 @@ var _ = Describe("DB", func() {
 		}))
 	})
 
+	It("should open DBs read-only", func() {
+		fill()
+		Expect(subject.Close()).To(Succeed())
+		files, _ := filepath.Glob(filepath.Join(testDir, "*"))
+		before, err := ioutil.ReadFile(filepath.Join(testDir, "00000000.rcp"))
+		Expect(err).NotTo(HaveOccurred())
+
+		subject, err = OpenWithOptions(testDir, NewHashKeyStore(), &Options{ReadOnly: true})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(subject.Get([]byte("key2"))).To(Equal([]byte("valX")))
+
+		_, err = subject.Set([]byte("key6"), []byte("val6"))
+		Expect(err).To(Equal(ERROR_READ_ONLY))
+		_, err = subject.Delete([]byte("key1"))
+		Expect(err).To(Equal(ERROR_READ_ONLY))
+
+		other, err := OpenWithOptions(testDir, NewHashKeyStore(), &Options{ReadOnly: true})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(other.Close()).To(Succeed())
+
+		_, err = Open(testDir, NewHashKeyStore())
+		Expect(err).To(Equal(ERROR_DB_LOCKED))
+
+		Expect(subject.Close()).To(Succeed())
+		after, err := ioutil.ReadFile(filepath.Join(testDir, "00000000.rcp"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(after).To(Equal(before))
+		Expect(filepath.Glob(filepath.Join(testDir, "*"))).To(Equal(files))
+	})
+
+	It("should not create DBs read-only", func() {
+		_, err := OpenWithOptions(filepath.Join(testDir, "missing"), NewHashKeyStore(), &Options{ReadOnly: true})
+		Expect(os.IsNotExist(err)).To(BeTrue())
+	})
+
+})
+
+var _ = Describe("DB with inline values", func() {
+	var subject *DB
+	var keys *HashKeyStore
+
+	var reopen = func() {
+		var err error
+		keys = NewHashKeyStore()
+		subject, err = OpenWithOptions(testDir, keys, &Options{InlineValueSize: 8})
+		Expect(err).NotTo(HaveOccurred())
+	}
+	var corrupt = func(key string) {
+		ref, ok := keys.Fetch([]byte(key))
+		Expect(ok).To(BeTrue())
+		_, err := subject.pages[ref.ID].file.WriteAt([]byte{0xff, 0xff}, int64(ref.Offset)+OH_KV+int64(len(key)))
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	BeforeEach(func() {
+		reopen()
+		_, err := subject.Set([]byte("small"), []byte("val1"))
+		Expect(err).NotTo(HaveOccurred())
+		_, err = subject.Set([]byte("large"), []byte("a larger value"))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		subject.Close()
+	})
+
+	It("should serve small values from memory", func() {
+		Expect(keys.inline).To(Equal(map[string][]byte{"small": []byte("val1")}))
+
+		corrupt("small")
+		val, err := subject.Get([]byte("small"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(val).To(Equal([]byte("val1")))
+
+		corrupt("large")
+		_, err = subject.Get([]byte("large"))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should drop inline values on update and delete", func() {
+		_, err := subject.Set([]byte("small"), []byte("a larger value"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(keys.inline).To(BeEmpty())
+
+		_, err = subject.Set([]byte("large"), []byte("val2"))
+		Expect(err).NotTo(HaveOccurred())
+		_, err = subject.Delete([]byte("large"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(keys.inline).To(BeEmpty())
+	})
+
+	It("should restore inline values from pages on reopen", func() {
+		Expect(subject.Close()).NotTo(HaveOccurred())
+		reopen()
+		Expect(keys.inline).To(BeEmpty())
+
+		val, err := subject.Get([]byte("small"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(val).To(Equal([]byte("val1")))
+		Expect(keys.inline).To(Equal(map[string][]byte{"small": []byte("val1")}))
+
+		val, err = subject.Get([]byte("large"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(val).To(Equal([]byte("a larger value")))
+		Expect(keys.inline).To(HaveLen(1))
+	})
+
 })
 
+// A persistent key store, which is never in sync
+type persistentTestKeyStore struct {
+	*HashKeyStore
+	commits []PageRef
+	lock    sync.Mutex
+}
+
+func (s *persistentTestKeyStore) Checkpoint() (PageRef, bool) { return PageRef{}, false }
+func (s *persistentTestKeyStore) Commit(pos PageRef) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.commits = append(s.commits, pos)
+	return nil
+}
+
+func (s *persistentTestKeyStore) committed() []PageRef {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return append([]PageRef(nil), s.commits...)
+}
+
 func BenchmarkDB_Writes_64(b *testing.B) { benchDB_writes(b, 64) }
 func BenchmarkDB_Writes_1K(b *testing.B) { benchDB_writes(b, 1*KiB) }
 func BenchmarkDB_Writes_1M(b *testing.B) { benchDB_writes(b, 1*MiB) }
@@ -0,0 +1,161 @@
+// Package digest implements a memory-efficient KeyStore, which keeps
+// 64-bit digests of keys instead of full keys.
+//
+// Digest collisions are resolved by chaining: colliding keys are
+// disambiguated by reading their full keys back from the pages, using
+// the KeyReader installed by the DB.
+package digest
+
+import (
+	"bytes"
+	"sync"
+
+	"github.com/bsm/rumcask"
+)
+
+// A digest based KeyStore implementation.
+// Keys are non-iterable and only their digests
+// are held in memory.
+type KeyStore struct {
+	refs   map[uint64]rumcask.PageRef
+	chains map[uint64][]rumcask.PageRef
+	reader rumcask.KeyReader
+	lock   sync.RWMutex
+}
+
+// NewKeyStore creates a new, empty store
+func NewKeyStore() *KeyStore {
+	return &KeyStore{
+		refs:   make(map[uint64]rumcask.PageRef),
+		chains: make(map[uint64][]rumcask.PageRef),
+	}
+}
+
+// SetKeyReader implements rumcask.DigestKeyStore
+func (s *KeyStore) SetKeyReader(reader rumcask.KeyReader) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.reader = reader
+}
+
+// Fetch retrieves the ref at key. Without collisions, the returned
+// ref is not verified and may point to a record of another key.
+func (s *KeyStore) Fetch(key []byte) (rumcask.PageRef, bool) {
+	digest := hashKey(key)
+
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if chain, ok := s.chains[digest]; ok {
+		if i := s.search(chain, key); i > -1 {
+			return chain[i], true
+		}
+		return rumcask.PageRef{}, false
+	}
+
+	ref, ok := s.refs[digest]
+	return ref, ok
+}
+
+// Store stores a key/ref pair
+func (s *KeyStore) Store(key []byte, ref rumcask.PageRef) (prev rumcask.PageRef, ok bool) {
+	digest := hashKey(key)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if chain, exists := s.chains[digest]; exists {
+		if i := s.search(chain, key); i > -1 {
+			prev, chain[i] = chain[i], ref
+			return prev, true
+		}
+		s.chains[digest] = append(chain, ref)
+		return
+	}
+
+	if prev, ok = s.refs[digest]; ok && !s.matches(prev, key) {
+		delete(s.refs, digest)
+		s.chains[digest] = []rumcask.PageRef{prev, ref}
+		return rumcask.PageRef{}, false
+	}
+
+	s.refs[digest] = ref
+	return
+}
+
+// Delete deletes a key
+func (s *KeyStore) Delete(key []byte) (prev rumcask.PageRef, ok bool) {
+	digest := hashKey(key)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if chain, exists := s.chains[digest]; exists {
+		i := s.search(chain, key)
+		if i < 0 {
+			return
+		}
+
+		prev = chain[i]
+		if chain = append(chain[:i], chain[i+1:]...); len(chain) == 1 {
+			delete(s.chains, digest)
+			s.refs[digest] = chain[0]
+		} else {
+			s.chains[digest] = chain
+		}
+		return prev, true
+	}
+
+	if prev, ok = s.refs[digest]; ok && s.matches(prev, key) {
+		delete(s.refs, digest)
+		return prev, true
+	}
+	return rumcask.PageRef{}, false
+}
+
+// Len returns the number of keys in the store
+func (s *KeyStore) Len() int {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	n := len(s.refs)
+	for _, chain := range s.chains {
+		n += len(chain)
+	}
+	return n
+}
+
+// Returns the index of the ref in chain which matches key
+func (s *KeyStore) search(chain []rumcask.PageRef, key []byte) int {
+	for i, ref := range chain {
+		if s.matches(ref, key) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Returns true if the record at ref is stored under key.
+// Without a reader, digests are trusted. Keys which cannot
+// be read never match, so other keys are not overwritten.
+func (s *KeyStore) matches(ref rumcask.PageRef, key []byte) bool {
+	if s.reader == nil {
+		return true
+	}
+
+	stored, err := s.reader(ref)
+	return err == nil && bytes.Equal(stored, key)
+}
+
+var hashKey = fnv64
+
+// FNV-1a 64-bit hash
+func fnv64(key []byte) uint64 {
+	hash := uint64(14695981039346656037)
+	for _, c := range key {
+		hash ^= uint64(c)
+		hash *= 1099511628211
+	}
+	return hash
+}
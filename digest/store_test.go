@@ -0,0 +1,151 @@
+package digest
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/bsm/rumcask"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("KeyStore", func() {
+	var subject *KeyStore
+	var _ rumcask.DigestKeyStore = subject // interface assertions
+
+	BeforeEach(func() {
+		subject = NewKeyStore()
+	})
+
+	It("should store/fetch/delete", func() {
+		_, ok := subject.Fetch([]byte("key1"))
+		Expect(ok).To(BeFalse())
+
+		_, ok = subject.Store([]byte("key1"), rumcask.PageRef{ID: 1, Offset: 1024})
+		Expect(ok).To(BeFalse())
+		_, ok = subject.Store([]byte("key2"), rumcask.PageRef{ID: 7, Offset: 8096})
+		Expect(ok).To(BeFalse())
+
+		ref, ok := subject.Store([]byte("key1"), rumcask.PageRef{ID: 2, Offset: 2048})
+		Expect(ok).To(BeTrue())
+		Expect(ref).To(Equal(rumcask.PageRef{ID: 1, Offset: 1024}))
+
+		ref, ok = subject.Fetch([]byte("key1"))
+		Expect(ok).To(BeTrue())
+		Expect(ref).To(Equal(rumcask.PageRef{ID: 2, Offset: 2048}))
+
+		ref, ok = subject.Delete([]byte("key2"))
+		Expect(ok).To(BeTrue())
+		Expect(ref).To(Equal(rumcask.PageRef{ID: 7, Offset: 8096}))
+		_, ok = subject.Delete([]byte("key2"))
+		Expect(ok).To(BeFalse())
+		Expect(subject.Len()).To(Equal(1))
+	})
+
+	Describe("with collisions", func() {
+		var keys map[rumcask.PageRef]string
+
+		BeforeEach(func() {
+			hashKey = func(_ []byte) uint64 { return 42 }
+			keys = make(map[rumcask.PageRef]string)
+			subject.SetKeyReader(func(ref rumcask.PageRef) ([]byte, error) {
+				return []byte(keys[ref]), nil
+			})
+		})
+
+		AfterEach(func() {
+			hashKey = fnv64
+		})
+
+		var store = func(key string, ref rumcask.PageRef) (rumcask.PageRef, bool) {
+			keys[ref] = key
+			return subject.Store([]byte(key), ref)
+		}
+
+		It("should not match keys which cannot be read", func() {
+			store("key1", rumcask.PageRef{ID: 1})
+			subject.SetKeyReader(func(ref rumcask.PageRef) ([]byte, error) {
+				return nil, rumcask.ERROR_BAD_OFFSET
+			})
+
+			_, ok := subject.Delete([]byte("key2"))
+			Expect(ok).To(BeFalse())
+			_, ok = subject.Store([]byte("key2"), rumcask.PageRef{ID: 2})
+			Expect(ok).To(BeFalse())
+			Expect(subject.chains[42]).To(Equal([]rumcask.PageRef{{ID: 1}, {ID: 2}}))
+		})
+
+		It("should chain keys", func() {
+			_, ok := store("key1", rumcask.PageRef{ID: 1})
+			Expect(ok).To(BeFalse())
+			_, ok = store("key2", rumcask.PageRef{ID: 2})
+			Expect(ok).To(BeFalse())
+			_, ok = store("key3", rumcask.PageRef{ID: 3})
+			Expect(ok).To(BeFalse())
+			Expect(subject.refs).To(BeEmpty())
+			Expect(subject.chains[42]).To(HaveLen(3))
+
+			prev, ok := store("key2", rumcask.PageRef{ID: 4})
+			Expect(ok).To(BeTrue())
+			Expect(prev).To(Equal(rumcask.PageRef{ID: 2}))
+
+			ref, ok := subject.Fetch([]byte("key2"))
+			Expect(ok).To(BeTrue())
+			Expect(ref).To(Equal(rumcask.PageRef{ID: 4}))
+			_, ok = subject.Fetch([]byte("key9"))
+			Expect(ok).To(BeFalse())
+
+			_, ok = subject.Delete([]byte("key9"))
+			Expect(ok).To(BeFalse())
+			_, ok = subject.Delete([]byte("key1"))
+			Expect(ok).To(BeTrue())
+			_, ok = subject.Delete([]byte("key2"))
+			Expect(ok).To(BeTrue())
+			Expect(subject.chains).To(BeEmpty())
+			Expect(subject.refs).To(Equal(map[uint64]rumcask.PageRef{42: {ID: 3}}))
+
+			// Unverified fetch, but verified deletion
+			ref, ok = subject.Fetch([]byte("key9"))
+			Expect(ok).To(BeTrue())
+			Expect(ref).To(Equal(rumcask.PageRef{ID: 3}))
+			_, ok = subject.Delete([]byte("key9"))
+			Expect(ok).To(BeFalse())
+		})
+
+		It("should be verified by DB", func() {
+			dir, err := ioutil.TempDir("", "rumcask-digest")
+			Expect(err).NotTo(HaveOccurred())
+			defer os.RemoveAll(dir)
+
+			subject = NewKeyStore()
+			db, err := rumcask.Open(dir, subject)
+			Expect(err).NotTo(HaveOccurred())
+			defer db.Close()
+
+			_, err = db.Set([]byte("key1"), []byte("val1"))
+			Expect(err).NotTo(HaveOccurred())
+			_, err = db.Get([]byte("key2"))
+			Expect(err).To(Equal(rumcask.ERROR_NOT_FOUND))
+
+			_, err = db.Set([]byte("key2"), []byte("val2"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(subject.Len()).To(Equal(2))
+
+			val, err := db.Get([]byte("key1"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(val).To(Equal([]byte("val1")))
+			val, err = db.Get([]byte("key2"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(val).To(Equal([]byte("val2")))
+		})
+	})
+
+})
+
+/** Test hook **/
+
+func TestSuite(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "rumcask/digest")
+}
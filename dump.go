@@ -0,0 +1,265 @@
+package rumcask
+
+import (
+	"io"
+	"os"
+)
+
+// DumpRecord describes a record found by DumpPage
+type DumpRecord struct {
+	// Offset of the record in the page file
+	Offset uint32
+	// Key and value lengths, as stored in the record
+	KeyLen, ValueLen int
+	// Deleted is true if the deletion marker is set
+	Deleted bool
+	// Valid is true if the lengths are within limits
+	// and the checksum matches
+	Valid bool
+	// Skipped is the number of bytes skipped after an invalid
+	// record, up to the next valid one or the end of the file
+	Skipped uint32
+	// Key and Value, truncated to the available bytes
+	// if the record is invalid
+	Key, Value []byte
+}
+
+// Tombstone returns true if the record is a valid tombstone
+func (r *DumpRecord) Tombstone() bool {
+	return r.Valid && r.ValueLen == 0
+}
+
+// Returns the total length of a valid record
+func (r *DumpRecord) size() int64 {
+	return int64(r.KeyLen+r.ValueLen) + OH_FULL
+}
+
+// PageDump summarises a page file, as read by DumpPage
+type PageDump struct {
+	// Size of the file
+	Size int64
+	// Version and Stats of the page header
+	Version uint8
+	Stats   PageStats
+	// HeaderErr is set if the page header is invalid
+	HeaderErr error
+
+	// Number of valid records, including deleted
+	// ones and tombstones
+	Records int
+	// Number of valid records with a deletion marker
+	Deleted int
+	// Number of invalid records
+	Corrupt int
+	// Total number of skipped bytes
+	Skipped int64
+}
+
+// DumpPage reads the page file fname record by record and calls
+// each for all of them, including deleted and invalid records.
+// After an invalid record, it resyncs by searching for the next
+// record which validates. Records are read even if the page header
+// is invalid. Iteration stops when each returns false, each may
+// be nil. Returns an error only if the file cannot be read.
+func DumpPage(fname string, each func(*DumpRecord) bool) (*PageDump, error) {
+	file, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	d := &PageDump{Size: info.Size()}
+	var header pageHeader
+	if err := header.read(file); err == io.EOF {
+		d.HeaderErr = ERROR_PAGE_BAD_HEADER
+	} else if err != nil {
+		d.HeaderErr = err
+	} else {
+		d.Version, d.Stats = header.Version, header.Stats
+	}
+
+	s := newRecordScanner(file, d.Size)
+	for {
+		rec, ok := s.next()
+		if !ok {
+			break
+		}
+
+		if !rec.Valid {
+			d.Corrupt++
+			d.Skipped += int64(rec.Skipped)
+		} else if d.Records++; rec.Deleted {
+			d.Deleted++
+		}
+		if each != nil && !each(rec) {
+			break
+		}
+	}
+	return d, s.err
+}
+
+// --------------------------------------------------------------------
+
+// Reads records from a page file, resyncs after invalid ones
+type recordScanner struct {
+	r    windowReader
+	size int64
+	pos  int64
+	err  error
+}
+
+func newRecordScanner(r io.ReaderAt, size int64) *recordScanner {
+	return &recordScanner{
+		r:    windowReader{r: r, buf: make([]byte, 64*KiB)},
+		size: size,
+		pos:  PAGE_HEADER_LEN,
+	}
+}
+
+// Returns the next record, false at the end of the file or on errors
+func (s *recordScanner) next() (*DumpRecord, bool) {
+	if s.pos >= s.size || s.err != nil {
+		return nil, false
+	}
+
+	rec := s.readAt(s.pos)
+	if s.err != nil {
+		return nil, false
+	}
+	if rec.Valid {
+		s.pos += rec.size()
+		return rec, true
+	}
+
+	next := s.resync(rec)
+	rec.Skipped = uint32(next - s.pos)
+	s.pos = next
+	return rec, s.err == nil
+}
+
+// Returns the offset of the next valid record after an invalid
+// one, or the end of the file. Tries the offset indicated by the
+// record lengths first.
+func (s *recordScanner) resync(rec *DumpRecord) int64 {
+	off := int64(rec.Offset)
+	if rec.KeyLen <= MAX_KEY_LEN && rec.ValueLen <= MAX_VALUE_LEN {
+		if end := off + rec.size(); end == s.size || (end < s.size && s.validAt(end)) {
+			return end
+		}
+	}
+
+	for off++; off+OH_FULL < s.size && s.err == nil; off++ {
+		if s.validAt(off) {
+			return off
+		}
+	}
+	return s.size
+}
+
+// Returns true if a record with a non-blank key and a
+// matching checksum starts at off
+func (s *recordScanner) validAt(off int64) bool {
+	lens := s.r.view(off, OH_KV)
+	if len(lens) < OH_KV {
+		s.err = s.r.err
+		return false
+	}
+
+	klen, vlen := recordLens(lens)
+	if klen < 1 || klen > MAX_KEY_LEN || vlen > MAX_VALUE_LEN {
+		return false
+	}
+
+	n := klen + vlen
+	if off+OH_FULL+int64(n) > s.size {
+		return false
+	}
+	data := s.r.view(off+OH_KV, n+OH_CSUM)
+	if len(data) < n+OH_CSUM {
+		s.err = s.r.err
+		return false
+	}
+	return CRC16(data[:n]) == binLE.Uint16(data[n:])
+}
+
+// Reads the record at off, validates lengths and checksum
+func (s *recordScanner) readAt(off int64) *DumpRecord {
+	rec := &DumpRecord{Offset: uint32(off)}
+
+	lens := s.r.view(off, OH_KV)
+	if len(lens) < OH_KV {
+		s.err = s.r.err
+		return rec
+	}
+	rec.Deleted = lens[OH_KV-1] > 127
+	rec.KeyLen, rec.ValueLen = recordLens(lens)
+
+	klen, vlen := rec.KeyLen, rec.ValueLen
+	avail := s.size - off - OH_KV
+	if klen > MAX_KEY_LEN || vlen > MAX_VALUE_LEN || int64(klen+vlen+OH_CSUM) > avail {
+		// Preview the key, as far as available
+		if int64(klen) > avail {
+			klen = int(avail)
+		}
+		if klen > MAX_KEY_LEN {
+			klen = MAX_KEY_LEN
+		}
+		rec.Key = append([]byte(nil), s.r.view(off+OH_KV, klen)...)
+		return rec
+	}
+
+	n := klen + vlen
+	data := s.r.view(off+OH_KV, n+OH_CSUM)
+	if len(data) < n+OH_CSUM {
+		s.err = s.r.err
+		return rec
+	}
+	rec.Key = append([]byte(nil), data[:klen]...)
+	rec.Value = append([]byte(nil), data[klen:n]...)
+	rec.Valid = CRC16(data[:n]) == binLE.Uint16(data[n:])
+	return rec
+}
+
+// Decodes key and value lengths, ignores the deletion marker
+func recordLens(lens []byte) (int, int) {
+	return int(binLE.Uint16(lens[0:])), int(binLE.Uint32(lens[OH_KEY:]) & 0x7fffffff)
+}
+
+// Buffers reads of nearby offsets
+type windowReader struct {
+	r   io.ReaderAt
+	buf []byte
+	off int64 // file offset of buf
+	n   int   // number of buffered bytes
+	err error
+}
+
+// Returns up to n bytes at off, fewer at the end of the file.
+// The result is only valid until the next call.
+func (w *windowReader) view(off int64, n int) []byte {
+	if off >= w.off && off+int64(n) <= w.off+int64(w.n) {
+		return w.buf[off-w.off : off-w.off+int64(n)]
+	}
+
+	// Large records bypass the buffer
+	buf, buffered := w.buf, n <= len(w.buf)
+	if !buffered {
+		buf = make([]byte, n)
+	}
+	m, err := w.r.ReadAt(buf, off)
+	if err != nil && err != io.EOF {
+		w.err = err
+	}
+	if buffered {
+		w.off, w.n = off, m
+	}
+	if m < n {
+		return buf[:m]
+	}
+	return buf[:n]
+}
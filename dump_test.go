@@ -0,0 +1,104 @@
+package rumcask
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("DumpPage", func() {
+	var fname string
+
+	var corrupt = func(offset int64, data ...byte) {
+		file, err := os.OpenFile(fname, os.O_WRONLY, 0)
+		Expect(err).NotTo(HaveOccurred())
+		defer file.Close()
+		_, err = file.WriteAt(data, offset)
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	var dump = func() ([]DumpRecord, *PageDump) {
+		var recs []DumpRecord
+		d, err := DumpPage(fname, func(rec *DumpRecord) bool {
+			recs = append(recs, *rec)
+			return true
+		})
+		Expect(err).NotTo(HaveOccurred())
+		return recs, d
+	}
+
+	BeforeEach(func() {
+		fname = filepath.Join(testDir, "00000001.rcp")
+		page, err := openPage(fname)
+		Expect(err).NotTo(HaveOccurred())
+		for i := 1; i <= 6; i++ {
+			_, err := page.write([]byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("val%d", i)))
+			Expect(err).NotTo(HaveOccurred())
+		}
+		Expect(page.delete(144)).To(Succeed())
+		Expect(page.close()).To(Succeed())
+	})
+
+	It("should dump intact pages", func() {
+		recs, d := dump()
+		Expect(recs).To(HaveLen(6))
+		Expect(recs[0]).To(Equal(DumpRecord{Offset: 128, KeyLen: 4, ValueLen: 4, Valid: true, Key: []byte("key1"), Value: []byte("val1")}))
+		Expect(recs[1]).To(Equal(DumpRecord{Offset: 144, KeyLen: 4, ValueLen: 4, Valid: true, Deleted: true, Key: []byte("key2"), Value: []byte("val2")}))
+		Expect(d).To(Equal(&PageDump{Size: 224, Version: VERSION, Stats: PageStats{6, 1}, Records: 6, Deleted: 1}))
+	})
+
+	It("should report corrupt records and resync", func() {
+		corrupt(170, 'X')        // value of key3
+		corrupt(192, 0xff, 0xff) // key length of key5
+		corrupt(224, 1, 2, 3)    // torn write
+
+		recs, d := dump()
+		Expect(recs).To(HaveLen(7))
+
+		offsets := make([]uint32, 0, len(recs))
+		for _, rec := range recs {
+			offsets = append(offsets, rec.Offset)
+		}
+		Expect(offsets).To(Equal([]uint32{128, 144, 160, 176, 192, 208, 224}))
+
+		Expect(recs[2]).To(Equal(DumpRecord{Offset: 160, KeyLen: 4, ValueLen: 4, Skipped: 16, Key: []byte("key3"), Value: []byte("Xal3")}))
+		Expect(recs[4].Valid).To(BeFalse())
+		Expect(recs[4].KeyLen).To(Equal(65535))
+		Expect(recs[4].Skipped).To(Equal(uint32(16)))
+		Expect(recs[4].Key).To(HaveLen(29))
+		Expect(recs[5].Key).To(Equal([]byte("key6")))
+		Expect(recs[5].Valid).To(BeTrue())
+		Expect(recs[6]).To(Equal(DumpRecord{Offset: 224, Skipped: 3}))
+
+		Expect(d.Records).To(Equal(4))
+		Expect(d.Deleted).To(Equal(1))
+		Expect(d.Corrupt).To(Equal(3))
+		Expect(d.Skipped).To(Equal(int64(35)))
+	})
+
+	It("should read records of pages with bad headers", func() {
+		corrupt(0, 'X')
+
+		recs, d := dump()
+		Expect(recs).To(HaveLen(6))
+		Expect(d.HeaderErr).To(Equal(ERROR_PAGE_BAD_HEADER))
+		Expect(d.Records).To(Equal(6))
+	})
+
+	It("should stop early", func() {
+		n := 0
+		d, err := DumpPage(fname, func(*DumpRecord) bool { n++; return n < 2 })
+		Expect(err).NotTo(HaveOccurred())
+		Expect(n).To(Equal(2))
+		Expect(d.Records).To(Equal(2))
+	})
+
+	It("should fail on missing files", func() {
+		_, err := DumpPage(filepath.Join(testDir, "missing.rcp"), nil)
+		Expect(os.IsNotExist(err)).To(BeTrue())
+	})
+
+})
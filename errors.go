@@ -4,11 +4,22 @@ import "strconv"
 
 const (
 	// DB errors
-	ERROR_DB_LOCKED Error = -100
+	ERROR_DB_LOCKED           Error = -100
+	ERROR_CHECKPOINT_INVALID  Error = -101
+	ERROR_META_INVALID        Error = -102
+	ERROR_COMPARATOR_MISMATCH Error = -103
+	ERROR_NOT_SUPPORTED       Error = -104
+	ERROR_INDEX_EXISTS        Error = -105
+	ERROR_INDEX_NOT_FOUND     Error = -106
+	ERROR_BAD_POSITION        Error = -107
+	ERROR_READ_ONLY           Error = -108
+	ERROR_REPLICATION         Error = -109
+	ERROR_BACKUP_INVALID      Error = -110
 
 	// Page errors
 	ERROR_PAGE_INVALID    Error = -200
 	ERROR_PAGE_BAD_HEADER Error = -201
+	ERROR_BLOOM_INVALID   Error = -202
 
 	// KV errors
 	ERROR_NOT_FOUND      Error = -300
@@ -33,9 +44,20 @@ func (e Error) Error() string {
 
 var errorMessages = map[int]string{
 	-100: "database directory is locked by another process",
+	-101: "invalid checkpoint",
+	-102: "invalid metadata",
+	-103: "comparator does not match the one the database was created with",
+	-104: "operation not supported by the key store",
+	-105: "index already exists",
+	-106: "index not found",
+	-107: "log position is not available",
+	-108: "database is read-only",
+	-109: "replication protocol error",
+	-110: "invalid backup",
 
 	-200: "invalid page",
 	-201: "invalid page header",
+	-202: "invalid bloom filter",
 
 	-300: "not found",
 	-301: "invalid offset",
@@ -14,14 +14,19 @@ type fileLock struct {
 	f *os.File
 }
 
-func newFileLock(fname string) (fl *fileLock, err error) {
+func newFileLock(fname string, readOnly bool) (fl *fileLock, err error) {
+	flag, how := os.O_RDWR|os.O_CREATE, syscall.LOCK_EX
+	if readOnly {
+		flag, how = os.O_RDONLY, syscall.LOCK_SH
+	}
+
 	fl = &fileLock{}
-	if fl.f, err = os.OpenFile(fname, os.O_RDWR|os.O_CREATE, 0644); err != nil {
+	if fl.f, err = os.OpenFile(fname, flag, 0644); err != nil {
 		fl = nil
 		return
 	}
 
-	if err = fl.flock(syscall.LOCK_EX); err != nil {
+	if err = fl.flock(how); err != nil {
 		if err == syscall.EAGAIN {
 			err = ERROR_DB_LOCKED
 		}
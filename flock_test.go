@@ -11,11 +11,31 @@ var _ = Describe("fileLock", func() {
 
 	It("should lock files exclusively", func() {
 		fname := filepath.Join(testDir, "LOCK")
-		flock, err := newFileLock(fname)
+		flock, err := newFileLock(fname, false)
 		Expect(err).NotTo(HaveOccurred())
 		defer flock.release()
 
-		_, err = newFileLock(fname)
+		_, err = newFileLock(fname, false)
+		Expect(err).To(Equal(ERROR_DB_LOCKED))
+		_, err = newFileLock(fname, true)
+		Expect(err).To(Equal(ERROR_DB_LOCKED))
+	})
+
+	It("should share read-only locks", func() {
+		fname := filepath.Join(testDir, "LOCK")
+		flock, err := newFileLock(fname, false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(flock.release()).To(Succeed())
+
+		r1, err := newFileLock(fname, true)
+		Expect(err).NotTo(HaveOccurred())
+		defer r1.release()
+
+		r2, err := newFileLock(fname, true)
+		Expect(err).NotTo(HaveOccurred())
+		defer r2.release()
+
+		_, err = newFileLock(fname, false)
 		Expect(err).To(Equal(ERROR_DB_LOCKED))
 	})
 
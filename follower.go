@@ -0,0 +1,354 @@
+package rumcask
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// ReplicaStats contains the replication state of a Follower
+type ReplicaStats struct {
+	// True, if connected to the leader
+	Connected bool
+	// Last reported end position of the leader
+	Leader Position
+	// End position of the applied log
+	Applied Position
+	// Number of bytes behind the leader, as last reported
+	Lag int64
+	// Time of the last frame received from the leader
+	LastContact time.Time
+	// Number of connection attempts after the first
+	Reconnects int
+	// Last replication error, if any
+	Err error
+}
+
+// Follower replicates the log of a leader DB into a local,
+// read-only DB. It reconnects and catches up automatically.
+type Follower struct {
+	db   *DB
+	addr string
+
+	stats ReplicaStats
+	conn  net.Conn
+	lock  sync.Mutex
+
+	closer, done chan struct{}
+}
+
+// Follow opens a read-only DB in dir and starts to replicate
+// from the leader at addr. Options.ReadOnly is implied, the DB
+// only accepts replicated writes.
+func Follow(dir string, keys KeyStore, addr string, opts *Options) (*Follower, error) {
+	// Pages must be writable for replication, writes
+	// of other callers are blocked below
+	var o Options
+	if opts != nil {
+		o = *opts
+	}
+	o.ReadOnly = false
+
+	db, err := OpenWithOptions(dir, keys, &o)
+	if err != nil {
+		return nil, err
+	}
+	db.readOnly = true
+
+	f := &Follower{
+		db:     db,
+		addr:   addr,
+		closer: make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go f.loop()
+	return f, nil
+}
+
+// DB returns the local, read-only DB
+func (f *Follower) DB() *DB {
+	return f.db
+}
+
+// Get retrieves a value from the local DB
+func (f *Follower) Get(key []byte) ([]byte, error) {
+	return f.db.Get(key)
+}
+
+// Stats returns the replication state
+func (f *Follower) Stats() ReplicaStats {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	stats := f.stats
+	stats.Applied = f.db.Position()
+	return stats
+}
+
+// Close stops replication and closes the local DB
+func (f *Follower) Close() error {
+	f.lock.Lock()
+	select {
+	case <-f.closer:
+		f.lock.Unlock()
+		return nil
+	default:
+	}
+	close(f.closer)
+	if f.conn != nil {
+		f.conn.Close()
+	}
+	f.lock.Unlock()
+
+	<-f.done
+	return f.db.Close()
+}
+
+// Connects and replicates until closed
+func (f *Follower) loop() {
+	defer close(f.done)
+
+	for attempt := 0; ; attempt++ {
+		err := f.session(attempt)
+
+		f.lock.Lock()
+		f.stats.Connected = false
+		if err != nil {
+			f.stats.Err = err
+		}
+		f.lock.Unlock()
+
+		select {
+		case <-f.closer:
+			return
+		case <-time.After(f.db.opts.ReplicationRetry):
+		}
+	}
+}
+
+// Runs a single replication session
+func (f *Follower) session(attempt int) error {
+	conn, err := net.DialTimeout("tcp", f.addr, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	f.lock.Lock()
+	select {
+	case <-f.closer:
+		f.lock.Unlock()
+		return nil
+	default:
+	}
+	f.conn = conn
+	if attempt > 0 {
+		f.stats.Reconnects++
+	}
+	f.stats.Connected = true
+	f.stats.Err = nil
+	f.lock.Unlock()
+
+	if err := f.writeHandshake(conn); err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(conn)
+	ack := make([]byte, 8)
+	for {
+		typ, err := r.ReadByte()
+		if err != nil {
+			return f.connErr(err)
+		}
+
+		switch typ {
+		case replFrameData:
+			buf := make([]byte, 12)
+			if _, err := io.ReadFull(r, buf); err != nil {
+				return f.connErr(err)
+			}
+			pos := Position{ID: binLE.Uint32(buf[0:]), Offset: binLE.Uint32(buf[4:])}
+			data := make([]byte, binLE.Uint32(buf[8:]))
+			if _, err := io.ReadFull(r, data); err != nil {
+				return f.connErr(err)
+			}
+			if err := f.db.applyReplicated(pos, data); err != nil {
+				return err
+			}
+
+			applied := f.db.Position()
+			binLE.PutUint32(ack[0:], applied.ID)
+			binLE.PutUint32(ack[4:], applied.Offset)
+			if _, err := conn.Write(ack); err != nil {
+				return f.connErr(err)
+			}
+			f.touch(nil)
+		case replFrameHeartbeat:
+			buf := make([]byte, 16)
+			if _, err := io.ReadFull(r, buf); err != nil {
+				return f.connErr(err)
+			}
+			f.touch(&ReplicaStats{
+				Leader: Position{ID: binLE.Uint32(buf[0:]), Offset: binLE.Uint32(buf[4:])},
+				Lag:    int64(binLE.Uint64(buf[8:])),
+			})
+		case replFrameError:
+			buf := make([]byte, 4)
+			if _, err := io.ReadFull(r, buf); err != nil {
+				return f.connErr(err)
+			}
+			return Error(int32(binLE.Uint32(buf)))
+		default:
+			return ERROR_REPLICATION
+		}
+	}
+}
+
+// Sends the position to replicate from
+func (f *Follower) writeHandshake(conn net.Conn) error {
+	pos := f.db.Position()
+
+	buf := make([]byte, len(_REPL_MAGIC)+9)
+	copy(buf, _REPL_MAGIC)
+	rest := buf[len(_REPL_MAGIC):]
+	if f.db.empty() {
+		rest[0] |= replFlagEmpty
+	}
+	binLE.PutUint32(rest[1:], pos.ID)
+	binLE.PutUint32(rest[5:], pos.Offset)
+	_, err := conn.Write(buf)
+	return err
+}
+
+// Records contact with the leader and optionally the
+// reported leader state
+func (f *Follower) touch(report *ReplicaStats) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.stats.LastContact = time.Now()
+	if report != nil {
+		f.stats.Leader, f.stats.Lag = report.Leader, report.Lag
+	}
+}
+
+// Returns nil for errors caused by Close
+func (f *Follower) connErr(err error) error {
+	select {
+	case <-f.closer:
+		return nil
+	default:
+		return err
+	}
+}
+
+// --------------------------------------------------------------------
+
+// Returns true if the log contains no records
+func (db *DB) empty() bool {
+	db.pLock.RLock()
+	defer db.pLock.RUnlock()
+
+	return len(db.pages) == 1 && db.current.pos() == PAGE_HEADER_LEN
+}
+
+// Appends replicated page data at pos and applies the contained
+// records to the key store
+func (db *DB) applyReplicated(pos Position, data []byte) error {
+	db.cLock.Lock()
+	defer db.cLock.Unlock()
+
+	if err := db.switchPage(pos.ID); err != nil {
+		return err
+	}
+
+	// Validate all records first, invalid data is never written
+	recs, err := parseReplicated(pos.Offset, data)
+	if err != nil {
+		return err
+	}
+
+	page := db.current
+	if err := page.writeRaw(pos.Offset, data); err != nil {
+		return err
+	}
+
+	for _, rec := range recs {
+		page.header.recWritten()
+		if len(rec.value) != 0 && page.bloom != nil {
+			page.bloom.add(rec.key, rec.offset+uint32(len(rec.key)+len(rec.value))+OH_FULL)
+		}
+		db.applyRecord(rec.key, rec.value, PageRef{ID: page.id, Offset: rec.offset})
+	}
+
+	db.notify()
+	return nil
+}
+
+// A record of replicated page data
+type replRecord struct {
+	key, value []byte
+	offset     uint32
+}
+
+// Parses replicated page data, starting at offset. Returns an error
+// unless data consists of complete records with valid checksums.
+func parseReplicated(offset uint32, data []byte) ([]replRecord, error) {
+	var recs []replRecord
+	for len(data) != 0 {
+		if len(data) < OH_FULL {
+			return nil, ERROR_REPLICATION
+		}
+
+		klen := int(binLE.Uint16(data[0:]))
+		vlen := int(binLE.Uint32(data[OH_KEY:]) &^ (1 << 31)) // strip deletion marker
+		if klen > MAX_KEY_LEN || vlen > MAX_VALUE_LEN || len(data) < OH_FULL+klen+vlen {
+			return nil, ERROR_REPLICATION
+		}
+
+		pair, csum := data[OH_KV:OH_KV+klen+vlen], data[OH_KV+klen+vlen:]
+		if CRC16(pair) != binLE.Uint16(csum) {
+			return nil, ERROR_BAD_CHECKSUM
+		}
+
+		recs = append(recs, replRecord{key: pair[:klen], value: pair[klen:], offset: offset})
+		offset += uint32(OH_FULL + klen + vlen)
+		data = data[OH_FULL+klen+vlen:]
+	}
+	return recs, nil
+}
+
+// Makes the page with id current, creates it if needed.
+// An empty, current page is replaced. Requires cLock.
+func (db *DB) switchPage(id uint32) error {
+	prev := db.current
+	switch {
+	case id == prev.id:
+		return nil
+	case id < prev.id:
+		return ERROR_REPLICATION
+	}
+
+	page, err := openPage(db.pageName(id))
+	if err != nil {
+		return err
+	}
+	if prev.bloom != nil {
+		prev.bloom.write(bloomName(prev))
+		page.bloom = newPageBloom(db.opts.BloomFalsePositiveRate)
+	}
+	db.makeCurrent(page)
+	db.signalRotation()
+
+	if prev.pos() == PAGE_HEADER_LEN {
+		db.pLock.Lock()
+		delete(db.pages, prev.id)
+		db.pLock.Unlock()
+		os.Remove(bloomName(prev))
+		return prev.unlink()
+	}
+	return nil
+}
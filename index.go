@@ -0,0 +1,80 @@
+package rumcask
+
+import "sort"
+
+// IndexFunc extracts the index values of a key/value pair.
+// The function must not retain key or value.
+type IndexFunc func(key, value []byte) [][]byte
+
+// A secondary index, maps index values to primary keys.
+// Indexes are held in memory and derived from the stored
+// records, they are built when added to a DB.
+type index struct {
+	fn      IndexFunc
+	entries map[string]map[string]struct{} // index value -> primary keys
+	values  map[string][]string            // primary key -> index values
+}
+
+func newIndex(fn IndexFunc) *index {
+	return &index{
+		fn:      fn,
+		entries: make(map[string]map[string]struct{}),
+		values:  make(map[string][]string),
+	}
+}
+
+// Replaces the entries of key
+func (x *index) set(key, value []byte) {
+	skey := string(key)
+	x.remove(skey)
+
+	var values []string
+	for _, v := range x.fn(key, value) {
+		sval := string(v)
+		keys, ok := x.entries[sval]
+		if !ok {
+			keys = make(map[string]struct{})
+			x.entries[sval] = keys
+		} else if _, dup := keys[skey]; dup {
+			continue
+		}
+		keys[skey] = struct{}{}
+		values = append(values, sval)
+	}
+	if len(values) != 0 {
+		x.values[skey] = values
+	}
+}
+
+// Removes the entries of key
+func (x *index) remove(skey string) {
+	for _, sval := range x.values[skey] {
+		keys := x.entries[sval]
+		if delete(keys, skey); len(keys) == 0 {
+			delete(x.entries, sval)
+		}
+	}
+	delete(x.values, skey)
+}
+
+// Returns the sorted primary keys for an index value
+func (x *index) lookup(value []byte) [][]byte {
+	keys := x.entries[string(value)]
+	skeys := make([]string, 0, len(keys))
+	for skey := range keys {
+		skeys = append(skeys, skey)
+	}
+	sort.Strings(skeys)
+
+	res := make([][]byte, len(skeys))
+	for i, skey := range skeys {
+		res[i] = []byte(skey)
+	}
+	return res
+}
+
+// Removes all entries
+func (x *index) reset() {
+	x.entries = make(map[string]map[string]struct{})
+	x.values = make(map[string][]string)
+}
@@ -0,0 +1,131 @@
+package rumcask
+
+import (
+	"bytes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// Indexes values of the form "status:email"
+func byStatus(_, value []byte) [][]byte {
+	if i := bytes.IndexByte(value, ':'); i > 0 {
+		return [][]byte{value[:i]}
+	}
+	return nil
+}
+
+func byEmail(_, value []byte) [][]byte {
+	if i := bytes.IndexByte(value, ':'); i > -1 && i+1 < len(value) {
+		return [][]byte{value[i+1:]}
+	}
+	return nil
+}
+
+var _ = Describe("index", func() {
+
+	It("should maintain entries", func() {
+		x := newIndex(byStatus)
+		x.set([]byte("k1"), []byte("active:a@example.com"))
+		x.set([]byte("k2"), []byte("active:b@example.com"))
+		x.set([]byte("k3"), []byte("blocked:c@example.com"))
+		x.set([]byte("k4"), []byte("none"))
+		Expect(x.lookup([]byte("active"))).To(Equal([][]byte{[]byte("k1"), []byte("k2")}))
+
+		x.set([]byte("k1"), []byte("blocked:a@example.com"))
+		x.remove("k3")
+		Expect(x.lookup([]byte("active"))).To(Equal([][]byte{[]byte("k2")}))
+		Expect(x.lookup([]byte("blocked"))).To(Equal([][]byte{[]byte("k1")}))
+		Expect(x.entries).To(HaveLen(2))
+		Expect(x.values).To(HaveLen(2))
+	})
+
+	It("should ignore duplicate values", func() {
+		x := newIndex(func(_, value []byte) [][]byte { return [][]byte{value, value} })
+		x.set([]byte("k1"), []byte("v"))
+		Expect(x.values["k1"]).To(HaveLen(1))
+		x.remove("k1")
+		Expect(x.entries).To(BeEmpty())
+	})
+
+})
+
+var _ = Describe("DB with indexes", func() {
+	var subject *DB
+
+	var set = func(key, value string) {
+		_, err := subject.Set([]byte(key), []byte(value))
+		Expect(err).NotTo(HaveOccurred())
+	}
+	var lookup = func(name, value string) []string {
+		keys, err := subject.Lookup(name, []byte(value))
+		Expect(err).NotTo(HaveOccurred())
+
+		res := make([]string, len(keys))
+		for i, key := range keys {
+			res[i] = string(key)
+		}
+		return res
+	}
+
+	BeforeEach(func() {
+		var err error
+		subject, err = Open(testDir, NewHashKeyStore())
+		Expect(err).NotTo(HaveOccurred())
+
+		set("u1", "active:a@example.com")
+		set("u2", "blocked:b@example.com")
+		Expect(subject.AddIndex("status", byStatus)).NotTo(HaveOccurred())
+		Expect(subject.AddIndex("email", byEmail)).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		subject.Close()
+	})
+
+	It("should build indexes from existing records", func() {
+		Expect(lookup("status", "active")).To(Equal([]string{"u1"}))
+		Expect(lookup("email", "b@example.com")).To(Equal([]string{"u2"}))
+		Expect(subject.AddIndex("status", byStatus)).To(Equal(ERROR_INDEX_EXISTS))
+	})
+
+	It("should update indexes on set and delete", func() {
+		set("u3", "active:c@example.com")
+		set("u1", "blocked:a@example.com")
+		Expect(lookup("status", "active")).To(Equal([]string{"u3"}))
+		Expect(lookup("status", "blocked")).To(Equal([]string{"u1", "u2"}))
+
+		_, err := subject.Delete([]byte("u2"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(lookup("status", "blocked")).To(Equal([]string{"u1"}))
+		Expect(lookup("email", "b@example.com")).To(BeEmpty())
+
+		Expect(subject.Clear()).NotTo(HaveOccurred())
+		Expect(lookup("status", "blocked")).To(BeEmpty())
+	})
+
+	It("should rebuild and drop indexes", func() {
+		Expect(subject.RebuildIndex("status")).NotTo(HaveOccurred())
+		Expect(lookup("status", "active")).To(Equal([]string{"u1"}))
+
+		Expect(subject.DropIndex("status")).NotTo(HaveOccurred())
+		_, err := subject.Lookup("status", []byte("active"))
+		Expect(err).To(Equal(ERROR_INDEX_NOT_FOUND))
+		Expect(subject.RebuildIndex("status")).To(Equal(ERROR_INDEX_NOT_FOUND))
+		Expect(subject.DropIndex("status")).To(Equal(ERROR_INDEX_NOT_FOUND))
+	})
+
+	It("should restore indexes on reopen", func() {
+		Expect(subject.Close()).NotTo(HaveOccurred())
+
+		var err error
+		subject, err = Open(testDir, NewHashKeyStore())
+		Expect(err).NotTo(HaveOccurred())
+		_, err = subject.Lookup("status", []byte("active"))
+		Expect(err).To(Equal(ERROR_INDEX_NOT_FOUND))
+
+		Expect(subject.AddIndex("status", byStatus)).NotTo(HaveOccurred())
+		Expect(lookup("status", "blocked")).To(Equal([]string{"u2"}))
+	})
+
+})
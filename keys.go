@@ -22,11 +22,102 @@ type KeyStore interface {
 	Fetch(key []byte) (PageRef, bool)
 }
 
+// Iterator allows callers to iterate key/ref pairs.
+// When this function returns false, iteration will
+// stop immediately.
+type Iterator func(key []byte, ref PageRef) bool
+
+// UnorderedIterator is implemented by KeyStores which
+// can visit all stored keys, in no particular order.
+// It is required for checkpointing.
+type UnorderedIterator interface {
+	// ForEach calls the iterator for each stored key.
+	// The store must not be modified by the iterator.
+	ForEach(each Iterator)
+}
+
+// OrderedIterator is implemented by KeyStores which can
+// visit keys in order.
+type OrderedIterator interface {
+	// Iterate calls the iterator for each key >= min and < max,
+	// in order. A nil min or max leaves the range unbounded.
+	// The store must not be modified by the iterator.
+	Iterate(min, max []byte, each Iterator)
+}
+
+// Counter is implemented by KeyStores which can count
+// their keys.
+type Counter interface {
+	// Len returns the number of stored keys.
+	Len() int
+}
+
+// Resetter is implemented by KeyStores which can remove
+// all keys at once.
+type Resetter interface {
+	// Reset removes all keys from the store.
+	Reset() error
+}
+
+// PersistentKeyStore is implemented by KeyStores which retain
+// their keys across restarts. Open skips parsing pages when the
+// persisted keys are in sync with the page files.
+type PersistentKeyStore interface {
+	KeyStore
+
+	// Checkpoint returns the position (ID of the last page and
+	// the end offset within that page) up to which the stored
+	// keys are complete. Returns false if the store was not
+	// committed cleanly.
+	Checkpoint() (PageRef, bool)
+
+	// Commit persists all pending changes and marks the store
+	// as complete up to the given position.
+	Commit(pos PageRef) error
+
+	Resetter
+}
+
+// KeyReader reads the key of the record at ref
+type KeyReader func(ref PageRef) ([]byte, error)
+
+// DigestKeyStore is implemented by KeyStores which only keep
+// digests of keys rather than full keys. These stores need to
+// read keys back from the pages to resolve digest collisions.
+// DB.Get validates the key of each fetched record.
+type DigestKeyStore interface {
+	KeyStore
+
+	// SetKeyReader is called by Open, before any keys are stored.
+	SetKeyReader(KeyReader)
+}
+
+// InlineKeyStore is implemented by KeyStores which can hold
+// small values next to their refs. The page log remains the
+// source of truth, inline values are a cache.
+type InlineKeyStore interface {
+	KeyStore
+
+	// StoreInline stores a key/ref pair together with a copy
+	// of the value. A subsequent Store drops the value.
+	StoreInline(key []byte, ref PageRef, value []byte) (PageRef, bool)
+
+	// CacheInline stores a copy of the value, only if the key
+	// is still stored at ref. Returns true if the value was stored.
+	CacheInline(key []byte, ref PageRef, value []byte) bool
+
+	// FetchInline retrieves the ref and the inline value for a key,
+	// the value is nil if not held inline. Returns false if
+	// not found.
+	FetchInline(key []byte) (PageRef, []byte, bool)
+}
+
 // A HashKeyStore is the simples KeyStore implementation.
 // Keys are non-iterable and are held in memory all the time.
 type HashKeyStore struct {
-	refs map[string]PageRef
-	lock sync.Mutex
+	refs   map[string]PageRef
+	inline map[string][]byte
+	lock   sync.Mutex
 }
 
 // NewHashKeyStore creates a new, empty HashKeyStore
@@ -50,9 +141,49 @@ func (s *HashKeyStore) Store(key []byte, ref PageRef) (PageRef, bool) {
 
 	prev, ok := s.refs[skey]
 	s.refs[skey] = ref
+	delete(s.inline, skey)
+	return prev, ok
+}
+
+func (s *HashKeyStore) StoreInline(key []byte, ref PageRef, value []byte) (PageRef, bool) {
+	skey := string(key)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.inline == nil {
+		s.inline = make(map[string][]byte)
+	}
+	prev, ok := s.refs[skey]
+	s.refs[skey] = ref
+	s.inline[skey] = append([]byte(nil), value...)
 	return prev, ok
 }
 
+func (s *HashKeyStore) CacheInline(key []byte, ref PageRef, value []byte) bool {
+	skey := string(key)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if cur, ok := s.refs[skey]; !ok || cur != ref {
+		return false
+	}
+	if s.inline == nil {
+		s.inline = make(map[string][]byte)
+	}
+	s.inline[skey] = append([]byte(nil), value...)
+	return true
+}
+
+func (s *HashKeyStore) FetchInline(key []byte) (PageRef, []byte, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	ref, ok := s.refs[string(key)]
+	return ref, s.inline[string(key)], ok
+}
+
 func (s *HashKeyStore) Delete(key []byte) (PageRef, bool) {
 	skey := string(key)
 
@@ -61,5 +192,208 @@ func (s *HashKeyStore) Delete(key []byte) (PageRef, bool) {
 
 	prev, ok := s.refs[skey]
 	delete(s.refs, skey)
+	delete(s.inline, skey)
+	return prev, ok
+}
+
+func (s *HashKeyStore) Len() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return len(s.refs)
+}
+
+func (s *HashKeyStore) Reset() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.refs, s.inline = make(map[string]PageRef), nil
+	return nil
+}
+
+func (s *HashKeyStore) ForEach(each Iterator) {
+	// Iterate a copy, iterators may block on I/O
+	s.lock.Lock()
+	entries := copyEntries(s.refs)
+	s.lock.Unlock()
+
+	for _, e := range entries {
+		if !each([]byte(e.key), e.ref) {
+			return
+		}
+	}
+}
+
+// A key/ref pair of a hash store
+type hashEntry struct {
+	key string
+	ref PageRef
+}
+
+// Copies all pairs of a hash store, keys are shared
+func copyEntries(refs map[string]PageRef) []hashEntry {
+	entries := make([]hashEntry, 0, len(refs))
+	for skey, ref := range refs {
+		entries = append(entries, hashEntry{key: skey, ref: ref})
+	}
+	return entries
+}
+
+// A ShardedHashKeyStore is a HashKeyStore variant, which
+// spreads keys across multiple shards, each guarded by its
+// own lock. It reduces contention under concurrent access.
+type ShardedHashKeyStore struct {
+	shards []hashShard
+	mask   uint32
+}
+
+type hashShard struct {
+	refs   map[string]PageRef
+	inline map[string][]byte
+	lock   sync.RWMutex
+	_      [24]byte // pad to cache line size
+}
+
+// NewShardedHashKeyStore creates a new, empty store with n shards.
+// The number of shards is rounded up to the next power of two.
+func NewShardedHashKeyStore(n int) *ShardedHashKeyStore {
+	size := 1
+	for size < n {
+		size <<= 1
+	}
+
+	shards := make([]hashShard, size)
+	for i := range shards {
+		shards[i].refs = make(map[string]PageRef)
+	}
+	return &ShardedHashKeyStore{shards: shards, mask: uint32(size - 1)}
+}
+
+func (s *ShardedHashKeyStore) Fetch(key []byte) (PageRef, bool) {
+	shard := s.shard(key)
+	shard.lock.RLock()
+	defer shard.lock.RUnlock()
+
+	ref, ok := shard.refs[string(key)]
+	return ref, ok
+}
+
+func (s *ShardedHashKeyStore) Store(key []byte, ref PageRef) (PageRef, bool) {
+	skey := string(key)
+	shard := s.shard(key)
+
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+
+	prev, ok := shard.refs[skey]
+	shard.refs[skey] = ref
+	delete(shard.inline, skey)
+	return prev, ok
+}
+
+func (s *ShardedHashKeyStore) StoreInline(key []byte, ref PageRef, value []byte) (PageRef, bool) {
+	skey := string(key)
+	shard := s.shard(key)
+
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+
+	if shard.inline == nil {
+		shard.inline = make(map[string][]byte)
+	}
+	prev, ok := shard.refs[skey]
+	shard.refs[skey] = ref
+	shard.inline[skey] = append([]byte(nil), value...)
+	return prev, ok
+}
+
+func (s *ShardedHashKeyStore) CacheInline(key []byte, ref PageRef, value []byte) bool {
+	skey := string(key)
+	shard := s.shard(key)
+
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+
+	if cur, ok := shard.refs[skey]; !ok || cur != ref {
+		return false
+	}
+	if shard.inline == nil {
+		shard.inline = make(map[string][]byte)
+	}
+	shard.inline[skey] = append([]byte(nil), value...)
+	return true
+}
+
+func (s *ShardedHashKeyStore) FetchInline(key []byte) (PageRef, []byte, bool) {
+	shard := s.shard(key)
+	shard.lock.RLock()
+	defer shard.lock.RUnlock()
+
+	ref, ok := shard.refs[string(key)]
+	return ref, shard.inline[string(key)], ok
+}
+
+func (s *ShardedHashKeyStore) Delete(key []byte) (PageRef, bool) {
+	skey := string(key)
+	shard := s.shard(key)
+
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+
+	prev, ok := shard.refs[skey]
+	delete(shard.refs, skey)
+	delete(shard.inline, skey)
 	return prev, ok
 }
+
+func (s *ShardedHashKeyStore) Len() int {
+	n := 0
+	for i := range s.shards {
+		shard := &s.shards[i]
+		shard.lock.RLock()
+		n += len(shard.refs)
+		shard.lock.RUnlock()
+	}
+	return n
+}
+
+func (s *ShardedHashKeyStore) Reset() error {
+	for i := range s.shards {
+		shard := &s.shards[i]
+		shard.lock.Lock()
+		shard.refs, shard.inline = make(map[string]PageRef), nil
+		shard.lock.Unlock()
+	}
+	return nil
+}
+
+func (s *ShardedHashKeyStore) ForEach(each Iterator) {
+	for i := range s.shards {
+		if !s.shards[i].forEach(each) {
+			return
+		}
+	}
+}
+
+func (s *hashShard) forEach(each Iterator) bool {
+	s.lock.RLock()
+	entries := copyEntries(s.refs)
+	s.lock.RUnlock()
+
+	for _, e := range entries {
+		if !each([]byte(e.key), e.ref) {
+			return false
+		}
+	}
+	return true
+}
+
+// Selects the shard for a key, using FNV-1a
+func (s *ShardedHashKeyStore) shard(key []byte) *hashShard {
+	hash := uint32(2166136261)
+	for _, c := range key {
+		hash ^= uint32(c)
+		hash *= 16777619
+	}
+	return &s.shards[hash&s.mask]
+}
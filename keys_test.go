@@ -1,6 +1,10 @@
 package rumcask
 
 import (
+	"fmt"
+	"math/rand"
+	"testing"
+
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
@@ -8,6 +12,10 @@ import (
 var _ = Describe("HashKeyStore", func() {
 	var subject *HashKeyStore
 	var _ KeyStore = subject // interface assertions
+	var _ UnorderedIterator = subject
+	var _ Counter = subject
+	var _ Resetter = subject
+	var _ InlineKeyStore = subject
 
 	BeforeEach(func() {
 		subject = NewHashKeyStore()
@@ -37,4 +45,182 @@ var _ = Describe("HashKeyStore", func() {
 		Expect(ok).To(BeFalse())
 	})
 
+	It("should iterate", func() {
+		subject.Store([]byte("key1"), PageRef{1, 1024})
+		subject.Store([]byte("key2"), PageRef{7, 8096})
+
+		refs := make(map[string]PageRef)
+		subject.ForEach(func(key []byte, ref PageRef) bool {
+			refs[string(key)] = ref
+			return true
+		})
+		Expect(refs).To(Equal(map[string]PageRef{
+			"key1": {1, 1024},
+			"key2": {7, 8096},
+		}))
+	})
+
+	It("should not block writers while iterating", func() {
+		subject.Store([]byte("key1"), PageRef{1, 1024})
+
+		subject.ForEach(func(_ []byte, _ PageRef) bool {
+			done := make(chan struct{})
+			go func() {
+				subject.Store([]byte("key2"), PageRef{7, 8096})
+				close(done)
+			}()
+			Eventually(done).Should(BeClosed())
+			return true
+		})
+		Expect(subject.Len()).To(Equal(2))
+	})
+
+	It("should hold inline values", func() {
+		value := []byte("val1")
+		_, ok := subject.StoreInline([]byte("key1"), PageRef{1, 1024}, value)
+		Expect(ok).To(BeFalse())
+		value[0] = 'X'
+
+		ref, val, ok := subject.FetchInline([]byte("key1"))
+		Expect(ok).To(BeTrue())
+		Expect(ref).To(Equal(PageRef{1, 1024}))
+		Expect(val).To(Equal([]byte("val1")))
+
+		prev, ok := subject.Store([]byte("key1"), PageRef{2, 2048})
+		Expect(ok).To(BeTrue())
+		Expect(prev).To(Equal(PageRef{1, 1024}))
+		_, val, ok = subject.FetchInline([]byte("key1"))
+		Expect(ok).To(BeTrue())
+		Expect(val).To(BeNil())
+
+		subject.StoreInline([]byte("key1"), PageRef{3, 128}, []byte("val3"))
+		subject.Delete([]byte("key1"))
+		_, val, ok = subject.FetchInline([]byte("key1"))
+		Expect(ok).To(BeFalse())
+		Expect(val).To(BeNil())
+	})
+
+	It("should cache inline values of unchanged refs", func() {
+		Expect(subject.CacheInline([]byte("key1"), PageRef{1, 1024}, []byte("val1"))).To(BeFalse())
+
+		subject.Store([]byte("key1"), PageRef{2, 2048})
+		Expect(subject.CacheInline([]byte("key1"), PageRef{1, 1024}, []byte("val1"))).To(BeFalse())
+		Expect(subject.CacheInline([]byte("key1"), PageRef{2, 2048}, []byte("val2"))).To(BeTrue())
+
+		ref, val, ok := subject.FetchInline([]byte("key1"))
+		Expect(ok).To(BeTrue())
+		Expect(ref).To(Equal(PageRef{2, 2048}))
+		Expect(val).To(Equal([]byte("val2")))
+	})
+
+	It("should count and reset", func() {
+		subject.Store([]byte("key1"), PageRef{1, 1024})
+		subject.Store([]byte("key2"), PageRef{7, 8096})
+		Expect(subject.Len()).To(Equal(2))
+
+		Expect(subject.Reset()).NotTo(HaveOccurred())
+		Expect(subject.Len()).To(Equal(0))
+		_, ok := subject.Fetch([]byte("key1"))
+		Expect(ok).To(BeFalse())
+	})
+
+})
+
+var _ = Describe("ShardedHashKeyStore", func() {
+	var subject *ShardedHashKeyStore
+	var _ KeyStore = subject // interface assertions
+	var _ UnorderedIterator = subject
+	var _ Counter = subject
+	var _ Resetter = subject
+	var _ InlineKeyStore = subject
+
+	BeforeEach(func() {
+		subject = NewShardedHashKeyStore(6)
+	})
+
+	It("should init", func() {
+		Expect(subject.shards).To(HaveLen(8))
+		Expect(subject.mask).To(Equal(uint32(7)))
+		Expect(NewShardedHashKeyStore(0).shards).To(HaveLen(1))
+	})
+
+	It("should store/fetch/delete", func() {
+		_, ok := subject.Fetch([]byte("key1"))
+		Expect(ok).To(BeFalse())
+
+		_, ok = subject.Store([]byte("key1"), PageRef{1, 1024})
+		Expect(ok).To(BeFalse())
+		_, ok = subject.Store([]byte("key2"), PageRef{7, 8096})
+		Expect(ok).To(BeFalse())
+
+		ref, ok := subject.Store([]byte("key1"), PageRef{2, 2048})
+		Expect(ok).To(BeTrue())
+		Expect(ref).To(Equal(PageRef{1, 1024}))
+
+		ref, ok = subject.Fetch([]byte("key1"))
+		Expect(ok).To(BeTrue())
+		Expect(ref).To(Equal(PageRef{2, 2048}))
+
+		ref, ok = subject.Delete([]byte("key2"))
+		Expect(ok).To(BeTrue())
+		Expect(ref).To(Equal(PageRef{7, 8096}))
+		_, ok = subject.Delete([]byte("key2"))
+		Expect(ok).To(BeFalse())
+	})
+
+	It("should cache inline values of unchanged refs", func() {
+		subject.Store([]byte("key1"), PageRef{2, 2048})
+		Expect(subject.CacheInline([]byte("key1"), PageRef{1, 1024}, []byte("val1"))).To(BeFalse())
+		Expect(subject.CacheInline([]byte("key1"), PageRef{2, 2048}, []byte("val2"))).To(BeTrue())
+
+		_, val, ok := subject.FetchInline([]byte("key1"))
+		Expect(ok).To(BeTrue())
+		Expect(val).To(Equal([]byte("val2")))
+	})
+
+	It("should spread keys across shards", func() {
+		for i := 0; i < 1000; i++ {
+			subject.Store([]byte(fmt.Sprintf("key%d", i)), PageRef{1, uint32(i)})
+		}
+		for i := range subject.shards {
+			Expect(len(subject.shards[i].refs)).To(BeNumerically(">", 50))
+		}
+
+		count := 0
+		subject.ForEach(func(_ []byte, _ PageRef) bool {
+			count++
+			return true
+		})
+		Expect(count).To(Equal(1000))
+		Expect(subject.Len()).To(Equal(1000))
+
+		Expect(subject.Reset()).NotTo(HaveOccurred())
+		Expect(subject.Len()).To(Equal(0))
+	})
+
 })
+
+func BenchmarkHashKeyStore_ParallelFetch(b *testing.B) {
+	benchKeyStore_parallelFetch(b, NewHashKeyStore())
+}
+func BenchmarkShardedHashKeyStore_ParallelFetch(b *testing.B) {
+	benchKeyStore_parallelFetch(b, NewShardedHashKeyStore(64))
+}
+
+func benchKeyStore_parallelFetch(b *testing.B, store KeyStore) {
+	keys := make([][]byte, 100000)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("KEY%08d", i))
+		store.Store(keys[i], PageRef{1, uint32(i)})
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		rnd := rand.New(rand.NewSource(rand.Int63()))
+		for pb.Next() {
+			if _, ok := store.Fetch(keys[rnd.Intn(len(keys))]); !ok {
+				b.Fatal("key not found")
+			}
+		}
+	})
+}
@@ -0,0 +1,101 @@
+package rumcask
+
+import (
+	"bytes"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+var _META_MAGIC = []byte{'R', 'U', 'M', 'C', 'M', 'T', 'A'}
+
+// Metadata keys
+const (
+	metaComparator = "comparator"
+)
+
+// DB metadata, stored in the META file. It contains:
+//
+// 	MAGIC WORD        7 bytes
+// 	VERSION           1 byte
+// 	ENTRY COUNT       2 bytes
+// 	ENTRIES           variable
+// 	CRC-32            4 bytes
+//
+// Entries are encoded as key length (2 bytes), key,
+// value length (2 bytes) and value.
+type dbMeta map[string]string
+
+// Writes the metadata file, atomically
+func (m dbMeta) write(fname string) error {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	buf := make([]byte, 10, 64)
+	copy(buf, _META_MAGIC)
+	buf[7] = VERSION
+	binLE.PutUint16(buf[8:], uint16(len(names)))
+	for _, name := range names {
+		buf = appendMetaString(buf, name)
+		buf = appendMetaString(buf, m[name])
+	}
+	buf = append(buf, 0, 0, 0, 0)
+	binLE.PutUint32(buf[len(buf)-4:], crc32.ChecksumIEEE(buf[:len(buf)-4]))
+
+	tmp := fname + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, fname)
+}
+
+// Reads a metadata file
+func readMeta(fname string) (dbMeta, error) {
+	buf, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	} else if len(buf) < 14 || !bytes.Equal(buf[:7], _META_MAGIC) || buf[7] != VERSION {
+		return nil, ERROR_META_INVALID
+	}
+
+	end := len(buf) - 4
+	if crc32.ChecksumIEEE(buf[:end]) != binLE.Uint32(buf[end:]) {
+		return nil, ERROR_META_INVALID
+	}
+
+	n := int(binLE.Uint16(buf[8:]))
+	meta := make(dbMeta, n)
+	rest := buf[10:end]
+	for i := 0; i < n; i++ {
+		var name, value string
+		var ok bool
+		if name, rest, ok = readMetaString(rest); !ok {
+			return nil, ERROR_META_INVALID
+		}
+		if value, rest, ok = readMetaString(rest); !ok {
+			return nil, ERROR_META_INVALID
+		}
+		meta[name] = value
+	}
+	return meta, nil
+}
+
+func appendMetaString(buf []byte, s string) []byte {
+	buf = append(buf, byte(len(s)), byte(len(s)>>8))
+	return append(buf, s...)
+}
+
+func readMetaString(buf []byte) (string, []byte, bool) {
+	if len(buf) < 2 {
+		return "", nil, false
+	}
+	n := int(binLE.Uint16(buf))
+	if len(buf) < 2+n {
+		return "", nil, false
+	}
+	return string(buf[2 : 2+n]), buf[2+n:], true
+}
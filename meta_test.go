@@ -0,0 +1,70 @@
+package rumcask
+
+import (
+	"io/ioutil"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// A sorted key store, for testing
+type sortedTestKeyStore struct {
+	*HashKeyStore
+	cmp Comparator
+}
+
+func (s sortedTestKeyStore) Comparator() Comparator { return s.cmp }
+
+var _ = Describe("dbMeta", func() {
+
+	It("should write and read", func() {
+		fname := filepath.Join(testDir, "META")
+		Expect(dbMeta{"comparator": "int64", "x": ""}.write(fname)).NotTo(HaveOccurred())
+
+		meta, err := readMeta(fname)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(meta).To(Equal(dbMeta{"comparator": "int64", "x": ""}))
+
+		data, err := ioutil.ReadFile(fname)
+		Expect(err).NotTo(HaveOccurred())
+		data[12]++
+		Expect(ioutil.WriteFile(fname, data, 0644)).NotTo(HaveOccurred())
+		_, err = readMeta(fname)
+		Expect(err).To(Equal(ERROR_META_INVALID))
+	})
+
+	It("should record comparators", func() {
+		db, err := Open(testDir, sortedTestKeyStore{NewHashKeyStore(), Int64Comparator})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(db.Close()).NotTo(HaveOccurred())
+
+		meta, err := readMeta(filepath.Join(testDir, "META"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(meta).To(Equal(dbMeta{"comparator": "int64"}))
+
+		// unsorted stores are not affected
+		db, err = Open(testDir, NewHashKeyStore())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(db.Close()).NotTo(HaveOccurred())
+
+		db, err = Open(testDir, sortedTestKeyStore{NewHashKeyStore(), Int64Comparator})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(db.Close()).NotTo(HaveOccurred())
+	})
+
+	It("should reject mismatching comparators", func() {
+		db, err := Open(testDir, sortedTestKeyStore{NewHashKeyStore(), BytewiseComparator})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(db.Close()).NotTo(HaveOccurred())
+
+		_, err = Open(testDir, sortedTestKeyStore{NewHashKeyStore(), ReverseComparator(BytewiseComparator)})
+		Expect(err).To(Equal(ERROR_COMPARATOR_MISMATCH))
+
+		// lock must be released
+		db, err = Open(testDir, sortedTestKeyStore{NewHashKeyStore(), BytewiseComparator})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(db.Close()).NotTo(HaveOccurred())
+	})
+
+})
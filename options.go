@@ -0,0 +1,74 @@
+package rumcask
+
+import "time"
+
+// Options can be passed to OpenWithOptions to
+// tune the behaviour of a DB
+type Options struct {
+	// CheckpointInterval enables periodic checkpoints
+	// of the KeyStore. Checkpoints are always written on
+	// Close, if the KeyStore is an UnorderedIterator.
+	// A PersistentKeyStore is committed instead, also
+	// whenever a page is full and after compaction.
+	// Default: 0 (disabled)
+	CheckpointInterval time.Duration
+
+	// NoCheckpoints disables checkpoints entirely.
+	// Default: false
+	NoCheckpoints bool
+
+	// BloomFalsePositiveRate enables per-page bloom filters,
+	// which allow Get to skip lookups of absent keys. Filters
+	// are stored next to each page and are only checked if the
+	// key store is a PersistentKeyStore, in-memory lookups are
+	// cheaper. Ignored if the key store orders keys by a
+	// comparator other than BytewiseComparator.
+	// Default: 0 (disabled)
+	BloomFalsePositiveRate float64
+
+	// InlineValueSize enables inline values. Values of up to
+	// this many bytes are cached in the KeyStore, if it is an
+	// InlineKeyStore, and Get serves them without page reads.
+	// Default: 0 (disabled)
+	InlineValueSize int
+
+	// ReadOnly opens an existing DB without modifying any of
+	// its files. The directory lock is shared, other read-only
+	// processes may open the DB at the same time, but not a
+	// writer. Writes return ERROR_READ_ONLY.
+	// Default: false
+	ReadOnly bool
+
+	// ReplicationHeartbeat is the interval at which a replication
+	// leader reports its position and lag to idle followers.
+	// Default: 1s
+	ReplicationHeartbeat time.Duration
+
+	// ReplicationRetry is the delay before a follower reconnects
+	// to its leader.
+	// Default: 1s
+	ReplicationRetry time.Duration
+}
+
+func (o *Options) norm() *Options {
+	var opts Options
+	if o != nil {
+		opts = *o
+	}
+	if opts.CheckpointInterval < 0 || opts.ReadOnly {
+		opts.CheckpointInterval = 0
+	}
+	if opts.BloomFalsePositiveRate < 0 || opts.BloomFalsePositiveRate >= 1 {
+		opts.BloomFalsePositiveRate = 0
+	}
+	if opts.ReplicationHeartbeat <= 0 {
+		opts.ReplicationHeartbeat = time.Second
+	}
+	if opts.ReplicationRetry <= 0 {
+		opts.ReplicationRetry = time.Second
+	}
+	if opts.InlineValueSize < 0 {
+		opts.InlineValueSize = 0
+	}
+	return &opts
+}
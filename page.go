@@ -62,12 +62,21 @@ func (h *pageHeader) read(r io.ReaderAt) error {
 }
 
 func (h *pageHeader) write(w io.WriterAt) error {
+	_, err := w.WriteAt(h.encode(), 0)
+	return err
+}
+
+func (h *pageHeader) encode() []byte {
+	stats := PageStats{
+		Written: atomic.LoadUint32(&h.Stats.Written),
+		Deleted: atomic.LoadUint32(&h.Stats.Deleted),
+	}
+
 	buf := make([]byte, PAGE_HEADER_LEN)
 	copy(buf[0:], _MAGIC)
 	buf[7] = VERSION
-	copy(buf[8:], (&h.Stats).encode())
-	_, err := w.WriteAt(buf, 0)
-	return err
+	copy(buf[8:], stats.encode())
+	return buf
 }
 
 func (h *pageHeader) writeStats(w io.WriterAt) error {
@@ -91,6 +100,12 @@ type pageIterator struct {
 func newPageIterator(p *Page) *pageIterator {
 	return &pageIterator{page: p, pos: uint32(PAGE_HEADER_LEN)}
 }
+func (i *pageIterator) Seek(pos uint32) {
+	if pos > i.pos {
+		i.pos = pos
+	}
+	i.Next()
+}
 func (i *pageIterator) First()      { i.Next() }
 func (i *pageIterator) Valid() bool { return i.err == nil }
 func (i *pageIterator) Next() {
@@ -117,6 +132,11 @@ type PageRef struct {
 	Offset uint32
 }
 
+// Returns true if r precedes o in the log
+func (r PageRef) before(o PageRef) bool {
+	return r.ID < o.ID || (r.ID == o.ID && r.Offset < o.Offset)
+}
+
 const (
 	OH_KEY  = 2
 	OH_VAL  = 4
@@ -125,6 +145,9 @@ const (
 	OH_FULL = OH_KV + OH_CSUM
 )
 
+// Records with a blank value are tombstones, they are
+// appended to the current page when a key is deleted.
+
 // An individual page-file
 // Pages are not thread-safe. Locks are implemented on DB level
 type Page struct {
@@ -132,11 +155,22 @@ type Page struct {
 	id     uint32
 	offset uint32
 	file   *os.File
+	bloom  *pageBloom // optional
+	refs   int32      // held by the DB and by readers, see acquire
 
 	closer, eoloop chan struct{}
 }
 
 func openPage(fname string) (*Page, error) {
+	return openPageFile(fname, false)
+}
+
+// Opens an existing page without modifying it
+func openReadOnlyPage(fname string) (*Page, error) {
+	return openPageFile(fname, true)
+}
+
+func openPageFile(fname string, readOnly bool) (*Page, error) {
 	base := filepath.Base(fname)
 	bext := filepath.Ext(base)
 	id, err := strconv.ParseUint(base[:len(base)-len(bext)], 10, 32)
@@ -144,7 +178,11 @@ func openPage(fname string) (*Page, error) {
 		return nil, ERROR_PAGE_INVALID
 	}
 
-	file, err := os.OpenFile(fname, os.O_CREATE|os.O_RDWR, 0664)
+	flag := os.O_CREATE | os.O_RDWR
+	if readOnly {
+		flag = os.O_RDONLY
+	}
+	file, err := os.OpenFile(fname, flag, 0664)
 	if err != nil {
 		return nil, err
 	}
@@ -160,10 +198,11 @@ func openPage(fname string) (*Page, error) {
 		header: &pageHeader{Version: VERSION},
 		file:   file,
 		offset: uint32(offset),
+		refs:   1,
 		closer: make(chan struct{}),
 		eoloop: make(chan struct{}),
 	}
-	if page.offset == 0 {
+	if page.offset == 0 && !readOnly {
 		if err := page.header.write(file); err != nil {
 			file.Close()
 			return nil, err
@@ -171,10 +210,17 @@ func openPage(fname string) (*Page, error) {
 		page.offset = PAGE_HEADER_LEN
 	} else if err := page.header.read(file); err != nil {
 		file.Close()
+		if err == io.EOF {
+			err = ERROR_PAGE_BAD_HEADER
+		}
 		return nil, err
 	}
 
-	go page.loop()
+	if readOnly {
+		close(page.eoloop) // stats are never written
+	} else {
+		go page.loop()
+	}
 	return page, nil
 }
 
@@ -204,6 +250,46 @@ func (p *Page) readKey(key []byte, offset uint32) ([]byte, error) {
 
 }
 
+// reads a record from offset, returns the value if the record
+// key equals key under cmp and is not marked as deleted
+func (p *Page) readMatching(key []byte, offset uint32, cmp Comparator) ([]byte, error) {
+	rkey, val, deleted, err := p.read(offset)
+	if err != nil {
+		return nil, err
+	} else if deleted || cmp.Compare(key, rkey) != 0 {
+		return nil, ERROR_NOT_FOUND
+	}
+	return val, nil
+}
+
+// reads the key of a record, without validating the checksum
+func (p *Page) key(offset uint32) ([]byte, error) {
+	blen := make([]byte, OH_KEY)
+	if _, err := p.file.ReadAt(blen, int64(offset)); err != nil {
+		return nil, err
+	}
+
+	klen := int(binLE.Uint16(blen))
+	if klen > MAX_KEY_LEN {
+		return nil, ERROR_BAD_OFFSET
+	}
+
+	key := make([]byte, klen)
+	if _, err := p.file.ReadAt(key, int64(offset)+OH_KV); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// reads the full length of the record at offset
+func (p *Page) recordSize(offset uint32) (int, error) {
+	lens := make([]byte, OH_KV)
+	if _, err := p.file.ReadAt(lens, int64(offset)); err != nil {
+		return 0, err
+	}
+	return recordLen(lens), nil
+}
+
 // reads data from the file
 func (p *Page) read(offset uint32) ([]byte, []byte, bool, error) {
 	lens := make([]byte, OH_KV)
@@ -257,6 +343,20 @@ func (p *Page) write(key, value []byte) (uint32, error) {
 	return offset, nil
 }
 
+// Appends raw record data at offset, which must be the
+// current end of the page
+func (p *Page) writeRaw(offset uint32, data []byte) error {
+	if offset != p.pos() {
+		return ERROR_BAD_OFFSET
+	}
+	n, err := p.file.WriteAt(data, int64(offset))
+	if err != nil {
+		return err
+	}
+	atomic.AddUint32(&p.offset, uint32(n))
+	return nil
+}
+
 // Marks a record as deleted
 func (p *Page) delete(offset uint32) error {
 	if p == nil {
@@ -291,11 +391,15 @@ func (p *Page) deleted() {
 	}
 }
 
-// Parse page, merge keys
-func (p *Page) parse(store KeyStore) error {
+// Parse page from the given offset, merge keys
+func (p *Page) parse(store KeyStore, from uint32) error {
 	iter := newPageIterator(p)
-	for iter.First(); iter.Valid(); iter.Next() {
-		store.Store(iter.key, PageRef{p.id, iter.offset})
+	for iter.Seek(from); iter.Valid(); iter.Next() {
+		if len(iter.value) == 0 {
+			store.Delete(iter.key)
+		} else {
+			store.Store(iter.key, PageRef{p.id, iter.offset})
+		}
 	}
 	return iter.Error()
 }
@@ -311,11 +415,26 @@ func (p *Page) pos() uint32 {
 	return atomic.LoadUint32(&p.offset)
 }
 
-// Unlinks the page completely
+// Keeps the file open until release is called
+func (p *Page) acquire() {
+	atomic.AddInt32(&p.refs, 1)
+}
+
+// Releases a reference, closes the file after the last one
+func (p *Page) release() error {
+	if atomic.AddInt32(&p.refs, -1) == 0 {
+		return p.close()
+	}
+	return nil
+}
+
+// Unlinks the page and releases the reference of the DB.
+// Readers can finish reading from the unlinked file.
 func (p *Page) unlink() error {
-	fname := p.file.Name()
-	p.close()
-	return os.Remove(fname)
+	if err := os.Remove(p.file.Name()); err != nil {
+		return err
+	}
+	return p.release()
 }
 
 // Closes the file
@@ -210,6 +210,26 @@ var _ = Describe("Page", func() {
 		Expect(err).To(Equal(ERROR_BAD_OFFSET))
 	})
 
+	It("should read matching records", func() {
+		off1, err := subject.write([]byte("key1"), []byte("data"))
+		Expect(err).NotTo(HaveOccurred())
+
+		key, err := subject.key(off1)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(key).To(Equal([]byte("key1")))
+
+		val, err := subject.readMatching([]byte("key1"), off1, BytewiseComparator)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(val).To(Equal([]byte("data")))
+
+		_, err = subject.readMatching([]byte("key2"), off1, BytewiseComparator)
+		Expect(err).To(Equal(ERROR_NOT_FOUND))
+
+		Expect(subject.delete(off1)).NotTo(HaveOccurred())
+		_, err = subject.readMatching([]byte("key1"), off1, BytewiseComparator)
+		Expect(err).To(Equal(ERROR_NOT_FOUND))
+	})
+
 	It("should mark records as deleted", func() {
 		off1, err := subject.write([]byte("key1"), []byte("data"))
 		Expect(err).NotTo(HaveOccurred())
@@ -266,12 +286,36 @@ var _ = Describe("Page", func() {
 		Expect(err).NotTo(HaveOccurred())
 
 		kstore := NewHashKeyStore()
-		Expect(subject.parse(kstore)).NotTo(HaveOccurred())
+		Expect(subject.parse(kstore, 0)).NotTo(HaveOccurred())
 		Expect(kstore.refs).To(Equal(map[string]PageRef{
 			"key1": {23, 128},
 			"key2": {23, 144},
 			"key4": {23, 181},
 		}))
+
+		_, err = subject.write([]byte("key2"), nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(subject.parse(kstore, 0)).NotTo(HaveOccurred())
+		Expect(kstore.refs).To(Equal(map[string]PageRef{
+			"key1": {23, 128},
+			"key4": {23, 181},
+		}))
+	})
+
+	It("should parse pages from offsets", func() {
+		_, err := subject.write([]byte("key1"), []byte("data"))
+		Expect(err).NotTo(HaveOccurred())
+		off2, err := subject.write([]byte("key2"), []byte("more data"))
+		Expect(err).NotTo(HaveOccurred())
+		_, err = subject.write([]byte("key1"), nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		kstore := NewHashKeyStore()
+		kstore.Store([]byte("key1"), PageRef{23, 128})
+		Expect(subject.parse(kstore, off2)).NotTo(HaveOccurred())
+		Expect(kstore.refs).To(Equal(map[string]PageRef{
+			"key2": {23, 144},
+		}))
 	})
 
 	It("should allow to increment deletion stats", func() {
@@ -0,0 +1,286 @@
+package radix
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/bsm/rumcask"
+)
+
+// Inner node kinds, by capacity
+const (
+	kindNode4 uint8 = iota
+	kindNode16
+	kindNode48
+	kindNode256
+)
+
+// A tree node. Each node holds the compressed path below its
+// parent edge and optionally a ref, if a key ends at this node.
+type node struct {
+	prefix []byte
+	ref    rumcask.PageRef
+	isLeaf bool
+	inner  *inner // nil, if node has no children
+}
+
+// Children of a node, the layout depends on the kind:
+//
+//	node4, node16: sorted edge labels in keys, children in same order
+//	node48:        children in 48 slots, index maps labels to slot+1
+//	node256:       children indexed by label
+type inner struct {
+	kind     uint8
+	size     int
+	keys     []byte
+	children []*node
+	index    *[256]uint8
+}
+
+func newInner() *inner {
+	return &inner{
+		kind:     kindNode4,
+		keys:     make([]byte, 0, 4),
+		children: make([]*node, 0, 4),
+	}
+}
+
+// Returns the child slot for label c, or nil
+func (in *inner) slot(c byte) **node {
+	switch in.kind {
+	case kindNode4:
+		for i, k := range in.keys {
+			if k == c {
+				return &in.children[i]
+			}
+		}
+	case kindNode16:
+		i := sort.Search(len(in.keys), func(i int) bool { return in.keys[i] >= c })
+		if i < len(in.keys) && in.keys[i] == c {
+			return &in.children[i]
+		}
+	case kindNode48:
+		if s := in.index[c]; s != 0 {
+			return &in.children[s-1]
+		}
+	case kindNode256:
+		if in.children[c] != nil {
+			return &in.children[c]
+		}
+	}
+	return nil
+}
+
+// Adds a child for label c, which must not exist
+func (in *inner) add(c byte, child *node) {
+	switch in.kind {
+	case kindNode4, kindNode16:
+		if len(in.keys) == cap(in.keys) {
+			if in.kind == kindNode4 {
+				in.resize(kindNode16)
+			} else {
+				in.resize(kindNode48)
+			}
+			in.add(c, child)
+			return
+		}
+
+		i := sort.Search(len(in.keys), func(i int) bool { return in.keys[i] >= c })
+		in.keys = append(in.keys, 0)
+		in.children = append(in.children, nil)
+		copy(in.keys[i+1:], in.keys[i:])
+		copy(in.children[i+1:], in.children[i:])
+		in.keys[i], in.children[i] = c, child
+	case kindNode48:
+		if in.size == 48 {
+			in.resize(kindNode256)
+			in.add(c, child)
+			return
+		}
+		for s := range in.children {
+			if in.children[s] == nil {
+				in.children[s] = child
+				in.index[c] = uint8(s + 1)
+				break
+			}
+		}
+	case kindNode256:
+		in.children[c] = child
+	}
+	in.size++
+}
+
+// Removes the child for label c
+func (in *inner) remove(c byte) {
+	switch in.kind {
+	case kindNode4, kindNode16:
+		i := bytes.IndexByte(in.keys, c)
+		if i < 0 {
+			return
+		}
+		copy(in.keys[i:], in.keys[i+1:])
+		copy(in.children[i:], in.children[i+1:])
+		in.children[len(in.children)-1] = nil
+		in.keys = in.keys[:len(in.keys)-1]
+		in.children = in.children[:len(in.children)-1]
+	case kindNode48:
+		s := in.index[c]
+		if s == 0 {
+			return
+		}
+		in.children[s-1] = nil
+		in.index[c] = 0
+	case kindNode256:
+		if in.children[c] == nil {
+			return
+		}
+		in.children[c] = nil
+	}
+	in.size--
+
+	// Shrink with some hysteresis
+	switch {
+	case in.kind == kindNode256 && in.size <= 36:
+		in.resize(kindNode48)
+	case in.kind == kindNode48 && in.size <= 12:
+		in.resize(kindNode16)
+	case in.kind == kindNode16 && in.size <= 3:
+		in.resize(kindNode4)
+	}
+}
+
+// Converts the layout to the given kind
+func (in *inner) resize(kind uint8) {
+	keys, children := make([]byte, 0, in.size), make([]*node, 0, in.size)
+	in.each(func(c byte, child *node) bool {
+		keys, children = append(keys, c), append(children, child)
+		return true
+	})
+
+	in.kind, in.size = kind, 0
+	in.keys, in.children, in.index = nil, nil, nil
+	switch kind {
+	case kindNode4, kindNode16:
+		capacity := 4
+		if kind == kindNode16 {
+			capacity = 16
+		}
+		in.keys = append(make([]byte, 0, capacity), keys...)
+		in.children = append(make([]*node, 0, capacity), children...)
+		in.size = len(keys)
+		return
+	case kindNode48:
+		in.children = make([]*node, 48)
+		in.index = new([256]uint8)
+	case kindNode256:
+		in.children = make([]*node, 256)
+	}
+	for i, c := range keys {
+		in.add(c, children[i])
+	}
+}
+
+// Visits children in label order
+func (in *inner) each(fn func(c byte, child *node) bool) bool {
+	switch in.kind {
+	case kindNode4, kindNode16:
+		for i, c := range in.keys {
+			if !fn(c, in.children[i]) {
+				return false
+			}
+		}
+	case kindNode48:
+		for c, s := range in.index {
+			if s != 0 && !fn(byte(c), in.children[s-1]) {
+				return false
+			}
+		}
+	case kindNode256:
+		for c, child := range in.children {
+			if child != nil && !fn(byte(c), child) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Visits children in reverse label order
+func (in *inner) reverseEach(fn func(c byte, child *node) bool) bool {
+	switch in.kind {
+	case kindNode4, kindNode16:
+		for i := len(in.keys) - 1; i >= 0; i-- {
+			if !fn(in.keys[i], in.children[i]) {
+				return false
+			}
+		}
+	case kindNode48:
+		for c := len(in.index) - 1; c >= 0; c-- {
+			if s := in.index[c]; s != 0 && !fn(byte(c), in.children[s-1]) {
+				return false
+			}
+		}
+	case kindNode256:
+		for c := len(in.children) - 1; c >= 0; c-- {
+			if child := in.children[c]; child != nil && !fn(byte(c), child) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Returns the number of children of n
+func (n *node) numChildren() int {
+	if n.inner == nil {
+		return 0
+	}
+	return n.inner.size
+}
+
+// Returns the child slot for label c, or nil
+func (n *node) slot(c byte) **node {
+	if n.inner == nil {
+		return nil
+	}
+	return n.inner.slot(c)
+}
+
+// Adds a child for label c
+func (n *node) addChild(c byte, child *node) {
+	if n.inner == nil {
+		n.inner = newInner()
+	}
+	n.inner.add(c, child)
+}
+
+// Removes the child for label c
+func (n *node) removeChild(c byte) {
+	if n.inner == nil {
+		return
+	}
+	if n.inner.remove(c); n.inner.size == 0 {
+		n.inner = nil
+	}
+}
+
+// Returns the length of the common prefix of a and b
+func commonPrefix(a, b []byte) int {
+	n := len(a)
+	if len(b) < n {
+		n = len(b)
+	}
+	for i := 0; i < n; i++ {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return n
+}
+
+func clone(b []byte) []byte {
+	if len(b) == 0 {
+		return nil
+	}
+	return append(make([]byte, 0, len(b)), b...)
+}
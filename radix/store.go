@@ -0,0 +1,362 @@
+package radix
+
+import (
+	"bytes"
+	"sync"
+	"unsafe"
+
+	"github.com/bsm/rumcask"
+)
+
+// Iterator allows callers to iterate the key/ref pairs
+// in lexical order. When this function returns false,
+// iteration will stop immediately.
+//
+// Keys are reconstructed from the tree during iteration
+// and are only valid until the iterator returns.
+type Iterator = rumcask.Iterator
+
+// An adaptive radix tree based KeyStore implementation.
+// Keys which share a prefix, such as hierarchical keys,
+// store the common part only once. Keys are iterable
+// in lexical order and are held in memory.
+type KeyStore struct {
+	root *node
+	size int
+	lock sync.RWMutex
+}
+
+// NewKeyStore creates a new, empty radix tree key store
+func NewKeyStore() *KeyStore {
+	return &KeyStore{root: new(node)}
+}
+
+// Comparator implements rumcask.SortedKeyStore
+func (s *KeyStore) Comparator() rumcask.Comparator {
+	return rumcask.BytewiseComparator
+}
+
+// Fetch retrieves the ref at key
+func (s *KeyStore) Fetch(key []byte) (_ rumcask.PageRef, _ bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	n, depth := s.root, 0
+	for depth < len(key) {
+		slot := n.slot(key[depth])
+		if slot == nil {
+			return
+		}
+
+		child := *slot
+		if !bytes.HasPrefix(key[depth+1:], child.prefix) {
+			return
+		}
+		n, depth = child, depth+1+len(child.prefix)
+	}
+	return n.ref, n.isLeaf
+}
+
+// Store stores a key/ref pair
+func (s *KeyStore) Store(key []byte, ref rumcask.PageRef) (rumcask.PageRef, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	n, depth := s.root, 0
+	for depth < len(key) {
+		c := key[depth]
+		slot := n.slot(c)
+		if slot == nil {
+			n.addChild(c, &node{prefix: clone(key[depth+1:]), ref: ref, isLeaf: true})
+			s.size++
+			return rumcask.PageRef{}, false
+		}
+
+		child := *slot
+		p := commonPrefix(child.prefix, key[depth+1:])
+		if p < len(child.prefix) {
+			// Split the compressed path at the first mismatch
+			split := &node{prefix: child.prefix[:p:p]}
+			split.addChild(child.prefix[p], child)
+			child.prefix = child.prefix[p+1:]
+			*slot = split
+			child = split
+		}
+		n, depth = child, depth+1+p
+	}
+
+	prev, ok := n.ref, n.isLeaf
+	n.ref, n.isLeaf = ref, true
+	if !ok {
+		s.size++
+	}
+	return prev, ok
+}
+
+// Delete deletes a key
+func (s *KeyStore) Delete(key []byte) (rumcask.PageRef, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	ref, ok := s.remove(s.root, key)
+	if ok {
+		s.size--
+	}
+	return ref, ok
+}
+
+// Removes key below n, compacts the path on the way back
+func (s *KeyStore) remove(n *node, key []byte) (_ rumcask.PageRef, _ bool) {
+	if len(key) == 0 {
+		if !n.isLeaf {
+			return
+		}
+		ref := n.ref
+		n.ref, n.isLeaf = rumcask.PageRef{}, false
+		return ref, true
+	}
+
+	slot := n.slot(key[0])
+	if slot == nil {
+		return
+	}
+
+	child := *slot
+	rest := key[1:]
+	if !bytes.HasPrefix(rest, child.prefix) {
+		return
+	}
+
+	ref, ok := s.remove(child, rest[len(child.prefix):])
+	if !ok || child.isLeaf {
+		return ref, ok
+	}
+
+	switch child.numChildren() {
+	case 0:
+		n.removeChild(key[0])
+	case 1:
+		*slot = child.merge()
+	}
+	return ref, ok
+}
+
+// Reset implements rumcask.Resetter
+func (s *KeyStore) Reset() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.root, s.size = new(node), 0
+	return nil
+}
+
+// Merges a value-less node with its only child
+func (n *node) merge() *node {
+	var edge byte
+	var only *node
+	n.inner.each(func(c byte, child *node) bool {
+		edge, only = c, child
+		return false
+	})
+
+	prefix := make([]byte, 0, len(n.prefix)+1+len(only.prefix))
+	prefix = append(prefix, n.prefix...)
+	prefix = append(prefix, edge)
+	only.prefix = append(prefix, only.prefix...)
+	return only
+}
+
+// Len returns the number of keys in the store
+func (s *KeyStore) Len() int {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.size
+}
+
+// ForEach iterates over all keys, in lexical order.
+// The store must not be modified by the iterator.
+func (s *KeyStore) ForEach(each Iterator) {
+	s.Iterate(nil, nil, each)
+}
+
+// Iterate iterates over a range of keys >= min and < max.
+// A nil min or max leaves the range unbounded.
+// The store must not be modified by the iterator.
+func (s *KeyStore) Iterate(min, max []byte, each Iterator) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	w := &walker{min: min, max: max, each: each}
+	w.walk(s.root, make([]byte, 0, 64), min != nil, max != nil)
+}
+
+// ReverseIterate iterates over a range of keys >= min and < max
+// in reverse order. A nil min or max leaves the range unbounded.
+// The store must not be modified by the iterator.
+func (s *KeyStore) ReverseIterate(min, max []byte, each Iterator) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	w := &walker{min: min, max: max, each: each, reverse: true}
+	w.walk(s.root, make([]byte, 0, 64), min != nil, max != nil)
+}
+
+// IteratePrefix iterates over all keys starting with prefix,
+// in lexical order. The store must not be modified by the iterator.
+func (s *KeyStore) IteratePrefix(prefix []byte, each Iterator) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	n, depth := s.root, 0
+	buf := make([]byte, 0, 64)
+	for depth < len(prefix) {
+		c := prefix[depth]
+		slot := n.slot(c)
+		if slot == nil {
+			return
+		}
+
+		child := *slot
+		rest := prefix[depth+1:]
+		if p := commonPrefix(child.prefix, rest); p < len(child.prefix) && p < len(rest) {
+			return
+		}
+		// walk appends the prefix of the last node
+		buf = append(append(buf, n.prefix...), c)
+		n, depth = child, depth+1+len(child.prefix)
+	}
+
+	w := &walker{each: each}
+	w.walk(n, buf, false, false)
+}
+
+// Stats returns memory statistics, it visits all nodes
+func (s *KeyStore) Stats() *Stats {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	stats := &Stats{Keys: s.size}
+	stats.collect(s.root, 0)
+	return stats
+}
+
+// Stats contains memory statistics
+type Stats struct {
+	// Number of keys
+	Keys int
+	// Number of tree nodes
+	Nodes int
+	// Number of inner nodes by kind
+	Node4, Node16, Node48, Node256 int
+	// Number of bytes stored in compressed paths
+	PrefixBytes int
+	// Number of bytes the stored keys would occupy
+	// without prefix sharing
+	KeyBytes int
+	// Estimated number of bytes allocated by the tree
+	MemoryBytes int
+}
+
+func (s *Stats) collect(n *node, depth int) {
+	depth += len(n.prefix)
+
+	s.Nodes++
+	s.PrefixBytes += len(n.prefix)
+	s.MemoryBytes += int(unsafe.Sizeof(*n)) + cap(n.prefix)
+	if n.isLeaf {
+		s.KeyBytes += depth
+	}
+
+	in := n.inner
+	if in == nil {
+		return
+	}
+
+	s.MemoryBytes += int(unsafe.Sizeof(*in)) + cap(in.keys) + cap(in.children)*int(unsafe.Sizeof(n))
+	switch in.kind {
+	case kindNode4:
+		s.Node4++
+	case kindNode16:
+		s.Node16++
+	case kindNode48:
+		s.Node48++
+		s.MemoryBytes += len(in.index)
+	case kindNode256:
+		s.Node256++
+	}
+	in.each(func(_ byte, child *node) bool {
+		s.collect(child, depth+1)
+		return true
+	})
+}
+
+// --------------------------------------------------------------------
+
+// Walks a (sub-)tree within [min, max)
+type walker struct {
+	min, max []byte
+	each     Iterator
+	reverse  bool
+}
+
+// Visits n, buf holds the full key path to n, excluding n.prefix.
+// The checkMin/checkMax flags are cleared once all keys of a subtree
+// are known to be within bounds. Returns false to stop.
+func (w *walker) walk(n *node, buf []byte, checkMin, checkMax bool) bool {
+	buf = append(buf, n.prefix...)
+
+	if checkMin {
+		switch comparePrefix(buf, w.min) {
+		case -1:
+			return !w.reverse // all keys below min
+		case 1:
+			checkMin = false
+		}
+	}
+	if checkMax {
+		switch comparePrefix(buf, w.max) {
+		case 1:
+			return w.reverse // all keys at or above max
+		case -1:
+			checkMax = false
+		}
+	}
+
+	yield := n.isLeaf &&
+		(!checkMin || bytes.Compare(buf, w.min) >= 0) &&
+		(!checkMax || bytes.Compare(buf, w.max) < 0)
+
+	if !w.reverse && yield && !w.each(buf, n.ref) {
+		return false
+	}
+
+	if in := n.inner; in != nil {
+		visit := func(c byte, child *node) bool {
+			return w.walk(child, append(buf, c), checkMin, checkMax)
+		}
+		if w.reverse && !in.reverseEach(visit) {
+			return false
+		} else if !w.reverse && !in.each(visit) {
+			return false
+		}
+	}
+
+	if w.reverse && yield && !w.each(buf, n.ref) {
+		return false
+	}
+	return true
+}
+
+// Compares all keys starting with prefix to bound. Returns -1
+// if they are all less than bound, 1 if they are all greater
+// than or equal to bound and 0 otherwise.
+func comparePrefix(prefix, bound []byte) int {
+	if len(prefix) < len(bound) {
+		return bytes.Compare(prefix, bound[:len(prefix)])
+	}
+	if bytes.Compare(prefix[:len(bound)], bound) < 0 {
+		return -1
+	}
+	return 1
+}
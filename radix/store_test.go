@@ -0,0 +1,249 @@
+package radix
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/bsm/rumcask"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("KeyStore", func() {
+	var subject *KeyStore
+	var _ rumcask.KeyStore = subject // interface assertions
+	var _ rumcask.UnorderedIterator = subject
+	var _ rumcask.SortedKeyStore = subject
+	var _ rumcask.OrderedIterator = subject
+	var _ rumcask.Counter = subject
+	var _ rumcask.Resetter = subject
+
+	var collect = func(iter func(Iterator)) []string {
+		var keys []string
+		iter(func(key []byte, _ rumcask.PageRef) bool {
+			keys = append(keys, string(key))
+			return true
+		})
+		return keys
+	}
+
+	BeforeEach(func() {
+		subject = NewKeyStore()
+		for i, key := range []string{
+			"tenant/1/user/1", "tenant/1/user/2", "tenant/1/user/10",
+			"tenant/2/user/1", "tenant/1", "tenant/10", "other",
+		} {
+			subject.Store([]byte(key), rumcask.PageRef{ID: uint32(i)})
+		}
+	})
+
+	It("should store/fetch/delete", func() {
+		ref, ok := subject.Fetch([]byte("tenant/1/user/10"))
+		Expect(ok).To(BeTrue())
+		Expect(ref).To(Equal(rumcask.PageRef{ID: 2}))
+		_, ok = subject.Fetch([]byte("tenant/1/user"))
+		Expect(ok).To(BeFalse())
+		_, ok = subject.Fetch([]byte("tenant/1/user/100"))
+		Expect(ok).To(BeFalse())
+
+		ref, ok = subject.Store([]byte("tenant/1"), rumcask.PageRef{ID: 9})
+		Expect(ok).To(BeTrue())
+		Expect(ref).To(Equal(rumcask.PageRef{ID: 4}))
+
+		ref, ok = subject.Delete([]byte("tenant/1/user/1"))
+		Expect(ok).To(BeTrue())
+		Expect(ref).To(Equal(rumcask.PageRef{ID: 0}))
+		_, ok = subject.Delete([]byte("tenant/1/user/1"))
+		Expect(ok).To(BeFalse())
+		_, ok = subject.Delete([]byte("tenant/1/user"))
+		Expect(ok).To(BeFalse())
+
+		ref, ok = subject.Fetch([]byte("tenant/1/user/10"))
+		Expect(ok).To(BeTrue())
+		Expect(ref).To(Equal(rumcask.PageRef{ID: 2}))
+		Expect(subject.Len()).To(Equal(6))
+	})
+
+	It("should iterate in order", func() {
+		Expect(collect(subject.ForEach)).To(Equal([]string{
+			"other", "tenant/1", "tenant/1/user/1", "tenant/1/user/10",
+			"tenant/1/user/2", "tenant/10", "tenant/2/user/1",
+		}))
+	})
+
+	It("should iterate ranges", func() {
+		Expect(collect(func(fn Iterator) {
+			subject.Iterate([]byte("tenant/1/"), []byte("tenant/2"), fn)
+		})).To(Equal([]string{
+			"tenant/1/user/1", "tenant/1/user/10", "tenant/1/user/2", "tenant/10",
+		}))
+		Expect(collect(func(fn Iterator) {
+			subject.Iterate(nil, []byte("tenant/1/user/1"), fn)
+		})).To(Equal([]string{"other", "tenant/1"}))
+		Expect(collect(func(fn Iterator) {
+			subject.ReverseIterate([]byte("tenant/1"), []byte("tenant/10"), fn)
+		})).To(Equal([]string{
+			"tenant/1/user/2", "tenant/1/user/10", "tenant/1/user/1", "tenant/1",
+		}))
+	})
+
+	It("should iterate prefixes", func() {
+		Expect(collect(func(fn Iterator) {
+			subject.IteratePrefix([]byte("tenant/1/user/1"), fn)
+		})).To(Equal([]string{"tenant/1/user/1", "tenant/1/user/10"}))
+		Expect(collect(func(fn Iterator) {
+			subject.IteratePrefix([]byte("tenant/1"), fn)
+		})).To(Equal([]string{
+			"tenant/1", "tenant/1/user/1", "tenant/1/user/10", "tenant/1/user/2", "tenant/10",
+		}))
+		Expect(collect(func(fn Iterator) {
+			subject.IteratePrefix([]byte("tenant/3"), fn)
+		})).To(BeEmpty())
+		Expect(collect(func(fn Iterator) {
+			subject.IteratePrefix(nil, fn)
+		})).To(HaveLen(7))
+	})
+
+	It("should iterate prefixes across compressed nodes", func() {
+		subject = NewKeyStore()
+		for _, key := range []string{"abcdef", "abcxyz", "q"} {
+			subject.Store([]byte(key), rumcask.PageRef{})
+		}
+
+		for prefix, expected := range map[string][]string{
+			"a":       {"abcdef", "abcxyz"},
+			"abc":     {"abcdef", "abcxyz"},
+			"abcd":    {"abcdef"},
+			"abcx":    {"abcxyz"},
+			"abcdef":  {"abcdef"},
+			"abcdefg": nil,
+			"q":       {"q"},
+		} {
+			keys := collect(func(fn Iterator) {
+				subject.IteratePrefix([]byte(prefix), fn)
+			})
+			Expect(keys).To(Equal(expected), "prefix %q", prefix)
+			for _, key := range keys {
+				_, ok := subject.Fetch([]byte(key))
+				Expect(ok).To(BeTrue(), "key %q", key)
+			}
+		}
+	})
+
+	It("should stop iterating", func() {
+		n := 0
+		subject.ReverseIterate(nil, nil, func(_ []byte, _ rumcask.PageRef) bool {
+			n++
+			return n < 3
+		})
+		Expect(n).To(Equal(3))
+	})
+
+	It("should share prefixes", func() {
+		subject = NewKeyStore()
+		for i := 0; i < 1000; i++ {
+			subject.Store([]byte(fmt.Sprintf("tenant/%d/user/%d/profile", i%10, i)), rumcask.PageRef{})
+		}
+
+		stats := subject.Stats()
+		Expect(stats.Keys).To(Equal(1000))
+		Expect(stats.Node4 + stats.Node16 + stats.Node48 + stats.Node256).To(BeNumerically(">", 10))
+		Expect(stats.PrefixBytes).To(BeNumerically("<", stats.KeyBytes/2))
+	})
+
+	It("should grow and shrink nodes", func() {
+		subject = NewKeyStore()
+		for i := 0; i < 256; i++ {
+			subject.Store([]byte{'k', byte(i)}, rumcask.PageRef{ID: uint32(i)})
+		}
+		Expect(subject.Stats().Node256).To(Equal(1))
+		Expect(subject.root.inner.slot('k')).NotTo(BeNil())
+
+		for i := 255; i >= 3; i-- {
+			_, ok := subject.Delete([]byte{'k', byte(i)})
+			Expect(ok).To(BeTrue())
+		}
+		stats := subject.Stats()
+		Expect(stats.Node256 + stats.Node48 + stats.Node16).To(Equal(0))
+		Expect(stats.Node4).To(Equal(2))
+		Expect(collect(subject.ForEach)).To(Equal([]string{"k\x00", "k\x01", "k\x02"}))
+	})
+
+	It("should match a model", func() {
+		subject = NewKeyStore()
+		model := make(map[string]rumcask.PageRef)
+		keyAt := func() []byte {
+			return []byte(fmt.Sprintf("t/%d/u/%d", rand.Intn(20), rand.Intn(300)))
+		}
+
+		for i := 0; i < 20000; i++ {
+			key := keyAt()
+			if rand.Intn(3) == 0 {
+				prev, ok := subject.Delete(key)
+				exp, exists := model[string(key)]
+				Expect(ok).To(Equal(exists))
+				Expect(prev).To(Equal(exp))
+				delete(model, string(key))
+			} else {
+				ref := rumcask.PageRef{ID: uint32(i)}
+				prev, ok := subject.Store(key, ref)
+				exp, exists := model[string(key)]
+				Expect(ok).To(Equal(exists))
+				Expect(prev).To(Equal(exp))
+				model[string(key)] = ref
+			}
+		}
+		Expect(subject.Len()).To(Equal(len(model)))
+
+		keys := make([]string, 0, len(model))
+		for key := range model {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		Expect(collect(subject.ForEach)).To(Equal(keys))
+
+		min, max := string(keyAt()), string(keyAt())
+		var exp []string
+		for _, key := range keys {
+			if key >= min && key < max {
+				exp = append(exp, key)
+			}
+		}
+		Expect(collect(func(fn Iterator) {
+			subject.Iterate([]byte(min), []byte(max), fn)
+		})).To(Equal(exp))
+
+		for _, key := range keys {
+			ref, ok := subject.Fetch([]byte(key))
+			Expect(ok).To(BeTrue())
+			Expect(ref).To(Equal(model[key]))
+		}
+		for _, key := range keys {
+			subject.Delete([]byte(key))
+		}
+		Expect(subject.Stats()).To(Equal(&Stats{Nodes: 1, MemoryBytes: subject.Stats().MemoryBytes}))
+	})
+
+})
+
+func BenchmarkKeyStore_Store(b *testing.B) {
+	keys := make([][]byte, b.N)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("tenant/%d/user/%08d", i%100, i))
+	}
+
+	store := NewKeyStore()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		store.Store(keys[i], rumcask.PageRef{ID: 1, Offset: uint32(i)})
+	}
+}
+
+/** Test hook **/
+
+func TestSuite(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "rumcask/radix")
+}
@@ -0,0 +1,190 @@
+package rumcask
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Name of the directory which receives damaged pages
+const lostFoundName = "lost+found"
+
+// Name of the file which makes Open rebuild the key store,
+// as repaired pages invalidate stored refs
+const rebuildKeysName = "REBUILD_KEYS"
+
+// RepairReport describes the pages rewritten by Repair
+type RepairReport struct {
+	// Time of the repair
+	Created time.Time `json:"created"`
+	// Directory, which holds the original pages and a copy
+	// of this report, blank if no pages were damaged
+	LostFound string `json:"lost_found,omitempty"`
+	// Repaired pages
+	Pages []RepairedPage `json:"pages"`
+}
+
+// RepairedPage describes a single repaired page
+type RepairedPage struct {
+	ID uint32 `json:"id"`
+	// HeaderErr describes the invalid header of the original
+	HeaderErr string `json:"header_error,omitempty"`
+	// Number of rewritten records
+	Salvaged int `json:"salvaged"`
+	// Offsets of dropped, invalid records in the original
+	Dropped []uint32 `json:"dropped,omitempty"`
+	// Number of dropped bytes
+	Skipped int64 `json:"skipped"`
+}
+
+// Repair salvages the readable records of damaged pages in dir. Corrupt
+// regions are skipped by searching for the next record which validates.
+// Each damaged page is replaced by a new page with the same ID, which
+// contains the salvaged records in their original order. The originals
+// are moved to a new directory in lost+found, together with a report.
+//
+// The DB must be closed. The checkpoint is discarded and a marker
+// is left, which makes the next Open reset persistent key stores
+// and rebuild the keys from all pages.
+func Repair(dir string) (*RepairReport, error) {
+	flock, err := newFileLock(filepath.Join(dir, "LOCK"), false)
+	if err != nil {
+		return nil, err
+	}
+	defer flock.release()
+
+	names, err := filepath.Glob(filepath.Join(dir, "*.rcp"))
+	if err != nil {
+		return nil, err
+	}
+
+	report := &RepairReport{Created: time.Now().UTC()}
+	for _, name := range names {
+		damaged, err := isDamaged(name)
+		if err != nil {
+			return nil, err
+		} else if !damaged {
+			continue
+		}
+
+		if report.LostFound == "" {
+			// Left before pages are replaced, in case repair is interrupted
+			if err := ioutil.WriteFile(filepath.Join(dir, rebuildKeysName), nil, 0644); err != nil {
+				return nil, err
+			}
+			if report.LostFound, err = makeLostFound(dir, report.Created); err != nil {
+				return nil, err
+			}
+		}
+
+		rp, err := repairPage(name, report.LostFound)
+		if err != nil {
+			return nil, err
+		}
+		report.Pages = append(report.Pages, *rp)
+	}
+
+	if report.LostFound == "" {
+		return report, nil
+	} else if err := os.Remove(filepath.Join(report.LostFound, "salvaged")); err != nil {
+		return nil, err
+	}
+
+	// Checkpoints may refer to dropped records
+	if err := os.Rename(filepath.Join(dir, "CHECKPOINT"), filepath.Join(report.LostFound, "CHECKPOINT")); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(filepath.Join(report.LostFound, "REPORT"), data, 0644); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// Returns true if a page has an invalid header or records
+func isDamaged(fname string) (bool, error) {
+	damaged := false
+	d, err := DumpPage(fname, func(rec *DumpRecord) bool {
+		damaged = !rec.Valid
+		return !damaged
+	})
+	if err != nil {
+		return false, err
+	}
+	return damaged || d.HeaderErr != nil, nil
+}
+
+// Creates a new lost+found subdirectory
+func makeLostFound(dir string, t time.Time) (string, error) {
+	parent := filepath.Join(dir, lostFoundName)
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return "", err
+	}
+	return ioutil.TempDir(parent, t.Format("20060102-150405-"))
+}
+
+// Rewrites the valid records of a damaged page, moves
+// the original to the lost directory
+func repairPage(fname, lost string) (*RepairedPage, error) {
+	base := filepath.Base(fname)
+	tmp := filepath.Join(lost, "salvaged", base)
+	if err := os.MkdirAll(filepath.Dir(tmp), 0755); err != nil {
+		return nil, err
+	}
+
+	page, err := openPage(tmp)
+	if err != nil {
+		return nil, err
+	}
+	rp := &RepairedPage{ID: page.id}
+
+	var werr error
+	d, err := DumpPage(fname, func(rec *DumpRecord) bool {
+		switch {
+		case !rec.Valid:
+			rp.Dropped = append(rp.Dropped, rec.Offset)
+		case !rec.Deleted: // marked records are skipped on load
+			if _, werr = page.write(rec.Key, rec.Value); werr != nil {
+				return false
+			}
+			rp.Salvaged++
+		}
+		return true
+	})
+	if err == nil {
+		err = werr
+	}
+	if err == nil {
+		err = page.file.Sync()
+	}
+	if e := page.close(); e != nil && err == nil {
+		err = e
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rp.Skipped = d.Skipped
+	if d.HeaderErr != nil {
+		rp.HeaderErr = d.HeaderErr.Error()
+	}
+
+	// Move the original and its bloom filter, replace with the salvaged page
+	bloom := fname[:len(fname)-len(".rcp")] + ".rcb"
+	if err := os.Rename(bloom, filepath.Join(lost, filepath.Base(bloom))); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err := os.Rename(fname, filepath.Join(lost, base)); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, fname); err != nil {
+		return nil, err
+	}
+	return rp, nil
+}
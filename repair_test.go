@@ -0,0 +1,141 @@
+package rumcask
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Repair", func() {
+
+	var corrupt = func(name string, offset int64, data ...byte) {
+		file, err := os.OpenFile(filepath.Join(testDir, name), os.O_WRONLY, 0)
+		Expect(err).NotTo(HaveOccurred())
+		defer file.Close()
+		_, err = file.WriteAt(data, offset)
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	BeforeEach(func() {
+		db, err := OpenWithOptions(testDir, NewHashKeyStore(), &Options{BloomFalsePositiveRate: 0.01})
+		Expect(err).NotTo(HaveOccurred())
+		for _, kv := range [][2]string{{"key1", "val1"}, {"key2", "val2"}, {"key3", "val3"}, {"key4", "val4"}} {
+			_, err := db.Set([]byte(kv[0]), []byte(kv[1]))
+			Expect(err).NotTo(HaveOccurred())
+		}
+		_, err = db.Delete([]byte("key4"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(db.nextPage()).To(Succeed())
+		_, err = db.Set([]byte("key5"), []byte("val5"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(db.Close()).To(Succeed())
+	})
+
+	It("should salvage readable records", func() {
+		corrupt("00000000.rcp", 144+10, 'X')  // value of key2
+		corrupt("00000000.rcp", 160, 0xff, 1) // key length of key3
+		corrupt("00000001.rcp", 144, 1, 2, 3) // torn write
+
+		report, err := Repair(testDir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.LostFound).To(HavePrefix(filepath.Join(testDir, "lost+found") + "/"))
+		Expect(report.Pages).To(Equal([]RepairedPage{
+			{ID: 0, Salvaged: 2, Dropped: []uint32{144}, Skipped: 32},
+			{ID: 1, Salvaged: 1, Dropped: []uint32{144}, Skipped: 3},
+		}))
+
+		lost, _ := filepath.Glob(filepath.Join(report.LostFound, "*"))
+		Expect(lost).To(ConsistOf(
+			filepath.Join(report.LostFound, "00000000.rcp"),
+			filepath.Join(report.LostFound, "00000000.rcb"),
+			filepath.Join(report.LostFound, "00000001.rcp"),
+			filepath.Join(report.LostFound, "00000001.rcb"),
+			filepath.Join(report.LostFound, "CHECKPOINT"),
+			filepath.Join(report.LostFound, "REPORT"),
+		))
+
+		keys := NewHashKeyStore()
+		db, err := Open(testDir, keys)
+		Expect(err).NotTo(HaveOccurred())
+		defer db.Close()
+
+		Expect(keys.refs).To(Equal(map[string]PageRef{
+			"key1": {ID: 0, Offset: 128},
+			"key5": {ID: 1, Offset: 128},
+		}))
+		Expect(db.Get([]byte("key1"))).To(Equal([]byte("val1")))
+		Expect(db.pages[0].header.Stats).To(Equal(PageStats{Written: 2}))
+
+		vr, err := db.Verify(nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(vr.OK()).To(BeTrue())
+	})
+
+	It("should make persistent key stores rebuild", func() {
+		corrupt("00000000.rcp", 144+10, 'X') // value of key2
+
+		_, err := Repair(testDir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(filepath.Join(testDir, rebuildKeysName)).To(BeARegularFile())
+
+		// Claims to be in sync, but holds refs of the original page
+		keys := &staleKeyStore{HashKeyStore: NewHashKeyStore(), pos: PageRef{ID: 1, Offset: 144}}
+		keys.Store([]byte("key3"), PageRef{ID: 0, Offset: 160})
+
+		db, err := Open(testDir, keys)
+		Expect(err).NotTo(HaveOccurred())
+		defer db.Close()
+
+		Expect(keys.resets).To(Equal(1))
+		Expect(keys.refs).To(HaveKeyWithValue("key3", PageRef{ID: 0, Offset: 144}))
+		Expect(db.Get([]byte("key3"))).To(Equal([]byte("val3")))
+		Expect(filepath.Join(testDir, rebuildKeysName)).NotTo(BeAnExistingFile())
+	})
+
+	It("should replace bad headers", func() {
+		corrupt("00000001.rcp", 0, 'X')
+
+		report, err := Repair(testDir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.Pages).To(Equal([]RepairedPage{
+			{ID: 1, HeaderErr: ERROR_PAGE_BAD_HEADER.Error(), Salvaged: 1},
+		}))
+
+		db, err := Open(testDir, NewHashKeyStore())
+		Expect(err).NotTo(HaveOccurred())
+		defer db.Close()
+		Expect(db.Get([]byte("key5"))).To(Equal([]byte("val5")))
+	})
+
+	It("should leave intact DBs alone", func() {
+		report, err := Repair(testDir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.LostFound).To(BeEmpty())
+		Expect(report.Pages).To(BeEmpty())
+		Expect(filepath.Join(testDir, "lost+found")).NotTo(BeADirectory())
+		Expect(filepath.Join(testDir, "CHECKPOINT")).To(BeARegularFile())
+	})
+
+	It("should require exclusive access", func() {
+		db, err := Open(testDir, NewHashKeyStore())
+		Expect(err).NotTo(HaveOccurred())
+		defer db.Close()
+
+		_, err = Repair(testDir)
+		Expect(err).To(Equal(ERROR_DB_LOCKED))
+	})
+
+})
+
+// A persistent store, which always claims to be in sync
+type staleKeyStore struct {
+	*HashKeyStore
+	pos    PageRef
+	resets int
+}
+
+func (s *staleKeyStore) Checkpoint() (PageRef, bool) { return s.pos, true }
+func (s *staleKeyStore) Commit(pos PageRef) error    { s.pos = pos; return nil }
+func (s *staleKeyStore) Reset() error                { s.resets++; return s.HashKeyStore.Reset() }
@@ -0,0 +1,315 @@
+package rumcask
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Replication protocol
+//
+// The follower opens a connection and sends a handshake with its
+// log position. The leader streams frames of raw page data from that
+// position, the follower acknowledges each applied frame with its new
+// position:
+//
+//	handshake: magic(7) flags(1) page(4) offset(4)
+//	data:      type(1) page(4) offset(4) len(4) data(len)
+//	heartbeat: type(1) page(4) offset(4) lag(8)
+//	error:     type(1) code(4)
+//	ack:       page(4) offset(4)
+//
+// Data frames contain whole records only. A data frame for a new page,
+// at the page header offset, signals a page rotation.
+var _REPL_MAGIC = []byte{'R', 'U', 'M', 'C', 'R', 'E', 'P'}
+
+const (
+	replFrameData      byte = 1
+	replFrameHeartbeat byte = 2
+	replFrameError     byte = 3
+
+	// Flag set by followers without any records
+	replFlagEmpty byte = 1
+
+	// Maximum data frame size, unless a single record is larger
+	replChunkSize = 1 * MiB
+)
+
+// FollowerStats contains the replication state of a connected
+// follower, as seen by the leader
+type FollowerStats struct {
+	// Remote address of the follower
+	Addr string
+	// Position acknowledged by the follower
+	Applied Position
+	// Number of bytes the follower is behind
+	Lag int64
+}
+
+// A follower connection on the leader side
+type replica struct {
+	addr    string
+	applied Position
+	lock    sync.Mutex
+}
+
+func (r *replica) ack(pos Position) {
+	r.lock.Lock()
+	r.applied = pos
+	r.lock.Unlock()
+}
+
+func (r *replica) position() Position {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.applied
+}
+
+// ServeReplication accepts follower connections on l and streams
+// page data to them. It blocks until the listener fails or the DB
+// is closed, which also closes the listener.
+func (db *DB) ServeReplication(l net.Listener) error {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-db.closer:
+			l.Close()
+		case <-done:
+		}
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			select {
+			case <-db.closer:
+				return nil
+			default:
+				return err
+			}
+		}
+		go db.serveFollower(conn)
+	}
+}
+
+// Followers returns the stats of all connected followers
+func (db *DB) Followers() []FollowerStats {
+	db.rLock.Lock()
+	defer db.rLock.Unlock()
+
+	stats := make([]FollowerStats, 0, len(db.replicas))
+	for r := range db.replicas {
+		applied := r.position()
+		stats = append(stats, FollowerStats{Addr: r.addr, Applied: applied, Lag: db.distance(applied)})
+	}
+	return stats
+}
+
+// Streams page data to a single follower
+func (db *DB) serveFollower(conn net.Conn) {
+	defer conn.Close()
+
+	pos, err := db.readHandshake(conn)
+	if err != nil {
+		if code, ok := err.(Error); ok {
+			writeReplError(conn, code)
+		}
+		return
+	}
+
+	r := &replica{addr: conn.RemoteAddr().String(), applied: pos}
+	db.rLock.Lock()
+	if db.replicas == nil {
+		db.replicas = make(map[*replica]struct{})
+	}
+	db.replicas[r] = struct{}{}
+	db.rLock.Unlock()
+
+	defer func() {
+		db.rLock.Lock()
+		delete(db.replicas, r)
+		db.rLock.Unlock()
+	}()
+
+	// Read acknowledgements
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+
+		buf := make([]byte, 8)
+		for {
+			if _, err := io.ReadFull(conn, buf); err != nil {
+				return
+			}
+			r.ack(Position{ID: binLE.Uint32(buf[0:]), Offset: binLE.Uint32(buf[4:])})
+		}
+	}()
+
+	ticker := time.NewTicker(db.opts.ReplicationHeartbeat)
+	defer ticker.Stop()
+
+	w := bufio.NewWriter(conn)
+	for {
+		wait := db.notifier()
+		sealed := db.currentID() != pos.ID
+
+		page := db.acquirePage(pos.ID)
+		if page == nil {
+			writeReplError(w, ERROR_BAD_POSITION)
+			w.Flush()
+			return
+		}
+
+		end := page.pos()
+		if pos.Offset < end {
+			data, err := readChunk(page, pos.Offset, end)
+			page.release()
+			if err != nil {
+				return
+			}
+			if err := writeReplData(w, pos, data); err != nil {
+				return
+			}
+			pos.Offset += uint32(len(data))
+			continue
+		}
+		page.release()
+
+		if sealed {
+			pos = Position{ID: db.nextPageID(pos.ID), Offset: PAGE_HEADER_LEN}
+			if err := writeReplData(w, pos, nil); err != nil {
+				return
+			}
+			continue
+		}
+
+		select {
+		case <-wait:
+		case <-ticker.C:
+			if err := writeReplHeartbeat(w, db.Position(), db.distance(r.position())); err != nil {
+				return
+			}
+		case <-gone:
+			return
+		case <-db.closer:
+			return
+		}
+	}
+}
+
+// Reads and validates a follower handshake, returns the
+// start position
+func (db *DB) readHandshake(conn net.Conn) (Position, error) {
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	defer conn.SetReadDeadline(time.Time{})
+
+	buf := make([]byte, len(_REPL_MAGIC)+9)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return Position{}, err
+	}
+	if !bytes.Equal(buf[:len(_REPL_MAGIC)], _REPL_MAGIC) {
+		return Position{}, ERROR_REPLICATION
+	}
+
+	rest := buf[len(_REPL_MAGIC):]
+	pos := Position{ID: binLE.Uint32(rest[1:]), Offset: binLE.Uint32(rest[5:])}
+	if rest[0]&replFlagEmpty != 0 {
+		pos = Position{ID: db.firstPageID(), Offset: PAGE_HEADER_LEN}
+	}
+
+	page := db.page(pos.ID)
+	if page == nil || pos.Offset < PAGE_HEADER_LEN || pos.Offset > page.pos() {
+		return Position{}, ERROR_BAD_POSITION
+	}
+	return pos, nil
+}
+
+// Returns the number of log bytes after pos
+func (db *DB) distance(pos Position) int64 {
+	db.pLock.RLock()
+	defer db.pLock.RUnlock()
+
+	var n int64
+	for id, page := range db.pages {
+		switch {
+		case id == pos.ID:
+			n += int64(page.pos()) - int64(pos.Offset)
+		case id > pos.ID:
+			n += int64(page.pos()) - PAGE_HEADER_LEN
+		}
+	}
+	return n
+}
+
+// Reads whole records from [from, end), up to replChunkSize
+// bytes unless the first record is larger
+func readChunk(page *Page, from, end uint32) ([]byte, error) {
+	size := end - from
+	if size > replChunkSize {
+		size = replChunkSize
+	}
+
+	buf := make([]byte, size)
+	if _, err := page.file.ReadAt(buf, int64(from)); err != nil {
+		return nil, err
+	}
+
+	n := 0
+	for n+OH_KV <= len(buf) {
+		rlen := recordLen(buf[n:])
+		if n+rlen > len(buf) {
+			break
+		}
+		n += rlen
+	}
+	if n != 0 {
+		return buf[:n], nil
+	}
+
+	buf = make([]byte, recordLen(buf))
+	if _, err := page.file.ReadAt(buf, int64(from)); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// Returns the full length of the record, which starts with
+// the given length prefix
+func recordLen(lens []byte) int {
+	klen := int(binLE.Uint16(lens[0:]))
+	vlen := int(binLE.Uint32(lens[OH_KEY:]) &^ (1 << 31)) // strip deletion marker
+	return OH_FULL + klen + vlen
+}
+
+func writeReplData(w *bufio.Writer, pos Position, data []byte) error {
+	buf := make([]byte, 13)
+	buf[0] = replFrameData
+	binLE.PutUint32(buf[1:], pos.ID)
+	binLE.PutUint32(buf[5:], pos.Offset)
+	binLE.PutUint32(buf[9:], uint32(len(data)))
+	w.Write(buf)
+	w.Write(data)
+	return w.Flush()
+}
+
+func writeReplHeartbeat(w *bufio.Writer, pos Position, lag int64) error {
+	buf := make([]byte, 17)
+	buf[0] = replFrameHeartbeat
+	binLE.PutUint32(buf[1:], pos.ID)
+	binLE.PutUint32(buf[5:], pos.Offset)
+	binLE.PutUint64(buf[9:], uint64(lag))
+	w.Write(buf)
+	return w.Flush()
+}
+
+func writeReplError(w io.Writer, code Error) error {
+	buf := make([]byte, 5)
+	buf[0] = replFrameError
+	binLE.PutUint32(buf[1:], uint32(int32(code)))
+	_, err := w.Write(buf)
+	return err
+}
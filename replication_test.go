@@ -0,0 +1,184 @@
+package rumcask
+
+import (
+	"bytes"
+	"net"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Replication", func() {
+	var leader *DB
+	var follower *Follower
+	var listener net.Listener
+	var opts = &Options{ReplicationHeartbeat: 10 * time.Millisecond, ReplicationRetry: 10 * time.Millisecond}
+
+	var set = func(key, value string) {
+		_, err := leader.Set([]byte(key), []byte(value))
+		Expect(err).NotTo(HaveOccurred())
+	}
+	var replicated = func(key string) func() string {
+		return func() string {
+			val, _ := follower.Get([]byte(key))
+			return string(val)
+		}
+	}
+	var serve = func(addr string) {
+		var err error
+		leader, err = OpenWithOptions(filepath.Join(testDir, "leader"), NewHashKeyStore(), opts)
+		Expect(err).NotTo(HaveOccurred())
+		listener, err = net.Listen("tcp", addr)
+		Expect(err).NotTo(HaveOccurred())
+		go leader.ServeReplication(listener)
+	}
+	var follow = func() {
+		var err error
+		follower, err = Follow(filepath.Join(testDir, "follower"), NewHashKeyStore(), listener.Addr().String(), opts)
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	BeforeEach(func() {
+		serve("127.0.0.1:0")
+		set("key1", "val1")
+		set("key2", "val2")
+		Expect(leader.nextPage()).NotTo(HaveOccurred())
+		set("key3", "val3")
+		follow()
+	})
+
+	AfterEach(func() {
+		follower.Close()
+		leader.Close()
+	})
+
+	It("should replicate history and live writes", func() {
+		Eventually(replicated("key1")).Should(Equal("val1"))
+		Eventually(replicated("key3")).Should(Equal("val3"))
+
+		large := bytes.Repeat([]byte{'x'}, replChunkSize+100)
+		_, err := leader.Set([]byte("large"), large)
+		Expect(err).NotTo(HaveOccurred())
+		set("key2", "valX")
+		_, err = leader.Delete([]byte("key1"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(leader.nextPage()).NotTo(HaveOccurred())
+		set("key4", "val4")
+
+		Eventually(replicated("key4")).Should(Equal("val4"))
+		Expect(replicated("key2")()).To(Equal("valX"))
+		Expect(replicated("key1")()).To(BeEmpty())
+		Expect(follower.Get([]byte("large"))).To(Equal(large))
+
+		_, err = follower.DB().Set([]byte("key5"), []byte("val5"))
+		Expect(err).To(Equal(ERROR_READ_ONLY))
+	})
+
+	It("should report lag", func() {
+		Eventually(replicated("key3")).Should(Equal("val3"))
+		Eventually(func() ReplicaStats {
+			stats := follower.Stats()
+			stats.LastContact = time.Time{}
+			return stats
+		}).Should(Equal(ReplicaStats{
+			Connected: true,
+			Leader:    leader.Position(),
+			Applied:   leader.Position(),
+		}))
+
+		stats := leader.Followers()
+		Expect(stats).To(HaveLen(1))
+		Expect(stats[0].Applied).To(Equal(leader.Position()))
+		Expect(stats[0].Lag).To(Equal(int64(0)))
+		Expect(leader.distance(Position{ID: 0, Offset: PAGE_HEADER_LEN})).To(Equal(int64(48)))
+	})
+
+	It("should catch up after a disconnect", func() {
+		Eventually(replicated("key3")).Should(Equal("val3"))
+		addr := listener.Addr().String()
+		Expect(leader.Close()).NotTo(HaveOccurred())
+		Eventually(func() bool { return follower.Stats().Connected }).Should(BeFalse())
+
+		serve(addr)
+		set("key4", "val4")
+		Eventually(replicated("key4")).Should(Equal("val4"))
+		Expect(follower.Stats().Reconnects).To(BeNumerically(">=", 1))
+	})
+
+	It("should resume after a restart", func() {
+		Eventually(replicated("key3")).Should(Equal("val3"))
+		Expect(follower.Close()).NotTo(HaveOccurred())
+
+		set("key4", "val4")
+		follow()
+		Eventually(replicated("key4")).Should(Equal("val4"))
+		Expect(follower.Get([]byte("key1"))).To(Equal([]byte("val1")))
+		Expect(follower.DB().Position()).To(Equal(leader.Position()))
+	})
+
+	It("should replicate with read-only options", func() {
+		ro := *opts
+		ro.ReadOnly = true
+		other, err := Follow(filepath.Join(testDir, "other"), NewHashKeyStore(), listener.Addr().String(), &ro)
+		Expect(err).NotTo(HaveOccurred())
+		defer other.Close()
+
+		Eventually(func() string {
+			val, _ := other.Get([]byte("key3"))
+			return string(val)
+		}).Should(Equal("val3"))
+		Expect(other.Stats().Err).NotTo(HaveOccurred())
+
+		_, err = other.DB().Set([]byte("key5"), []byte("val5"))
+		Expect(err).To(Equal(ERROR_READ_ONLY))
+	})
+
+	It("should validate replicated data before writing", func() {
+		db, err := Open(filepath.Join(testDir, "other"), NewHashKeyStore())
+		Expect(err).NotTo(HaveOccurred())
+		defer db.Close()
+
+		record := func(key, value string) []byte {
+			data := make([]byte, OH_FULL+len(key)+len(value))
+			binLE.PutUint16(data[0:], uint16(len(key)))
+			binLE.PutUint32(data[OH_KEY:], uint32(len(value)))
+			copy(data[OH_KV:], key)
+			copy(data[OH_KV+len(key):], value)
+			binLE.PutUint16(data[len(data)-OH_CSUM:], CRC16(data[OH_KV:len(data)-OH_CSUM]))
+			return data
+		}
+		valid := append(record("key1", "val1"), record("key2", "val2")...)
+		corrupt := append(record("key1", "val1"), record("key2", "val2")...)
+		corrupt[len(corrupt)-3] = 'X'
+
+		pos := db.Position()
+		Expect(db.applyReplicated(pos, corrupt)).To(Equal(ERROR_BAD_CHECKSUM))
+		Expect(db.applyReplicated(pos, valid[:len(valid)-1])).To(Equal(ERROR_REPLICATION))
+		Expect(db.Position()).To(Equal(pos))
+		_, err = db.Get([]byte("key1"))
+		Expect(err).To(Equal(ERROR_NOT_FOUND))
+
+		Expect(db.applyReplicated(pos, valid)).To(Succeed())
+		Expect(db.Position()).To(Equal(Position{ID: pos.ID, Offset: pos.Offset + uint32(len(valid))}))
+		Expect(db.Get([]byte("key2"))).To(Equal([]byte("val2")))
+	})
+
+	It("should reject diverged followers", func() {
+		Expect(follower.Close()).NotTo(HaveOccurred())
+
+		db, err := Open(filepath.Join(testDir, "other"), NewHashKeyStore())
+		Expect(err).NotTo(HaveOccurred())
+		for i := 0; i < 10; i++ {
+			_, err = db.Set([]byte("other"), []byte("value"))
+			Expect(err).NotTo(HaveOccurred())
+		}
+		Expect(db.Close()).NotTo(HaveOccurred())
+
+		follower, err = Follow(filepath.Join(testDir, "other"), NewHashKeyStore(), listener.Addr().String(), opts)
+		Expect(err).NotTo(HaveOccurred())
+		Eventually(func() error { return follower.Stats().Err }).Should(Equal(ERROR_BAD_POSITION))
+	})
+
+})
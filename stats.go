@@ -0,0 +1,238 @@
+package rumcask
+
+import "sync/atomic"
+
+// Maximum number of superseded records, which are queued
+// between Stats calls. Sizes are recalculated if exceeded.
+const maxDeadRecords = 1 << 16
+
+// Stats contains DB-level statistics
+type Stats struct {
+	// Number of pages
+	Pages int
+	// Total size of all pages, including headers
+	TotalBytes int64
+	// Size of the records referenced by the key store and
+	// of all other records, including tombstones. Both are
+	// zero unless the key store is an UnorderedIterator.
+	LiveBytes, DeadBytes int64
+	// Number of keys, zero unless the key store is a Counter
+	Keys int
+
+	// ID, size and fill ratio of the current page
+	CurrentPage     uint32
+	CurrentPageSize uint32
+	CurrentPageFill float64
+
+	// Operation counters since the DB was opened
+	Gets, Sets, Deletes uint64
+	// Number of gets which did not find the key
+	Misses uint64
+	// Number of reads which failed checksum validation
+	ChecksumFailures uint64
+	// Number of times a new current page was started
+	PageRotations uint64
+}
+
+// Stats returns DB-level statistics. The first call reads the size
+// of every record referenced by the key store, later calls only read
+// the sizes of records which were superseded since. Reads and writes
+// are not blocked while sizes are read.
+//
+// At most 65536 superseded records are queued between calls, beyond
+// that the next call reads the sizes of all records again. If the
+// queue overflows while sizes are read, LiveBytes and DeadBytes are
+// reported as zero and recalculated by the next call.
+func (db *DB) Stats() (*Stats, error) {
+	stats := &Stats{
+		Gets:             atomic.LoadUint64(&db.gets),
+		Sets:             atomic.LoadUint64(&db.sets),
+		Deletes:          atomic.LoadUint64(&db.deletes),
+		Misses:           atomic.LoadUint64(&db.misses),
+		ChecksumFailures: atomic.LoadUint64(&db.badChecksums),
+		PageRotations:    atomic.LoadUint64(&db.rotations),
+	}
+	if store, ok := db.keys.(Counter); ok {
+		stats.Keys = store.Len()
+	}
+
+	if err := db.updateLive(); err != nil {
+		return nil, err
+	}
+
+	db.cLock.Lock()
+	defer db.cLock.Unlock()
+
+	db.pLock.RLock()
+	defer db.pLock.RUnlock()
+
+	for _, page := range db.pages {
+		stats.TotalBytes += int64(page.pos())
+	}
+	stats.Pages = len(db.pages)
+	stats.CurrentPage = db.current.id
+	stats.CurrentPageSize = db.current.pos()
+	stats.CurrentPageFill = float64(stats.CurrentPageSize) / MAX_PAGE_SIZE
+
+	if db.liveKnown {
+		stats.LiveBytes = db.live
+		stats.DeadBytes = stats.TotalBytes - db.live - int64(stats.Pages)*PAGE_HEADER_LEN
+	}
+	return stats, nil
+}
+
+// Initialises live bytes from a snapshot of the key store, if needed,
+// subtracts superseded records. Neither the key store is iterated nor
+// are sizes read while holding cLock.
+func (db *DB) updateLive() error {
+	iter, ok := db.keys.(UnorderedIterator)
+	if !ok {
+		return nil
+	}
+
+	db.sLock.Lock()
+	defer db.sLock.Unlock()
+
+	// Records written from start on are tracked as they are
+	// written, earlier ones are collected from the snapshot
+	db.cLock.Lock()
+	scan, start := !db.liveKnown, db.position()
+	if scan {
+		db.live, db.liveKnown = 0, true
+	}
+	gen := db.liveGen
+	db.cLock.Unlock()
+
+	var recs []pageRecord
+	if scan {
+		iter.ForEach(func(_ []byte, ref PageRef) bool {
+			if ref.before(start) {
+				if page := db.acquirePage(ref.ID); page != nil {
+					recs = append(recs, pageRecord{page: page, offset: ref.Offset})
+				}
+			}
+			return true
+		})
+	}
+
+	db.cLock.Lock()
+	if gen != db.liveGen {
+		db.cLock.Unlock()
+		releaseRecords(recs)
+		return nil // reset in the meantime
+	}
+	dead := db.dead
+	db.dead = nil
+	db.cLock.Unlock()
+
+	if scan {
+		recs, dead = reconcileRecords(recs, dead, start)
+	}
+	added, err1 := sumRecords(recs)
+	removed, err2 := sumRecords(dead)
+
+	db.cLock.Lock()
+	defer db.cLock.Unlock()
+
+	if gen != db.liveGen {
+		return nil // reset in the meantime
+	} else if err1 != nil || err2 != nil {
+		db.resetLive(false)
+		if err1 != nil {
+			return err1
+		}
+		return err2
+	}
+	db.live += added - removed
+	return nil
+}
+
+// Adds the size of a new record. Requires cLock.
+func (db *DB) trackLive(key, value []byte) {
+	if db.liveKnown {
+		db.live += int64(len(key)+len(value)) + OH_FULL
+	}
+}
+
+// Queues a superseded record, its size is subtracted by the
+// next Stats call. Requires cLock.
+func (db *DB) untrackLive(ref PageRef) {
+	if !db.liveKnown {
+		return
+	} else if len(db.dead) == maxDeadRecords {
+		db.resetLive(false)
+		return
+	}
+
+	if page := db.acquirePage(ref.ID); page != nil {
+		db.dead = append(db.dead, pageRecord{page: page, offset: ref.Offset})
+	}
+}
+
+// Discards queued records. Live bytes are zero if known,
+// or recalculated by the next Stats call. Requires cLock.
+func (db *DB) resetLive(known bool) {
+	releaseRecords(db.dead)
+	db.live, db.liveKnown, db.dead = 0, known && db.liveKnown, nil
+	db.liveGen++
+}
+
+// A record of an acquired page
+type pageRecord struct {
+	page   *Page
+	offset uint32
+}
+
+func (r pageRecord) ref() PageRef { return PageRef{r.page.id, r.offset} }
+
+// Reconciles a snapshot of live records with the records superseded
+// while it was taken. Records written before start and superseded
+// since may or may not be part of the snapshot, they are dropped from
+// both. Releases the pages of dropped records.
+func reconcileRecords(recs, dead []pageRecord, start PageRef) ([]pageRecord, []pageRecord) {
+	superseded := make(map[PageRef]struct{})
+	n := 0
+	for _, rec := range dead {
+		if ref := rec.ref(); ref.before(start) {
+			superseded[ref] = struct{}{}
+			rec.page.release()
+		} else {
+			dead[n] = rec
+			n++
+		}
+	}
+	dead = dead[:n]
+
+	n = 0
+	for _, rec := range recs {
+		if _, ok := superseded[rec.ref()]; ok {
+			rec.page.release()
+		} else {
+			recs[n] = rec
+			n++
+		}
+	}
+	return recs[:n], dead
+}
+
+// Releases the pages of records
+func releaseRecords(recs []pageRecord) {
+	for _, rec := range recs {
+		rec.page.release()
+	}
+}
+
+// Sums the sizes of records, releases their pages
+func sumRecords(recs []pageRecord) (int64, error) {
+	var sum int64
+	var err error
+	for _, rec := range recs {
+		if err == nil {
+			var n int
+			n, err = rec.page.recordSize(rec.offset)
+			sum += int64(n)
+		}
+		rec.page.release()
+	}
+	return sum, err
+}
@@ -0,0 +1,184 @@
+package rumcask
+
+import (
+	"sync"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Stats", func() {
+	var subject *DB
+
+	var set = func(key, value string) {
+		_, err := subject.Set([]byte(key), []byte(value))
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	var stats = func() *Stats {
+		stats, err := subject.Stats()
+		Expect(err).NotTo(HaveOccurred())
+		return stats
+	}
+
+	BeforeEach(func() {
+		var err error
+		subject, err = Open(testDir, NewHashKeyStore())
+		Expect(err).NotTo(HaveOccurred())
+
+		set("key1", "val1")
+		set("key2", "val2")
+		set("key3", "val3")
+	})
+
+	AfterEach(func() {
+		subject.Close()
+	})
+
+	It("should calculate sizes", func() {
+		Expect(stats()).To(Equal(&Stats{
+			Pages:           1,
+			TotalBytes:      176,
+			LiveBytes:       48,
+			Keys:            3,
+			CurrentPageSize: 176,
+			CurrentPageFill: 176.0 / MAX_PAGE_SIZE,
+			Sets:            3,
+		}))
+	})
+
+	It("should track live and dead bytes", func() {
+		Expect(stats().LiveBytes).To(Equal(int64(48)))
+
+		set("key2", "value2")
+		_, err := subject.Delete([]byte("key3"))
+		Expect(err).NotTo(HaveOccurred())
+
+		s := stats()
+		Expect(s.TotalBytes).To(Equal(int64(128 + 48 + 18 + 12)))
+		Expect(s.LiveBytes).To(Equal(int64(16 + 18)))
+		Expect(s.DeadBytes).To(Equal(int64(16 + 16 + 12)))
+		Expect(s.Keys).To(Equal(2))
+
+		Expect(subject.nextPage()).To(Succeed())
+		Expect(subject.Compact()).To(Succeed())
+
+		s = stats()
+		Expect(s.Pages).To(Equal(1))
+		Expect(s.CurrentPage).To(Equal(uint32(1)))
+		Expect(s.LiveBytes).To(Equal(int64(34)))
+		Expect(s.DeadBytes).To(Equal(int64(0)))
+		Expect(s.PageRotations).To(Equal(uint64(1)))
+	})
+
+	It("should defer size reads of superseded records", func() {
+		Expect(stats().LiveBytes).To(Equal(int64(48)))
+
+		set("key1", "value1")
+		Expect(subject.dead).To(HaveLen(1))
+		Expect(subject.live).To(Equal(int64(48 + 18)))
+
+		Expect(stats().LiveBytes).To(Equal(int64(50)))
+		Expect(subject.dead).To(BeEmpty())
+	})
+
+	It("should not block writers while reading sizes", func() {
+		Expect(subject.Close()).To(Succeed())
+		store := &hookedKeyStore{HashKeyStore: NewHashKeyStore()}
+		var err error
+		subject, err = Open(testDir, store)
+		Expect(err).NotTo(HaveOccurred())
+
+		store.hook = func() {
+			done := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				set("key1", "value1")
+				set("key4", "val4")
+				set("key4", "value4")
+				_, err := subject.Delete([]byte("key2"))
+				Expect(err).NotTo(HaveOccurred())
+				close(done)
+			}()
+			Eventually(done).Should(BeClosed())
+		}
+
+		s := stats()
+		Expect(s.LiveBytes).To(Equal(int64(18 + 16 + 18)))
+		Expect(s.DeadBytes).To(Equal(s.TotalBytes - 128 - s.LiveBytes))
+		Expect(subject.dead).To(BeEmpty())
+	})
+
+	It("should reset live bytes on clear", func() {
+		Expect(stats().LiveBytes).To(Equal(int64(48)))
+		Expect(subject.Clear()).To(Succeed())
+		Expect(stats().LiveBytes).To(Equal(int64(0)))
+		Expect(stats().DeadBytes).To(Equal(int64(48 + 36)))
+	})
+
+	It("should count operations", func() {
+		_, err := subject.Get([]byte("key1"))
+		Expect(err).NotTo(HaveOccurred())
+		_, err = subject.Get([]byte("missing"))
+		Expect(err).To(Equal(ERROR_NOT_FOUND))
+		_, err = subject.Delete([]byte("key2"))
+		Expect(err).NotTo(HaveOccurred())
+
+		s := stats()
+		Expect(s.Gets).To(Equal(uint64(2)))
+		Expect(s.Misses).To(Equal(uint64(1)))
+		Expect(s.Sets).To(Equal(uint64(3)))
+		Expect(s.Deletes).To(Equal(uint64(1)))
+		Expect(s.ChecksumFailures).To(Equal(uint64(0)))
+	})
+
+	It("should count checksum failures", func() {
+		_, err := subject.current.file.WriteAt([]byte{'X'}, 128+10)
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = subject.Get([]byte("key1"))
+		Expect(err).To(Equal(ERROR_BAD_CHECKSUM))
+		Expect(stats().ChecksumFailures).To(Equal(uint64(1)))
+	})
+
+	It("should be safe for concurrent use", func() {
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+
+				for j := 0; j < 50; j++ {
+					set("key2", "value")
+					subject.Get([]byte("key1"))
+					_, err := subject.Stats()
+					Expect(err).NotTo(HaveOccurred())
+				}
+			}()
+		}
+		wg.Wait()
+
+		s := stats()
+		Expect(s.Gets).To(Equal(uint64(200)))
+		Expect(s.Sets).To(Equal(uint64(203)))
+		Expect(s.LiveBytes).To(Equal(int64(16 + 17 + 16)))
+	})
+
+})
+
+// A key store, which calls hook once while iterating
+type hookedKeyStore struct {
+	*HashKeyStore
+	hook func()
+}
+
+func (s *hookedKeyStore) ForEach(each Iterator) {
+	s.HashKeyStore.ForEach(func(key []byte, ref PageRef) bool {
+		if hook := s.hook; hook != nil {
+			s.hook = nil
+			hook()
+		}
+		return each(key, ref)
+	})
+}
@@ -0,0 +1,191 @@
+package rumcask
+
+import (
+	"io"
+	"time"
+)
+
+// VerifyOptions can be passed to Verify
+type VerifyOptions struct {
+	// BytesPerSecond limits the read rate, to reduce
+	// the impact on a live DB.
+	// Default: 0 (unlimited)
+	BytesPerSecond int64
+}
+
+// PageReport is the result of verifying a single page
+type PageReport struct {
+	ID uint32
+	// Number of verified bytes, the size of the page
+	// at the start of the check
+	Size uint32
+	// HeaderErr is set if the page header is invalid
+	HeaderErr error
+	// Number of valid records
+	Records int
+	// Offsets of invalid records
+	Corrupt []uint32
+	// Number of bytes skipped after invalid records
+	Skipped int64
+}
+
+// RefError reports a key, which does not point to a valid record
+type RefError struct {
+	Key []byte
+	Ref PageRef
+	Err error
+}
+
+// VerifyReport is the result of Verify
+type VerifyReport struct {
+	Pages []PageReport
+	// Total number of valid and invalid records
+	Records, Corrupt int
+	// Number of checked keys
+	Keys int
+	// Keys with invalid refs
+	BadRefs []RefError
+}
+
+// OK returns true if no problems were found
+func (r *VerifyReport) OK() bool {
+	if r.Corrupt != 0 || len(r.BadRefs) != 0 {
+		return false
+	}
+	for _, p := range r.Pages {
+		if p.HeaderErr != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Verify reads every record of every page, validates page headers
+// and checksums and checks that each key of the key store points
+// to a valid record for that key. Keys are only checked if the
+// key store is an UnorderedIterator. Problems are collected in
+// the report, an error is only returned if pages cannot be read.
+//
+// Verify runs online, it covers all records written before it
+// was started and does not block other operations.
+func (db *DB) Verify(opts *VerifyOptions) (*VerifyReport, error) {
+	var limit *rateLimiter
+	if opts != nil && opts.BytesPerSecond > 0 {
+		limit = &rateLimiter{rate: opts.BytesPerSecond, start: time.Now()}
+	}
+
+	_, pages := db.snapshotPages()
+	defer releasePages(pages)
+
+	report := new(VerifyReport)
+	for _, ps := range pages {
+		pr, err := verifyPage(ps, limit)
+		if err != nil {
+			return nil, err
+		}
+		report.Pages = append(report.Pages, *pr)
+		report.Records += pr.Records
+		report.Corrupt += len(pr.Corrupt)
+	}
+
+	if iter, ok := db.keys.(UnorderedIterator); ok {
+		keys, refs := collectRefs(iter)
+		for i, key := range keys {
+			if err := db.verifyRef(key, refs[i], limit); err != nil {
+				report.BadRefs = append(report.BadRefs, RefError{Key: key, Ref: refs[i], Err: err})
+			}
+		}
+		report.Keys = len(keys)
+	}
+	return report, nil
+}
+
+// Reads all records of a page snapshot
+func verifyPage(ps pageSnapshot, limit *rateLimiter) (*PageReport, error) {
+	pr := &PageReport{ID: ps.page.id, Size: ps.size}
+
+	var r io.ReaderAt = io.NewSectionReader(ps.page.file, 0, int64(ps.size))
+	if limit != nil {
+		r = &limitedReaderAt{r: r, limit: limit}
+	}
+
+	var header pageHeader
+	if err := header.read(r); err == io.EOF {
+		pr.HeaderErr = ERROR_PAGE_BAD_HEADER
+	} else if err != nil {
+		pr.HeaderErr = err
+	}
+
+	s := newRecordScanner(r, int64(ps.size))
+	for {
+		rec, ok := s.next()
+		if !ok {
+			break
+		}
+		if rec.Valid {
+			pr.Records++
+		} else {
+			pr.Corrupt = append(pr.Corrupt, rec.Offset)
+			pr.Skipped += int64(rec.Skipped)
+		}
+	}
+	return pr, s.err
+}
+
+// Checks that ref points to a valid record for key. Keys
+// which were modified since ref was collected are ignored.
+func (db *DB) verifyRef(key []byte, ref PageRef, limit *rateLimiter) error {
+	var err error
+	if page := db.acquirePage(ref.ID); page == nil {
+		err = ERROR_BAD_OFFSET
+	} else {
+		defer page.release()
+
+		cmp := db.match
+		if cmp == nil {
+			cmp = BytewiseComparator
+		}
+
+		var val []byte
+		val, err = page.readMatching(key, ref.Offset, cmp)
+		if limit != nil {
+			limit.wait(len(key) + len(val) + OH_FULL)
+		}
+	}
+
+	if err != nil {
+		if cur, ok := db.keys.Fetch(key); !ok || cur != ref {
+			return nil
+		}
+	}
+	return err
+}
+
+// --------------------------------------------------------------------
+
+// Limits reads to a number of bytes per second
+type rateLimiter struct {
+	rate  int64
+	start time.Time
+	total int64
+}
+
+// Accounts for n bytes, sleeps if the rate is exceeded
+func (l *rateLimiter) wait(n int) {
+	l.total += int64(n)
+	due := time.Duration(float64(l.total) / float64(l.rate) * float64(time.Second))
+	if delay := due - time.Since(l.start); delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+type limitedReaderAt struct {
+	r     io.ReaderAt
+	limit *rateLimiter
+}
+
+func (r *limitedReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := r.r.ReadAt(p, off)
+	r.limit.wait(n)
+	return n, err
+}
@@ -0,0 +1,101 @@
+package rumcask
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Verify", func() {
+	var subject *DB
+
+	var set = func(key, value string) {
+		_, err := subject.Set([]byte(key), []byte(value))
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	var corrupt = func(name string, offset int64, data ...byte) {
+		file, err := os.OpenFile(filepath.Join(testDir, name), os.O_WRONLY, 0)
+		Expect(err).NotTo(HaveOccurred())
+		defer file.Close()
+		_, err = file.WriteAt(data, offset)
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	BeforeEach(func() {
+		var err error
+		subject, err = Open(testDir, NewHashKeyStore())
+		Expect(err).NotTo(HaveOccurred())
+
+		set("key1", "val1")
+		set("key2", "val2")
+		set("key3", "val3")
+		Expect(subject.nextPage()).To(Succeed())
+		set("key2", "valX")
+		_, err = subject.Delete([]byte("key3"))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		subject.Close()
+	})
+
+	It("should verify intact DBs", func() {
+		report, err := subject.Verify(nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.OK()).To(BeTrue())
+		Expect(report.Pages).To(Equal([]PageReport{
+			{ID: 0, Size: 176, Records: 3},
+			{ID: 1, Size: 156, Records: 2},
+		}))
+		Expect(report.Records).To(Equal(5))
+		Expect(report.Corrupt).To(Equal(0))
+		Expect(report.Keys).To(Equal(2))
+		Expect(report.BadRefs).To(BeEmpty())
+	})
+
+	It("should report all problems", func() {
+		corrupt("00000000.rcp", 0, 'X')       // header
+		corrupt("00000000.rcp", 128+10, 'X')  // value of key1
+		corrupt("00000000.rcp", 144, 0xff, 1) // key length of stale key2
+
+		report, err := subject.Verify(nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.OK()).To(BeFalse())
+		Expect(report.Pages[0]).To(Equal(PageReport{
+			ID:        0,
+			Size:      176,
+			HeaderErr: ERROR_PAGE_BAD_HEADER,
+			Records:   1,
+			Corrupt:   []uint32{128},
+			Skipped:   32,
+		}))
+		Expect(report.Records).To(Equal(3))
+		Expect(report.Corrupt).To(Equal(1))
+		Expect(report.BadRefs).To(Equal([]RefError{
+			{Key: []byte("key1"), Ref: PageRef{ID: 0, Offset: 128}, Err: ERROR_BAD_CHECKSUM},
+		}))
+	})
+
+	It("should report refs to deleted records", func() {
+		subject.keys.Store([]byte("key3"), PageRef{ID: 0, Offset: 160})
+
+		report, err := subject.Verify(nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.BadRefs).To(Equal([]RefError{
+			{Key: []byte("key3"), Ref: PageRef{ID: 0, Offset: 160}, Err: ERROR_NOT_FOUND},
+		}))
+	})
+
+	It("should limit the read rate", func() {
+		start := time.Now()
+		report, err := subject.Verify(&VerifyOptions{BytesPerSecond: 2000})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.OK()).To(BeTrue())
+		Expect(time.Since(start)).To(BeNumerically(">", 100*time.Millisecond))
+	})
+
+})